@@ -3,23 +3,49 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/pkg/ctxconfig"
 	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/history"
+	"github.com/superops-team/hyperops/pkg/inventory"
 	"github.com/superops-team/hyperops/pkg/ops"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/event"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"github.com/superops-team/hyperops/pkg/version"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// exitFailed 脚本执行失败
+	exitFailed = 1
+	// exitInvalidParams 脚本参数不合法, 脚本没有执行
+	exitInvalidParams = 2
+	// exitLimitExceeded 超出资源限制, job被取消
+	exitLimitExceeded = 3
+)
+
+// exitCode ExecuteApply返回错误时进程的退出码
+func exitCode(err error) int {
+	var paramErr *ops.ParamError
+	if errors.As(err, &paramErr) {
+		return exitInvalidParams
+	}
+	if localctx.ExceededLimit(err) != "" {
+		return exitLimitExceeded
+	}
+	return exitFailed
+}
+
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "hyperops apply [flags]",
@@ -31,6 +57,18 @@ var applyCmd = &cobra.Command{
 			fmt.Println(err)
 			os.Exit(-1)
 		}
+		if viper.GetBool("help-params") {
+			if err := printParams(target); err != nil {
+				fmt.Println(err)
+				os.Exit(exitInvalidParams)
+			}
+			return
+		}
+		params, err := parseSetFlags(viper.GetStringSlice("set"))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitInvalidParams)
+		}
 
 		env := environment.NewEnvStorage()
 		err = environment.InitEnvironmentVariables(env)
@@ -50,6 +88,11 @@ var applyCmd = &cobra.Command{
 		jobId := viper.GetString("id")
 		jobName := viper.GetString("name")
 		if jobId == "" {
+			// 随机的id无法找到上一次的checkpoint
+			if viper.GetBool("resume") {
+				fmt.Println("--resume requires the --id of the interrupted job")
+				os.Exit(exitInvalidParams)
+			}
 			u, _ := uuid.NewRandom()
 			jobId = u.String()
 		}
@@ -60,22 +103,20 @@ var applyCmd = &cobra.Command{
 		ctxConfigFile := viper.GetString("ctxconfig")
 		debugFlag := viper.GetBool("debug")
 		ctxMap := map[string]interface{}{}
-		if debugFlag {
-			ctxMap["HYPEROPS_WORKSPACE_KEEP"] = true
-		}
+		var secrets map[string]string
 		if ctxConfigFile != "" {
-			buf, err := ioutil.ReadFile(ctxConfigFile)
-			if err != nil {
-				return
-			}
-
-			err = yaml.Unmarshal(buf, &ctxMap)
+			// 配置中的ENC[...]加密值解密后同时作为密码, 输出时会被替换
+			ctxMap, secrets, err = ctxconfig.Load(ctxConfigFile, viper.GetString("config-key-file"))
 			if err != nil {
-				return
+				fmt.Println(err)
+				os.Exit(-1)
 			}
 		}
+		if debugFlag {
+			ctxMap["HYPEROPS_WORKSPACE_KEEP"] = true
+		}
 
-		ExecuteApply(
+		err = ExecuteApply(
 			target,
 			viper.GetString("file"),
 			jobName,
@@ -83,48 +124,50 @@ var applyCmd = &cobra.Command{
 			viper.GetString("tags"),
 			viper.GetInt("timeout"),
 			ctxMap,
+			secrets,
+			params,
 		)
+		if err != nil {
+			os.Exit(exitCode(err))
+		}
 	},
 }
 
-func ExecuteApply(target *ops.Target, jobFile string, jobName string, jobId string, jobTags string, timeout int, ctxMap map[string]interface{}) {
-	ctx := context.Background()
-	eventCh := make(chan event.Event)
-	done := make(chan struct{})
-	var wg sync.WaitGroup
-	// async log receive
+// ExecuteApply 执行脚本并保存执行记录, 错误已经输出, 返回的错误用于设置进程的退出码
+func ExecuteApply(target *ops.Target, jobFile string, jobName string, jobId string, jobTags string, timeout int, ctxMap map[string]interface{}, secrets map[string]string, params map[string]string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := event.NewBus(ctx)
+	debug := viper.GetBool("debug")
 	// 执行print(msg) 函数调用的所有日志会走到这个地方
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		debug := viper.GetBool("debug")
-		for {
-			select {
-			case ev := <-eventCh:
-				if ev.Type == event.ETPrint {
-					payload := ev.Payload.(event.PrintEvent)
-					fmt.Printf("%s\n", payload.Msg)
-				}
-				if ev.Type == event.ETTask {
-					payload := ev.Payload.(event.TaskEvent)
-					if debug {
-						fmt.Printf("job status change (%s -> %s)\n", payload.From, payload.To)
-					}
-				}
-				if ev.Type == event.ETData {
-					payload := ev.Payload.(event.DataEvent)
-					s, _ := json.MarshalIndent(payload.Data, "", "\t")
-					if debug {
-						fmt.Printf("job data: \n%s\n", s)
-					}
-				}
-			case <-done:
-				return
-			}
-		}
-	}()
-
-	defer wg.Wait()
+	bus.SubscribeTypes(func(_ context.Context, ev event.Event) error {
+		payload := ev.Payload.(event.PrintEvent)
+		fmt.Printf("%s\n", payload.Msg)
+		return nil
+	}, event.ETPrint)
+	var recorder *history.Recorder
+	if !viper.GetBool("no-history") {
+		recorder = history.NewRecorder(&history.Record{
+			ID:         jobId,
+			Name:       jobName,
+			Tags:       jobTags,
+			ScriptPath: jobFile,
+		})
+		recorder.Subscribe(bus)
+	}
+	if debug {
+		bus.SubscribeTypes(func(_ context.Context, ev event.Event) error {
+			payload := ev.Payload.(event.TaskEvent)
+			fmt.Printf("job status change (%s -> %s)\n", payload.From, payload.To)
+			return nil
+		}, event.ETTask)
+		bus.SubscribeTypes(func(_ context.Context, ev event.Event) error {
+			payload := ev.Payload.(event.DataEvent)
+			s, _ := json.MarshalIndent(payload.Data, "", "\t")
+			fmt.Printf("job data: \n%s\n", s)
+			return nil
+		}, event.ETData)
+	}
 
 	v := version.GetVersion()
 	cfg := map[string]interface{}{
@@ -141,20 +184,154 @@ func ExecuteApply(target *ops.Target, jobFile string, jobName string, jobId stri
 		}
 	}
 
+	limits, err := limitsFromFlags()
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
 	opts := []func(*ops.ExecOpts){
-		ops.AddEventsChannel(eventCh),
+		ops.SetEventBus(bus),
 		ops.SetLocals(cfg),
+		ops.SetSecrets(secrets),
 		ops.SetTimeout(time.Duration(timeout) * time.Second),
+		ops.SetParams(params),
+		ops.SetLimits(limits.MaxSteps, limits.MaxOutputBytes, limits.MaxShellStdout, limits.MaxHeapBytes),
+		ops.SetSecretProviders(secretProvidersFromFlags()),
+	}
+	if viper.GetBool("dry-run") {
+		stubs, err := loadDryRunStubs(viper.GetString("dry-run-stubs"))
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		opts = append(opts, ops.SetDryRun(stubs))
+	}
+	if viper.GetBool("resume") {
+		opts = append(opts, ops.SetResume())
+	}
+	if root := viper.GetString("module-root"); root != "" {
+		opts = append(opts, ops.SetModuleRoot(root))
+	}
+	if file := viper.GetString("inventory"); file != "" {
+		inv, err := inventory.Load(file)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		opts = append(opts, ops.SetInventory(inv))
+	}
+	if file := viper.GetString("sandbox"); file != "" {
+		policy, err := sandbox.Load(file)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		opts = append(opts, ops.SetSandbox(policy))
 	}
 
-	err := ops.ExecScript(ctx, target, opts...)
+	fixtures, err := fixturesFromFlags(jobFile)
 	if err != nil {
 		fmt.Println(err.Error())
+		return err
+	}
+	if fixtures != nil {
+		opts = append(opts, ops.SetFixtures(fixtures))
 	}
 
-	done <- struct{}{}
-	// time.Sleep(time.Second)
-	close(eventCh)
+	err = ops.ExecScript(ctx, target, opts...)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	// 回放时没有被调用的录制同样视为失败
+	if fixtures != nil {
+		if fixturesErr := finishFixtures(fixtures); err == nil {
+			err = fixturesErr
+		}
+	}
+	if recorder != nil {
+		if err := saveHistory(recorder.Finish(err)); err != nil {
+			fmt.Printf("save job history failed: %s\n", err.Error())
+		}
+	}
+	return err
+}
+
+// parseSetFlags 解析--set key=value形式的脚本参数
+func parseSetFlags(values []string) (map[string]string, error) {
+	params := make(map[string]string, len(values))
+	for _, kv := range values {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("invalid --set %s, should be key=value", kv)
+		}
+		params[pair[0]] = pair[1]
+	}
+	return params, nil
+}
+
+// printParams 输出脚本声明的参数
+func printParams(target *ops.Target) error {
+	decls, err := ops.ParseParams(target.ScriptPath, target.ScriptContent)
+	if err != nil {
+		return err
+	}
+	ops.FormatParams(os.Stdout, decls)
+	return nil
+}
+
+// loadDryRunStubs 读取dry-run自定义返回值, yaml格式: 函数名 -> 返回值
+func loadDryRunStubs(file string) (map[string]interface{}, error) {
+	if file == "" {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	stubs := map[string]interface{}{}
+	if err := yaml.Unmarshal(buf, &stubs); err != nil {
+		return nil, err
+	}
+	return stubs, nil
+}
+
+// fixturesFromFlags --record录制shell/http/fs调用的结果, --replay回放录制的结果
+func fixturesFromFlags(script string) (*localctx.Fixtures, error) {
+	record, replay := viper.GetString("record"), viper.GetString("replay")
+	switch {
+	case record != "" && replay != "":
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
+	case record != "" && viper.GetBool("dry-run"):
+		return nil, fmt.Errorf("--record and --dry-run cannot be used together")
+	case record != "":
+		return localctx.NewFixtures(script), nil
+	case replay != "":
+		return localctx.LoadFixtures(replay)
+	}
+	return nil, nil
+}
+
+// finishFixtures 保存录制的调用, 即使脚本执行失败; 回放时有没被调用的录制返回错误
+func finishFixtures(fixtures *localctx.Fixtures) error {
+	if !fixtures.Replaying() {
+		file := viper.GetString("record")
+		if err := fixtures.Save(file); err != nil {
+			fmt.Printf("save fixtures failed: %s\n", err.Error())
+			return err
+		}
+		fmt.Printf("recorded %d calls to %s\n", len(fixtures.Calls), file)
+		return nil
+	}
+	unreplayed := fixtures.Unreplayed()
+	if len(unreplayed) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("replay: %d recorded calls were not issued", len(unreplayed))
+	fmt.Println(err.Error())
+	for _, call := range unreplayed {
+		fmt.Printf("  %s(args=%s, kwargs=%s)\n", call.Name, call.Args, call.Kwargs)
+	}
+	return err
 }
 
 func init() {
@@ -188,5 +365,35 @@ func init() {
 	applyCmd.PersistentFlags().StringArrayP("env", "e", []string{}, "Environment variables.")
 	BindViper(applyCmd.PersistentFlags(), "env")
 
+	applyCmd.PersistentFlags().Bool("dry-run", false, "only print the side-effecting calls instead of executing them")
+	BindViper(applyCmd.PersistentFlags(), "dry-run")
+
+	applyCmd.PersistentFlags().String("dry-run-stubs", "", "yaml file of stub results by func name in dry-run mode, --dry-run-stubs=stubs.yaml")
+	BindViper(applyCmd.PersistentFlags(), "dry-run-stubs")
+
+	applyCmd.PersistentFlags().String("record", "", "record the shell/http/fs calls and their results to the file, --record=fixtures.yaml")
+	BindViper(applyCmd.PersistentFlags(), "record")
+
+	applyCmd.PersistentFlags().String("replay", "", "return the results recorded by --record instead of running the shell/http/fs calls, --replay=fixtures.yaml")
+	BindViper(applyCmd.PersistentFlags(), "replay")
+
+	applyCmd.PersistentFlags().Bool("resume", false, "resume the job with the same --id, skip the completed steps")
+	BindViper(applyCmd.PersistentFlags(), "resume")
+
+	applyCmd.PersistentFlags().String("inventory", "", "inventory file of hosts and groups, yaml or ini, --inventory=hosts.yaml")
+	BindViper(applyCmd.PersistentFlags(), "inventory")
+
+	applyCmd.PersistentFlags().StringArray("set", []string{}, "script parameters declared by params(), --set replicas=3 --set region=eu")
+	BindViper(applyCmd.PersistentFlags(), "set")
+
+	applyCmd.PersistentFlags().Bool("help-params", false, "print the parameters declared by the script and exit")
+	BindViper(applyCmd.PersistentFlags(), "help-params")
+
+	applyCmd.PersistentFlags().String("module-root", "", "root dir of load(\"//path/to/module.ops\"), default the git repo root of the ops file")
+	BindViper(applyCmd.PersistentFlags(), "module-root")
+
+	applyCmd.PersistentFlags().String("sandbox", "", "sandbox policy yaml limiting the modules, shell, fs and http of the script, --sandbox=restricted.yaml")
+	BindViper(applyCmd.PersistentFlags(), "sandbox")
+
 	RootCmd.AddCommand(applyCmd)
 }
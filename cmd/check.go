@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/superops-team/hyperops/pkg/ops"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "hyperops check -f <opsfile> [flags]",
+	Long:  "hyperops check -f x.ops [--json], check the script and the local modules it loads without running them, exit non-zero when problems are found",
+	Run: func(cmd *cobra.Command, args []string) {
+		files, _ := cmd.Flags().GetStringSlice("file")
+		files = append(files, args...)
+		if len(files) == 0 {
+			_ = cmd.Help()
+			os.Exit(-1)
+		}
+		root, _ := cmd.Flags().GetString("module-root")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		diags := []*ops.Diagnostic{}
+		for _, file := range files {
+			target, err := ops.NewTarget(file)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			found, err := ops.CheckScript(target, ops.SetModuleRoot(root))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			diags = append(diags, found...)
+		}
+		if asJSON {
+			printJSON(diags)
+		} else {
+			for _, d := range diags {
+				fmt.Println(d)
+			}
+		}
+		if len(diags) > 0 {
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	checkCmd.Flags().StringSliceP("file", "f", nil, "ops file to check, can be repeated")
+	checkCmd.Flags().String("module-root", "", "root dir of load(\"//path/to/module.ops\"), default the git repo root of the ops file")
+	checkCmd.Flags().Bool("json", false, "output in json format")
+	RootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/pkg/ctxconfig"
+	"github.com/superops-team/hyperops/pkg/environment"
+	"gopkg.in/yaml.v2"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "hyperops config [command]",
+	Long:  "hyperops config keygen | encrypt <file> | decrypt <file> | edit <file>, manage encrypted values in ctxconfig files",
+}
+
+var configKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "hyperops config keygen [flags]",
+	Long:  "generate a key into --config-key-file, default " + ctxconfig.DefaultKeyFile(),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		file := configKeyFile()
+		if _, err := os.Stat(file); err == nil && !force {
+			fmt.Printf("key file %s already exists, use --force to overwrite\n", file)
+			os.Exit(-1)
+		}
+		key, err := ctxconfig.GenerateKey()
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(file), 0700)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(file, []byte(key.String()+"\n"), 0600)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		fmt.Printf("key written to %s\n", file)
+	},
+}
+
+var configEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "hyperops config encrypt <file> [flags]",
+	Long:  "hyperops config encrypt ctx.yaml --keys='password|token' -i, encrypt all values or the ones whose field matches --keys",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		match, err := keysMatcher(cmd, nil)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		err = rewriteConfig(cmd, args[0], func(data yaml.MapSlice, key ctxconfig.Key) error {
+			return ctxconfig.Encrypt(data, key, match)
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+var configDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "hyperops config decrypt <file> [flags]",
+	Long:  "hyperops config decrypt ctx.yaml, print the decrypted file or decrypt in place with -i",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := rewriteConfig(cmd, args[0], func(data yaml.MapSlice, key ctxconfig.Key) error {
+			_, err := ctxconfig.Decrypt(data, key)
+			return err
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "hyperops config edit <file> [flags]",
+	Long:  "decrypt the file into $EDITOR, the values encrypted before and the fields matching --keys are encrypted again after saving",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := editConfig(cmd, args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+// configKeyFile 密钥文件, 默认为$HOME/.hyperops/config.key
+func configKeyFile() string {
+	if file := viper.GetString("config-key-file"); file != "" {
+		return file
+	}
+	return ctxconfig.DefaultKeyFile()
+}
+
+// keysMatcher 按--keys匹配需要加密的字段, 未指定时encrypted为nil则加密所有字段, 否则只加密encrypted中的路径
+func keysMatcher(cmd *cobra.Command, encrypted map[string]bool) (func(path, name string) bool, error) {
+	keys, _ := cmd.Flags().GetString("keys")
+	var re *regexp.Regexp
+	if keys != "" {
+		var err error
+		if re, err = regexp.Compile(keys); err != nil {
+			return nil, fmt.Errorf("invalid --keys: %w", err)
+		}
+	}
+	return func(path, name string) bool {
+		if encrypted != nil && encrypted[path] {
+			return true
+		}
+		if re != nil {
+			return re.MatchString(name)
+		}
+		return encrypted == nil
+	}, nil
+}
+
+func readConfig(file string) (yaml.MapSlice, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var data yaml.MapSlice
+	if err := yaml.Unmarshal(buf, &data); err != nil {
+		return nil, fmt.Errorf("invalid ctxconfig %s: %w", file, err)
+	}
+	return data, nil
+}
+
+// rewriteConfig 读取配置并使用密钥处理后输出, -i时写回原文件
+func rewriteConfig(cmd *cobra.Command, file string, fn func(data yaml.MapSlice, key ctxconfig.Key) error) error {
+	data, err := readConfig(file)
+	if err != nil {
+		return err
+	}
+	key, err := ctxconfig.LoadKey(viper.GetString("config-key-file"))
+	if err != nil {
+		return err
+	}
+	if err := fn(data, key); err != nil {
+		return err
+	}
+	buf, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if inPlace, _ := cmd.Flags().GetBool("in-place"); inPlace {
+		return ioutil.WriteFile(file, buf, 0600)
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
+
+// editConfig 解密到临时文件, 编辑器退出后重新加密写回
+func editConfig(cmd *cobra.Command, file string) error {
+	data, err := readConfig(file)
+	if err != nil {
+		return err
+	}
+	key, err := ctxconfig.LoadKey(viper.GetString("config-key-file"))
+	if err != nil {
+		return err
+	}
+	encrypted, err := ctxconfig.EncryptedPaths(data)
+	if err != nil {
+		return err
+	}
+	if _, err := ctxconfig.Decrypt(data, key); err != nil {
+		return err
+	}
+	buf, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile("", "hyperops-config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	editor := environment.NewEnvStorage().Get("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	c := exec.Command("sh", "-c", editor+` "$0"`, tmp.Name())
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("run editor %s: %w", editor, err)
+	}
+
+	if data, err = readConfig(tmp.Name()); err != nil {
+		return err
+	}
+	match, err := keysMatcher(cmd, encrypted)
+	if err != nil {
+		return err
+	}
+	if err := ctxconfig.Encrypt(data, key, match); err != nil {
+		return err
+	}
+	if buf, err = yaml.Marshal(data); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0600)
+}
+
+func init() {
+	RootCmd.PersistentFlags().String("config-key-file", "", "key file to decrypt the ENC[...] values in ctxconfig, default $"+ctxconfig.KeyEnv+" or "+ctxconfig.DefaultKeyFile())
+	BindViper(RootCmd.PersistentFlags(), "config-key-file")
+
+	configKeygenCmd.Flags().Bool("force", false, "overwrite the existing key file")
+	configEncryptCmd.Flags().String("keys", "", "regexp of the fields to encrypt, default all")
+	configEditCmd.Flags().String("keys", "", "regexp of the fields to encrypt besides the ones encrypted before")
+	for _, c := range []*cobra.Command{configEncryptCmd, configDecryptCmd} {
+		c.Flags().BoolP("in-place", "i", false, "write the result back to the file instead of stdout")
+	}
+
+	configCmd.AddCommand(configKeygenCmd, configEncryptCmd, configDecryptCmd, configEditCmd)
+	RootCmd.AddCommand(configCmd)
+}
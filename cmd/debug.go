@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/pkg/ctxconfig"
+	"github.com/superops-team/hyperops/pkg/ops"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/debugger"
+	"github.com/superops-team/hyperops/pkg/version"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "hyperops debug -f <opsfile> [flags]",
+	Long:  "hyperops debug -f x.ops --listen=127.0.0.1:4711, wait for a Debug Adapter Protocol client (e.g. VS Code with debugServer) to attach, then run the script with breakpoints, stepping and variable inspection",
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		file, _ := flags.GetString("file")
+		if file == "" {
+			_ = cmd.Help()
+			os.Exit(-1)
+		}
+		listen, _ := flags.GetString("listen")
+		ctxConfigFile, _ := flags.GetString("ctxconfig")
+		sets, _ := flags.GetStringArray("set")
+		root, _ := flags.GetString("module-root")
+		jobID, _ := flags.GetString("id")
+		timeout, _ := flags.GetDuration("timeout")
+
+		target, err := ops.NewTarget(file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		params, err := parseSetFlags(sets)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		ctxMap := map[string]interface{}{}
+		var secrets map[string]string
+		if ctxConfigFile != "" {
+			ctxMap, secrets, err = ctxconfig.Load(ctxConfigFile, viper.GetString("config-key-file"))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		}
+		if jobID == "" {
+			u, _ := uuid.NewRandom()
+			jobID = u.String()
+		}
+		v := version.GetVersion()
+		cfg := map[string]interface{}{
+			"job_id":    jobID,
+			"job_name":  jobID,
+			"version":   v.Version,
+			"buildtime": v.BuildTime,
+		}
+		for k, v := range ctxMap {
+			if _, ok := cfg[k]; !ok {
+				cfg[k] = v
+			}
+		}
+
+		// 只接受一个调试客户端, 连接后不再监听
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		fmt.Printf("debug server listening on %s, waiting for the debugger to attach\n", ln.Addr())
+		conn, err := ln.Accept()
+		_ = ln.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		d := debugger.New()
+		session := debugger.NewSession(conn, d)
+		session.OnTerminate = func() {
+			localctx.GetTaskManager().Kill(jobID)
+		}
+		go func() {
+			if err := session.Serve(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+		select {
+		case <-session.Ready():
+		case <-session.Done():
+			fmt.Println("debugger disconnected before the script started")
+			return
+		}
+
+		err = ops.ExecScript(context.Background(), target,
+			ops.SetLocals(cfg),
+			ops.SetSecrets(secrets),
+			ops.SetParams(params),
+			ops.SetModuleRoot(root),
+			ops.SetTimeout(timeout),
+			ops.SetOutputWriter(io.MultiWriter(os.Stdout, session)),
+			ops.SetDebugger(d),
+		)
+		code := 0
+		if err != nil {
+			fmt.Println(err)
+			session.Output("stderr", err.Error()+"\n")
+			code = 1
+		}
+		session.Exited(code)
+		// 等待客户端收到terminated后断开
+		select {
+		case <-session.Done():
+		case <-time.After(5 * time.Second):
+			_ = conn.Close()
+		}
+		if err != nil {
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	debugCmd.Flags().StringP("file", "f", "", "ops file to debug")
+	debugCmd.Flags().String("listen", "127.0.0.1:4711", "address of the debug adapter server, the debugServer port in VS Code")
+	debugCmd.Flags().StringP("ctxconfig", "c", "", "ctx config, --ctxconfig=ctx_config.yaml")
+	debugCmd.Flags().StringArray("set", []string{}, "script parameters declared by params(), --set replicas=3 --set region=eu")
+	debugCmd.Flags().String("module-root", "", "root dir of load(\"//path/to/module.ops\"), default the git repo root of the ops file")
+	debugCmd.Flags().StringP("id", "i", "", "job id, default auto generate by uuid")
+	debugCmd.Flags().Duration("timeout", 24*time.Hour, "max exec time including the time paused in the debugger")
+	RootCmd.AddCommand(debugCmd)
+}
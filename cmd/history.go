@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/history"
+)
+
+// historyOpenTimeout 存储目录被其他hyperops进程占用时的最长等待时间
+const historyOpenTimeout = 10 * time.Second
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "hyperops history [command]",
+	Long:  "hyperops history list | hyperops history show <jobid>",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "hyperops history list [flags]",
+	Long:  "hyperops history list --limit=20 --name=<jobname> --status=failed",
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		name, _ := cmd.Flags().GetString("name")
+		status, _ := cmd.Flags().GetString("status")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		store, err := openHistory()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		defer store.Close()
+
+		records, err := store.List(history.Filter{Name: name, Status: status, Limit: limit})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if asJSON {
+			printJSON(records)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSTATUS\tSTART\tDURATION")
+		for _, rec := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", rec.ID, rec.Name, rec.Status, rec.StartTime.Format(time.RFC3339), rec.Duration().Round(time.Millisecond))
+		}
+		w.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <jobid>",
+	Short: "hyperops history show <jobid>",
+	Long:  "hyperops history show <jobid> [--json]",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		store, err := openHistory()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		defer store.Close()
+
+		rec, err := store.Get(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if asJSON {
+			printJSON(rec)
+			return
+		}
+		fmt.Printf("id:       %s\n", rec.ID)
+		fmt.Printf("name:     %s\n", rec.Name)
+		fmt.Printf("tags:     %s\n", rec.Tags)
+		fmt.Printf("script:   %s\n", rec.ScriptPath)
+		fmt.Printf("status:   %s\n", rec.Status)
+		if rec.Limit != "" {
+			fmt.Printf("limit:    %s\n", rec.Limit)
+		}
+		fmt.Printf("start:    %s\n", rec.StartTime.Format(time.RFC3339))
+		fmt.Printf("end:      %s\n", rec.EndTime.Format(time.RFC3339))
+		fmt.Printf("duration: %s\n", rec.Duration().Round(time.Millisecond))
+		for _, t := range rec.Transitions {
+			fmt.Printf("status change: %s -> %s\n", t.From, t.To)
+		}
+		if len(rec.Data) > 0 {
+			s, _ := json.MarshalIndent(rec.Data, "", "\t")
+			fmt.Printf("data:\n%s\n", s)
+		}
+		if rec.Error != "" {
+			fmt.Printf("error:\n%s\n", rec.Error)
+		}
+		fmt.Printf("output:\n%s", rec.Output)
+		if rec.Truncated {
+			fmt.Println("... (output truncated)")
+		}
+	},
+}
+
+func printJSON(v interface{}) {
+	s, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(s))
+}
+
+// historyDir 执行记录存储目录, 默认为$HOME/.hyperops/history
+func historyDir() string {
+	if dir := viper.GetString("history-dir"); dir != "" {
+		return dir
+	}
+	env := environment.NewEnvStorage()
+	_ = environment.InitEnvironmentVariables(env)
+	return filepath.Join(env.Get("HOME"), ".hyperops", "history")
+}
+
+func openHistory() (*history.BadgerStore, error) {
+	return history.OpenBadgerStore(historyDir(), historyOpenTimeout)
+}
+
+// saveHistory 保存执行记录, 只在保存时短暂打开存储，避免多个进程同时执行时互相占用
+func saveHistory(rec *history.Record) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Save(rec)
+}
+
+func init() {
+	RootCmd.PersistentFlags().String("history-dir", "", "job history store dir, default $HOME/.hyperops/history")
+	BindViper(RootCmd.PersistentFlags(), "history-dir")
+
+	RootCmd.PersistentFlags().Bool("no-history", false, "do not save job records into history")
+	BindViper(RootCmd.PersistentFlags(), "no-history")
+
+	historyListCmd.Flags().Int("limit", 20, "max records to show")
+	historyListCmd.Flags().String("name", "", "filter records by job name")
+	historyListCmd.Flags().String("status", "", "filter records by status, succeed or failed")
+	historyListCmd.Flags().Bool("json", false, "output in json format")
+	historyShowCmd.Flags().Bool("json", false, "output in json format")
+
+	historyCmd.AddCommand(historyListCmd, historyShowCmd)
+	RootCmd.AddCommand(historyCmd)
+}
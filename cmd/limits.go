@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/internal/http"
+)
+
+// sizeUnits 字节数支持的单位
+var sizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"GB", 1 << 30}, {"G", 1 << 30},
+	{"MB", 1 << 20}, {"M", 1 << 20},
+	{"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize 解析64MB/512K形式的字节数, 不带单位时为字节, 空字符串为0
+func parseSize(s string) (uint64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	unit := uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q, should be like 512K, 64MB or 1G", s)
+	}
+	return n * unit, nil
+}
+
+// limitsFromFlags 读取资源限制参数, apply直接使用, server和schedule作为所有任务的上限
+func limitsFromFlags() (http.Limits, error) {
+	limits := http.Limits{MaxSteps: viper.GetUint64("max-steps")}
+	for name, v := range map[string]*uint64{
+		"max-output":       &limits.MaxOutputBytes,
+		"max-shell-stdout": &limits.MaxShellStdout,
+		"max-heap":         &limits.MaxHeapBytes,
+	} {
+		size, err := parseSize(viper.GetString(name))
+		if err != nil {
+			return limits, fmt.Errorf("--%s: %w", name, err)
+		}
+		*v = size
+	}
+	return limits, nil
+}
+
+// serverLimitsFromFlags server和schedule中所有任务的上限, 堆内存为进程内所有任务共享, 不支持--max-heap
+func serverLimitsFromFlags() (http.Limits, error) {
+	limits, err := limitsFromFlags()
+	if err == nil && limits.MaxHeapBytes > 0 {
+		err = fmt.Errorf("--max-heap only applies to apply, the heap is shared by all the jobs of the server")
+	}
+	return limits, err
+}
+
+func init() {
+	RootCmd.PersistentFlags().Uint64("max-steps", 0, "max starlark execution steps of each thread, 0 for unlimited")
+	BindViper(RootCmd.PersistentFlags(), "max-steps")
+
+	RootCmd.PersistentFlags().String("max-output", "", "max bytes printed by the script, eg --max-output=10MB")
+	BindViper(RootCmd.PersistentFlags(), "max-output")
+
+	RootCmd.PersistentFlags().String("max-shell-stdout", "", "max stdout kept of each shell/ssh call, the rest is truncated, eg --max-shell-stdout=1MB")
+	BindViper(RootCmd.PersistentFlags(), "max-shell-stdout")
+
+	RootCmd.PersistentFlags().String("max-heap", "", "soft heap budget of the apply process, the job is cancelled when the heap exceeds it, eg --max-heap=2G")
+	BindViper(RootCmd.PersistentFlags(), "max-heap")
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/superops-team/hyperops/pkg/mod"
+)
+
+var modCmd = &cobra.Command{
+	Use:   "mod",
+	Short: "hyperops mod [command]",
+	Long:  "hyperops mod init | get <name> <source> | tidy | vendor, manage module packages loaded by load(\"@name//path.ops\")",
+}
+
+var modInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "hyperops mod init [flags]",
+	Long:  "create an empty " + mod.ManifestFile + " in the script dir",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		if err := mod.Init(dir); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+var modGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "hyperops mod get <name> <source> [flags]",
+	Long:  "hyperops mod get netops git+https://github.com/example/netops.git --version=v1.2.0",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		version, _ := cmd.Flags().GetString("version")
+		locked, err := mod.Add(dir, args[0], mod.Require{Source: args[1], Version: version})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		fmt.Printf("locked %s %s %s\n", args[0], locked.Version, locked.Hash)
+	},
+}
+
+var modTidyCmd = &cobra.Command{
+	Use:   "tidy",
+	Short: "hyperops mod tidy [flags]",
+	Long:  "remove the unused module packages, lock the new ones and download the missing ones into cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		result, err := mod.Tidy(dir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		for _, name := range result.Removed {
+			fmt.Printf("removed %s\n", name)
+		}
+		for _, name := range result.Fetched {
+			fmt.Printf("locked %s\n", name)
+		}
+	},
+}
+
+var modVendorCmd = &cobra.Command{
+	Use:   "vendor",
+	Short: "hyperops mod vendor [flags]",
+	Long:  "copy the locked module packages into " + mod.VendorDir + " next to the lockfile",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		if err := mod.Vendor(dir); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	modCmd.PersistentFlags().String("dir", ".", "dir of the scripts, "+mod.ManifestFile+" and "+mod.LockFile)
+	modGetCmd.Flags().String("version", "", "git tag, branch or commit, or the version label of a tarball")
+
+	modCmd.AddCommand(modInitCmd, modGetCmd, modTidyCmd, modVendorCmd)
+	RootCmd.AddCommand(modCmd)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/ops"
+)
+
+// runCmd 支持脚本首行使用 #!/usr/bin/env -S hyperops run, 之后可以直接执行 ./x.ops --replicas 3
+var runCmd = &cobra.Command{
+	Use:                "run",
+	Short:              "hyperops run <opsfile> [--param value ...]",
+	Long:               "hyperops run x.ops --replicas 3 --region=eu, the args after the ops file are mapped to the parameters declared by params()",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+			_ = cmd.Help()
+			return
+		}
+		target, err := ops.NewTarget(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		decls, err := ops.ParseParams(target.ScriptPath, target.ScriptContent)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitInvalidParams)
+		}
+		params, help, err := parseParamArgs(decls, args[1:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(exitInvalidParams)
+		}
+		if help {
+			fmt.Printf("Usage: %s [--param value ...]\n", args[0])
+			ops.FormatParams(os.Stdout, decls)
+			return
+		}
+
+		env := environment.NewEnvStorage()
+		if err := environment.InitEnvironmentVariables(env); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		u, _ := uuid.NewRandom()
+		jobName := strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		if err := ExecuteApply(target, args[0], jobName, u.String(), "", 1000, map[string]interface{}{}, nil, params); err != nil {
+			os.Exit(exitCode(err))
+		}
+	},
+}
+
+// parseParamArgs 将--name value或--name=value形式的命令行参数映射为脚本参数, bool参数可以省略值
+func parseParamArgs(decls []*ops.Param, args []string) (map[string]string, bool, error) {
+	types := make(map[string]string, len(decls))
+	for _, p := range decls {
+		types[p.Name] = p.Type
+	}
+	params := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-h" || arg == "--help" {
+			return nil, true, nil
+		}
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 {
+			return nil, false, fmt.Errorf("invalid argument %s, should be --param value", arg)
+		}
+		name, value := strings.TrimPrefix(arg, "--"), ""
+		kv := strings.SplitN(name, "=", 2)
+		if len(kv) == 2 {
+			name, value = kv[0], kv[1]
+		}
+		// 参数名中的-与_等价, 例如--max-surge对应max_surge
+		if _, ok := types[name]; !ok {
+			if alias := strings.ReplaceAll(name, "-", "_"); types[alias] != "" {
+				name = alias
+			}
+		}
+		if len(kv) == 2 {
+			params[name] = value
+			continue
+		}
+		if types[name] == ops.ParamBool && (i+1 == len(args) || strings.HasPrefix(args[i+1], "--")) {
+			params[name] = "true"
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, false, fmt.Errorf("missing value of --%s", name)
+		}
+		params[name] = args[i+1]
+		i++
+	}
+	return params, false, nil
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/internal/http"
+	"github.com/superops-team/hyperops/internal/schedule"
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/history"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "hyperops schedule [flags]",
+	Long:  "hyperops schedule -f schedules.yaml --addr=127.0.0.1:8088",
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		addr, _ := cmd.Flags().GetString("addr")
+		sandboxDir, _ := cmd.Flags().GetString("sandbox-dir")
+		defaultSandbox, _ := cmd.Flags().GetString("default-sandbox")
+
+		env := environment.NewEnvStorage()
+		err := environment.InitEnvironmentVariables(env)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		manifest, err := schedule.LoadManifest(file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		jm := http.NewJobManager()
+		if !viper.GetBool("no-history") {
+			// 只在保存记录时打开存储, 运行期间可以使用history命令查询
+			jm.SetHistory(history.NewDirStore(historyDir(), historyOpenTimeout))
+		}
+		if err := setupSandboxes(jm, sandboxDir, defaultSandbox); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		limits, err := serverLimitsFromFlags()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		jm.SetLimits(limits)
+		jm.SetSecretProviders(secretProvidersFromFlags())
+
+		scheduler, err := schedule.NewScheduler(manifest, jm)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		scheduler.SetConfigKeyFile(viper.GetString("config-key-file"))
+		scheduler.Start()
+		defer scheduler.Stop()
+
+		fmt.Printf("hyperops schedule %d scripts, listen on %s\n", len(manifest.Schedules), addr)
+		// 只提供定时任务的api, 不能通过调度服务提交或者kill任意脚本
+		if err := http.Serve(addr, scheduler); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	scheduleCmd.Flags().StringP("file", "f", "schedules.yaml", "schedule manifest file, --file=schedules.yaml")
+	scheduleCmd.Flags().String("addr", "127.0.0.1:8088", "http listen address, eg --addr=0.0.0.0:8088")
+	scheduleCmd.Flags().String("sandbox-dir", "", "dir of sandbox policy yaml files, schedules select one by name")
+	scheduleCmd.Flags().String("default-sandbox", "", "sandbox used by the schedules without sandbox, eg --default-sandbox=restricted")
+
+	RootCmd.AddCommand(scheduleCmd)
+}
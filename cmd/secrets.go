@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/pkg/secret"
+)
+
+// secretProvidersFromFlags 根据命令行参数创建密码后端, env始终可用, file和vault在配置后可用
+func secretProvidersFromFlags() secret.Providers {
+	providers := secret.Providers{
+		"env": secret.NewEnv(viper.GetString("secret-env-prefix")),
+	}
+	if file := viper.GetString("secret-file"); file != "" {
+		providers["file"] = secret.NewFile(file)
+	}
+	if addr := viper.GetString("vault-addr"); addr != "" {
+		providers["vault"] = secret.NewVault(addr, viper.GetString("vault-token"), viper.GetInt("vault-kv-version"))
+	}
+	return providers
+}
+
+func init() {
+	RootCmd.PersistentFlags().String("secret-file", "", "dotenv or yaml file for secret://file/<name> references")
+	BindViper(RootCmd.PersistentFlags(), "secret-file")
+
+	RootCmd.PersistentFlags().String("secret-env-prefix", "HYPEROPS_SECRET_", "env prefix for secret://env/<name> references")
+	BindViper(RootCmd.PersistentFlags(), "secret-env-prefix")
+
+	RootCmd.PersistentFlags().String("vault-addr", os.Getenv("VAULT_ADDR"), "vault address for secret://vault/<mount>/<path>#<key> references")
+	BindViper(RootCmd.PersistentFlags(), "vault-addr")
+
+	RootCmd.PersistentFlags().String("vault-token", os.Getenv("VAULT_TOKEN"), "vault token, defaults to $VAULT_TOKEN")
+	BindViper(RootCmd.PersistentFlags(), "vault-token")
+
+	RootCmd.PersistentFlags().Int("vault-kv-version", 2, "version of the vault kv secrets engine, 1 or 2")
+	BindViper(RootCmd.PersistentFlags(), "vault-kv-version")
+}
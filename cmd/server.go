@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/superops-team/hyperops/internal/http"
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/history"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "hyperops server [flags]",
+	Long:  "hyperops server --addr=127.0.0.1:8088",
+	Run: func(cmd *cobra.Command, args []string) {
+		env := environment.NewEnvStorage()
+		err := environment.InitEnvironmentVariables(env)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+
+		jm := http.NewJobManager()
+		if !viper.GetBool("no-history") {
+			// 只在保存记录时打开存储, 运行期间可以使用history命令查询
+			jm.SetHistory(history.NewDirStore(historyDir(), historyOpenTimeout))
+		}
+		if err := setupSandboxes(jm, viper.GetString("sandbox-dir"), viper.GetString("default-sandbox")); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		addr := viper.GetString("addr")
+		if err := setupAPI(jm, addr, viper.GetString("scripts-dir"), viper.GetString("default-sandbox")); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if err := setupAuth(jm, viper.GetString("api-token-file"), viper.GetBool("no-inline-scripts"), viper.GetString("scripts-dir")); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		limits, err := serverLimitsFromFlags()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		jm.SetLimits(limits)
+		jm.SetSecretProviders(secretProvidersFromFlags())
+
+		fmt.Printf("hyperops server listen on %s\n", addr)
+		if err := http.Serve(addr, jm); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+	},
+}
+
+// setupSandboxes 加载目录下的沙箱策略, 提交任务时按名称选择
+func setupSandboxes(jm *http.JobManager, dir, def string) error {
+	if dir == "" {
+		if def != "" {
+			return fmt.Errorf("--default-sandbox requires --sandbox-dir")
+		}
+		return nil
+	}
+	policies, err := sandbox.LoadDir(dir)
+	if err != nil {
+		return err
+	}
+	return jm.SetSandboxes(policies, def)
+}
+
+// setupAPI 设置api提交的路径所在的目录, 监听非本机地址时要求所有任务默认在沙箱中执行
+func setupAPI(jm *http.JobManager, addr, scriptsDir, def string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); def == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("--default-sandbox is required to listen on %s, scripts submitted from other hosts would run unrestricted", addr)
+	}
+	if scriptsDir == "" {
+		return nil
+	}
+	return jm.SetScriptsDir(scriptsDir)
+}
+
+// setupAuth 从文件读取api的bearer token, 并按需关闭脚本内容提交, 未设置token时任何能访问监听地址的用户都可以执行脚本
+func setupAuth(jm *http.JobManager, tokenFile string, noInline bool, scriptsDir string) error {
+	if noInline {
+		if scriptsDir == "" {
+			return fmt.Errorf("--no-inline-scripts requires --scripts-dir, no script could run without it")
+		}
+		jm.DisableInlineScripts()
+	}
+	if tokenFile == "" {
+		fmt.Println("warning: the api is unauthenticated, any user who can reach the listen address can run scripts, set --api-token-file to require a token")
+		return nil
+	}
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("api token file %s is empty", tokenFile)
+	}
+	jm.SetToken(token)
+	return nil
+}
+
+func init() {
+	serverCmd.PersistentFlags().String("addr", "127.0.0.1:8088", "http listen address, eg --addr=0.0.0.0:8088")
+	BindViper(serverCmd.PersistentFlags(), "addr")
+
+	serverCmd.PersistentFlags().String("sandbox-dir", "", "dir of sandbox policy yaml files, jobs select one by name")
+	BindViper(serverCmd.PersistentFlags(), "sandbox-dir")
+
+	serverCmd.PersistentFlags().String("default-sandbox", "", "sandbox used by the jobs without sandbox, eg --default-sandbox=restricted")
+	BindViper(serverCmd.PersistentFlags(), "default-sandbox")
+
+	serverCmd.PersistentFlags().String("scripts-dir", "", "dir of the script_path and inventory files that jobs may use, only inline scripts are accepted without it")
+	BindViper(serverCmd.PersistentFlags(), "scripts-dir")
+
+	serverCmd.PersistentFlags().String("api-token-file", "", "file of the bearer token that api requests must send, the api is unauthenticated without it")
+	BindViper(serverCmd.PersistentFlags(), "api-token-file")
+
+	serverCmd.PersistentFlags().Bool("no-inline-scripts", false, "reject inline scripts, only script_path under --scripts-dir can run")
+	BindViper(serverCmd.PersistentFlags(), "no-inline-scripts")
+
+	RootCmd.AddCommand(serverCmd)
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superops-team/hyperops/pkg/mod"
+	"github.com/superops-team/hyperops/pkg/ops"
+)
+
+// testCmd 与go test一样使用-run和-v这样的单横线参数, 因此自行解析参数
+var testCmd = &cobra.Command{
+	Use:                "test",
+	Short:              "hyperops test [dir/... | dir | x_test.ops] [flags]",
+	Long:               "hyperops test ./... -run 'drain' --junit=report.xml, run the test_* functions of the *_test.ops files, each in an isolated runtime where assert.star can be loaded",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		flags.AddFlagSet(cmd.InheritedFlags())
+		if err := flags.Parse(goStyleArgs(cmd, args)); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if help, _ := flags.GetBool("help"); help {
+			_ = cmd.Help()
+			return
+		}
+		pattern, _ := flags.GetString("run")
+		junit, _ := flags.GetString("junit")
+		verbose, _ := flags.GetBool("verbose")
+		root, _ := flags.GetString("module-root")
+		timeout, _ := flags.GetDuration("timeout")
+
+		var run *regexp.Regexp
+		if pattern != "" {
+			var err error
+			if run, err = regexp.Compile(pattern); err != nil {
+				fmt.Printf("invalid -run: %s\n", err)
+				os.Exit(-1)
+			}
+		}
+		files, err := findTestFiles(flags.Args())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		if len(files) == 0 {
+			fmt.Println("no test files")
+			return
+		}
+
+		var all []*ops.TestCase
+		failed := 0
+		for _, file := range files {
+			target, err := ops.NewTarget(file)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			start := time.Now()
+			cases := ops.RunTests(context.Background(), target, run, ops.SetModuleRoot(root), ops.SetTimeout(timeout))
+			status := "ok"
+			for _, c := range cases {
+				printTestCase(c, verbose)
+				if c.Failed() {
+					status = "FAIL"
+					failed++
+				}
+			}
+			suffix := ""
+			if len(cases) == 0 {
+				suffix = " [no tests to run]"
+			}
+			fmt.Printf("%s\t%s\t%.3fs%s\n", status, file, time.Since(start).Seconds(), suffix)
+			all = append(all, cases...)
+		}
+
+		if junit != "" {
+			if err := writeJUnit(junit, all); err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+		}
+		if failed > 0 {
+			fmt.Printf("FAIL: %d of %d tests failed\n", failed, len(all))
+			os.Exit(-1)
+		}
+	},
+}
+
+// goStyleArgs 将-run=x, -junit x这样的单横线长参数转换为双横线
+func goStyleArgs(cmd *cobra.Command, args []string) []string {
+	ret := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 2 {
+			name := strings.SplitN(arg[1:], "=", 2)[0]
+			if cmd.Flags().Lookup(name) != nil {
+				arg = "-" + arg
+			}
+		}
+		ret = append(ret, arg)
+	}
+	return ret
+}
+
+// findTestFiles 按go test的方式解析路径, dir/...递归查找子目录, 跳过隐藏目录, _开头的目录和hyperops_modules
+func findTestFiles(args []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var files []string
+	seen := map[string]bool{}
+	for _, arg := range args {
+		dir, recursive := arg, false
+		if arg == "..." || strings.HasSuffix(arg, "/...") {
+			dir, recursive = strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/"), true
+			if dir == "" {
+				dir = "."
+			}
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if !seen[dir] {
+				seen[dir] = true
+				files = append(files, dir)
+			}
+			continue
+		}
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				name := info.Name()
+				if path != dir && (!recursive || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == mod.VendorDir) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ops.TestSuffix) && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// printTestCase 失败的测试输出脚本的print和失败信息, -v时输出所有测试
+func printTestCase(c *ops.TestCase, verbose bool) {
+	if !verbose && !c.Failed() {
+		return
+	}
+	status := "PASS"
+	if c.Failed() {
+		status = "FAIL"
+	}
+	if verbose {
+		fmt.Printf("=== RUN   %s\n", c.Name)
+	}
+	fmt.Printf("--- %s: %s (%.3fs)\n", status, c.Name, c.Duration.Seconds())
+	if out := strings.TrimRight(c.Output, "\n"); out != "" {
+		fmt.Println(indent(out))
+	}
+	for _, failure := range c.Failures {
+		fmt.Println(indent(failure))
+	}
+	if c.Err != nil {
+		fmt.Println(indent(c.Err.Error()))
+	}
+}
+
+func indent(s string) string {
+	return "    " + strings.ReplaceAll(s, "\n", "\n    ")
+}
+
+func writeJUnit(file string, cases []*ops.TestCase) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := ops.WriteJUnit(f, cases); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	testCmd.Flags().String("run", "", "run only the test functions matching the regexp")
+	testCmd.Flags().String("junit", "", "write the results to the file in JUnit XML format")
+	testCmd.Flags().BoolP("verbose", "v", false, "print the output of all tests, not only the failed ones")
+	testCmd.Flags().String("module-root", "", "root dir of load(\"//path/to/module.ops\"), default the git repo root of the test file")
+	testCmd.Flags().Duration("timeout", 100*time.Second, "timeout of each test function")
+	RootCmd.AddCommand(testCmd)
+}
@@ -12,18 +12,28 @@ func Index(ctx *fasthttp.RequestCtx) {
 	fmt.Fprint(ctx, "OK!\n")
 }
 
-func APIregist(r *fasthttprouter.Router) {
+// Registrar 注册一组api, 例如JobManager
+type Registrar interface {
+	Register(r *fasthttprouter.Router)
+}
+
+func APIregist(r *fasthttprouter.Router, apis ...Registrar) {
 	r.GET("/", Index)
+
+	for _, api := range apis {
+		api.Register(r)
+	}
 }
 
-func Serve(addr string) {
+func Serve(addr string, apis ...Registrar) error {
 	router := fasthttprouter.New()
-	APIregist(router)
+	APIregist(router, apis...)
 
 	p := NewPrometheus("hyperops")
 	fastpHandler := p.WrapHandler(router)
 
 	if err := fasthttp.ListenAndServe(addr, fastpHandler); err != nil {
-		fasthttp.ListenAndServeUNIX("/var/run/hyperops.sock", os.FileMode(int(0755)), fastpHandler)
+		return fasthttp.ListenAndServeUNIX("/var/run/hyperops.sock", os.FileMode(int(0755)), fastpHandler)
 	}
+	return nil
 }
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superops-team/hyperops/pkg/ops/event"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// maxStreamHistory 每个任务缓存的事件数，新的订阅者会先收到这些历史事件
+	maxStreamHistory = 1000
+	// subscriberBuffer 订阅者的缓冲大小，消费过慢的订阅者会被断开，避免阻塞脚本执行
+	subscriberBuffer = 256
+	// heartbeatInterval sse心跳间隔，用于及时发现断开的连接
+	heartbeatInterval = 15 * time.Second
+)
+
+// streamer 将单个任务的事件扇出给多个订阅者
+type streamer struct {
+	sync.Mutex
+	history []event.Event
+	subs    map[chan event.Event]struct{}
+	closed  bool
+}
+
+func newStreamer() *streamer {
+	return &streamer{
+		subs: make(map[chan event.Event]struct{}),
+	}
+}
+
+// publish 广播事件，不会因为订阅者阻塞
+func (s *streamer) publish(e event.Event) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return
+	}
+	s.history = append(s.history, e)
+	if len(s.history) > maxStreamHistory {
+		s.history = s.history[len(s.history)-maxStreamHistory:]
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe 返回历史事件以及后续事件的接收通道，任务结束后通道会被关闭
+func (s *streamer) subscribe() ([]event.Event, chan event.Event, func()) {
+	s.Lock()
+	defer s.Unlock()
+	history := append([]event.Event(nil), s.history...)
+	ch := make(chan event.Event, subscriberBuffer)
+	if s.closed {
+		close(ch)
+		return history, ch, func() {}
+	}
+	s.subs[ch] = struct{}{}
+	cancel := func() {
+		s.Lock()
+		defer s.Unlock()
+		if _, ok := s.subs[ch]; ok {
+			delete(s.subs, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel
+}
+
+// close 任务结束，关闭所有订阅者
+func (s *streamer) close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// writeSSE 按照server-sent events格式写入一条消息
+func writeSSE(w *bufio.Writer, name string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, buf); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// EventsHandler GET /api/v1/tasks/:id/events
+// 以sse方式推送任务的打印、状态变更以及最终数据事件，可通过types参数过滤事件类型，
+// 任务结束时推送end事件，内容为任务最终状态
+func (m *JobManager) EventsHandler(ctx *fasthttp.RequestCtx) {
+	job := m.Get(jobID(ctx))
+	if job == nil {
+		writeError(ctx, fasthttp.StatusNotFound, ErrJobNotFound)
+		return
+	}
+
+	var filter map[event.Type]bool
+	if types := string(ctx.QueryArgs().Peek("types")); types != "" {
+		filter = map[event.Type]bool{}
+		for _, typ := range strings.Split(types, ",") {
+			filter[event.Type(strings.TrimSpace(typ))] = true
+		}
+	}
+
+	history, ch, cancel := job.events.subscribe()
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("Connection", "keep-alive")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		send := func(e event.Event) error {
+			if filter != nil && !filter[e.Type] {
+				return nil
+			}
+			return writeSSE(w, string(e.Type), e)
+		}
+		for _, e := range history {
+			if err := send(e); err != nil {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					// 消费过慢被断开时任务并未结束，不发送end事件
+					if job.Finished() {
+						_ = writeSSE(w, "end", job.Info())
+					}
+					return
+				}
+				if err := send(e); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := w.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/superops-team/hyperops/pkg/ops"
+	"github.com/valyala/fasthttp"
+)
+
+func TestJobManagerEvents(t *testing.T) {
+	r := fasthttprouter.New()
+	NewJobManager().Register(r)
+
+	script := `
+print("line1")
+sleep("200ms")
+print("line2")
+ctx.set("result", "ok")
+`
+	req, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_events_job",
+		Target: &ops.Target{ScriptContent: []byte(script)},
+		Locals: map[string]interface{}{"HYPEROPS_WORKSPACE_KEEP": false},
+	})
+	ctx := doRequest(r, "POST", "/api/v1/tasks", req)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	// 两个订阅者各自收到完整的事件流
+	for i := 0; i < 2; i++ {
+		ctx = doRequest(r, "GET", "/api/v1/tasks/http_events_job/events", nil)
+		if ct := string(ctx.Response.Header.ContentType()); ct != "text/event-stream" {
+			t.Fatalf("unexpected content type %s", ct)
+		}
+		body := string(ctx.Response.Body())
+		for _, expect := range []string{"event: op:Print", "line1", "line2", "event: op:Task", `"to":"finished"`, "event: op:Data", `"result":"ok"`, "event: end"} {
+			if !strings.Contains(body, expect) {
+				t.Errorf("events mismatch. expected: %q, got: %s", expect, body)
+			}
+		}
+	}
+
+	ctx = doRequest(r, "GET", "/api/v1/tasks/http_events_job/events?types=op:Task", nil)
+	body := string(ctx.Response.Body())
+	if strings.Contains(body, "op:Print") || !strings.Contains(body, "op:Task") {
+		t.Errorf("events filter mismatch. got: %s", body)
+	}
+}
@@ -0,0 +1,676 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/google/uuid"
+	"github.com/superops-team/hyperops/pkg/history"
+	"github.com/superops-team/hyperops/pkg/inventory"
+	"github.com/superops-team/hyperops/pkg/ops"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/event"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"github.com/superops-team/hyperops/pkg/secret"
+	"github.com/superops-team/hyperops/pkg/version"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// maxFinishedJobs 最多保留的已结束任务记录数，避免常驻进程内存无限增长
+	maxFinishedJobs = 1024
+	// defaultJobTimeout 未指定超时时间时的默认执行时长(秒)
+	defaultJobTimeout = 1000
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobExists      = errors.New("job with the same id is still running")
+	ErrJobNotRunning  = errors.New("job is not running")
+	ErrInvalidTarget  = errors.New("invalid target, script_path or file is required")
+	ErrInvalidRequest = errors.New("invalid request body")
+	ErrNoSandbox      = errors.New("sandbox not found")
+	// ErrHeapLimit 堆内存为进程内所有任务共享, 不能作为单个任务的限制
+	ErrHeapLimit = errors.New("max_heap_bytes is not supported on the server, the heap is shared by all the jobs")
+	// ErrPathNotAllowed api提交的脚本和主机清单路径不在服务端的脚本目录下
+	ErrPathNotAllowed = errors.New("path is not allowed, script_path and inventory must be under the server scripts dir")
+	// ErrInlineScript 服务端关闭了脚本内容提交, 只能执行脚本目录下的文件
+	ErrInlineScript = errors.New("inline scripts are disabled, submit a script_path under the server scripts dir")
+	// ErrUnauthorized 请求未携带正确的bearer token
+	ErrUnauthorized = errors.New("unauthorized, a valid bearer token is required")
+)
+
+// SubmitRequest 提交脚本执行的请求体
+type SubmitRequest struct {
+	ID        string                 `json:"id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Tags      string                 `json:"tags,omitempty"`
+	Target    *ops.Target            `json:"target"`
+	Locals    map[string]interface{} `json:"locals,omitempty"`
+	Secrets   map[string]string      `json:"secrets,omitempty"`
+	Timeout   int                    `json:"timeout,omitempty"`   // 超时时间(秒)
+	Resume    bool                   `json:"resume,omitempty"`    // 根据checkpoint恢复执行, 需要指定与上次相同的id
+	Inventory string                 `json:"inventory,omitempty"` // 服务端本地的主机清单文件路径
+	Params    map[string]string      `json:"params,omitempty"`    // 脚本参数, 按params()的声明校验
+	Sandbox   string                 `json:"sandbox,omitempty"`   // 服务端配置的沙箱策略名称, 未指定时使用默认策略
+	Limits    Limits                 `json:"limits,omitempty"`    // 资源限制, 不能超过服务端设置的上限
+}
+
+// Limits 任务的资源限制, 0表示不限制
+type Limits struct {
+	MaxSteps       uint64 `json:"max_steps,omitempty"`
+	MaxOutputBytes uint64 `json:"max_output_bytes,omitempty"`
+	MaxShellStdout uint64 `json:"max_shell_stdout,omitempty"`
+	MaxHeapBytes   uint64 `json:"max_heap_bytes,omitempty"`
+}
+
+// within 使用不超过max的限制, max中为0的项不限制
+func (l Limits) within(max Limits) Limits {
+	clamp := func(v, max uint64) uint64 {
+		if max > 0 && (v == 0 || v > max) {
+			return max
+		}
+		return v
+	}
+	return Limits{
+		MaxSteps:       clamp(l.MaxSteps, max.MaxSteps),
+		MaxOutputBytes: clamp(l.MaxOutputBytes, max.MaxOutputBytes),
+		MaxShellStdout: clamp(l.MaxShellStdout, max.MaxShellStdout),
+		MaxHeapBytes:   clamp(l.MaxHeapBytes, max.MaxHeapBytes),
+	}
+}
+
+// JobInfo 任务对外展示的状态信息
+type JobInfo struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Tags       string `json:"tags,omitempty"`
+	ScriptPath string `json:"script_path,omitempty"`
+	Status     string `json:"status"`
+	StartTime  int64  `json:"start_time"`
+	EndTime    int64  `json:"end_time,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Limit      string `json:"limit,omitempty"` // 因超出资源限制失败时触发的限制
+}
+
+// Job 通过api提交的任务记录，结束后仍然保留输出用于查询
+type Job struct {
+	sync.Mutex
+	ID         string
+	Name       string
+	Tags       string
+	ScriptPath string
+	StartTime  time.Time
+	EndTime    time.Time
+	Err        error
+	finished   bool
+	done       chan struct{}
+	kill       context.CancelFunc // 取消执行, 任务尚未注册到TaskManager时也能生效
+	output     bytes.Buffer
+	events     *streamer
+}
+
+// Write 实现io.Writer，作为任务的OutputWriter并发安全地缓存输出
+func (j *Job) Write(p []byte) (int, error) {
+	j.Lock()
+	defer j.Unlock()
+	return j.output.Write(p)
+}
+
+// Output 返回当前缓存的输出快照
+func (j *Job) Output() []byte {
+	j.Lock()
+	defer j.Unlock()
+	return append([]byte(nil), j.output.Bytes()...)
+}
+
+// Finished 任务是否执行结束
+func (j *Job) Finished() bool {
+	j.Lock()
+	defer j.Unlock()
+	return j.finished
+}
+
+// Done 任务结束后关闭的channel
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) finish(err error) {
+	j.Lock()
+	defer j.Unlock()
+	j.finished = true
+	j.EndTime = time.Now()
+	j.Err = err
+	close(j.done)
+}
+
+// Info 生成任务状态快照，执行中的任务状态取自TaskManager
+func (j *Job) Info() *JobInfo {
+	j.Lock()
+	defer j.Unlock()
+	info := &JobInfo{
+		ID:         j.ID,
+		Name:       j.Name,
+		Tags:       j.Tags,
+		ScriptPath: j.ScriptPath,
+		Status:     string(localctx.PendingStatus),
+		StartTime:  j.StartTime.Unix(),
+	}
+	if j.finished {
+		info.Status = string(localctx.FinishedStatus)
+		info.EndTime = j.EndTime.Unix()
+		if j.Err != nil {
+			info.Error = j.Err.Error()
+			info.Limit = localctx.ExceededLimit(j.Err)
+		}
+		return info
+	}
+	if status, ok := localctx.GetTaskManager().Status(j.ID); ok {
+		info.Status = string(status)
+	}
+	return info
+}
+
+// JobManager 管理通过api提交的任务，任务的挂起恢复等控制委托给TaskManager
+type JobManager struct {
+	sync.Mutex
+	jobs     map[string]*Job
+	finished []string // 按结束顺序记录的任务ID，用于淘汰
+	history  history.Store
+
+	sandboxes      map[string]*sandbox.Policy // 可供任务选择的沙箱策略
+	defaultSandbox string                     // 未指定沙箱时使用的策略, 为空时不限制
+	limits         Limits                     // 所有任务的资源限制上限
+	providers      secret.Providers           // 解析任务secrets和ctx配置中secret://引用的密码后端
+	scriptsDir     string                     // api提交的script_path和inventory所在的目录, 为空时只能提交脚本内容
+	noInline       bool                       // 拒绝提交脚本内容, 只能执行脚本目录下的文件
+	token          []byte                     // api的bearer token, 为空时不认证
+}
+
+// NewJobManager 创建任务管理器
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs: make(map[string]*Job),
+	}
+}
+
+// SetHistory 设置执行记录存储，任务结束后保存执行记录
+func (m *JobManager) SetHistory(store history.Store) {
+	m.Lock()
+	defer m.Unlock()
+	m.history = store
+}
+
+// SetSandboxes 设置可供任务选择的沙箱策略, def不为空时所有未指定沙箱的任务都使用该策略
+func (m *JobManager) SetSandboxes(policies map[string]*sandbox.Policy, def string) error {
+	if _, ok := policies[def]; def != "" && !ok {
+		return fmt.Errorf("%w: %s", ErrNoSandbox, def)
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.sandboxes = policies
+	m.defaultSandbox = def
+	return nil
+}
+
+// SetLimits 设置任务资源限制的上限, 提交任务时未指定或超出的限制使用该值
+func (m *JobManager) SetLimits(limits Limits) {
+	m.Lock()
+	defer m.Unlock()
+	m.limits = limits
+}
+
+// SetSecretProviders 设置密码后端, 任务的secrets和locals中可以使用secret://引用
+func (m *JobManager) SetSecretProviders(providers secret.Providers) {
+	m.Lock()
+	defer m.Unlock()
+	m.providers = providers
+}
+
+// SetScriptsDir 设置api提交的script_path和inventory所在的目录, 相对路径基于该目录
+func (m *JobManager) SetScriptsDir(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.scriptsDir = real
+	return nil
+}
+
+// DisableInlineScripts 拒绝api提交的脚本内容, 只能执行脚本目录下的script_path
+func (m *JobManager) DisableInlineScripts() {
+	m.Lock()
+	defer m.Unlock()
+	m.noInline = true
+}
+
+// SetToken 设置api的bearer token, 设置后所有任务api都需要携带Authorization: Bearer <token>
+func (m *JobManager) SetToken(token string) {
+	m.Lock()
+	defer m.Unlock()
+	m.token = []byte(token)
+}
+
+// authorize 校验请求的bearer token, 未设置token时不认证
+func (m *JobManager) authorize(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		m.Lock()
+		token := m.token
+		m.Unlock()
+		if len(token) > 0 {
+			auth := ctx.Request.Header.Peek("Authorization")
+			prefix := []byte("Bearer ")
+			if !bytes.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare(auth[len(prefix):], token) != 1 {
+				ctx.Response.Header.Set("WWW-Authenticate", "Bearer")
+				writeError(ctx, fasthttp.StatusUnauthorized, ErrUnauthorized)
+				return
+			}
+		}
+		h(ctx)
+	}
+}
+
+// confine 将api提交的路径限制在脚本目录下, 返回解析符号链接后的路径
+func (m *JobManager) confine(p string) (string, error) {
+	m.Lock()
+	dir := m.scriptsDir
+	m.Unlock()
+	if dir == "" {
+		return "", fmt.Errorf("%w: %s, the server has no scripts dir", ErrPathNotAllowed, p)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	within := func(path string) bool {
+		return strings.HasPrefix(path, dir+string(filepath.Separator))
+	}
+	// 先按路径检查, 避免通过错误信息探测目录外的文件是否存在
+	if !within(filepath.Clean(p)) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, p)
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	if !within(real) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, p)
+	}
+	return real, nil
+}
+
+// confineRequest 限制api请求中的服务端本地路径, 调度清单中的路径由服务端配置, 不经过该检查
+func (m *JobManager) confineRequest(req *SubmitRequest) (err error) {
+	m.Lock()
+	noInline := m.noInline
+	m.Unlock()
+	if noInline && req.Target != nil && len(req.Target.ScriptContent) > 0 {
+		return ErrInlineScript
+	}
+	if req.Target != nil && req.Target.ScriptPath != "" {
+		if req.Target.ScriptPath, err = m.confine(req.Target.ScriptPath); err != nil {
+			return err
+		}
+	}
+	if req.Inventory != "" {
+		if req.Inventory, err = m.confine(req.Inventory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandbox 按名称选择沙箱策略
+func (m *JobManager) sandbox(name string) (*sandbox.Policy, error) {
+	m.Lock()
+	defer m.Unlock()
+	if name == "" {
+		name = m.defaultSandbox
+	}
+	if name == "" {
+		return nil, nil
+	}
+	policy, ok := m.sandboxes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSandbox, name)
+	}
+	return policy, nil
+}
+
+// Submit 异步执行脚本，返回任务记录
+func (m *JobManager) Submit(req *SubmitRequest) (*Job, error) {
+	if req.Target == nil || (req.Target.ScriptPath == "" && len(req.Target.ScriptContent) == 0) {
+		return nil, ErrInvalidTarget
+	}
+	if req.Target.ScritType == "" {
+		req.Target.ScritType = ops.OpsStarlark
+	}
+	if req.ID == "" {
+		if req.Resume {
+			return nil, ops.ErrResumeWithoutID
+		}
+		u, _ := uuid.NewRandom()
+		req.ID = u.String()
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+	if req.Timeout <= 0 {
+		req.Timeout = defaultJobTimeout
+	}
+	if req.Limits.MaxHeapBytes > 0 {
+		return nil, ErrHeapLimit
+	}
+	var inv *inventory.Inventory
+	if req.Inventory != "" {
+		var err error
+		if inv, err = inventory.Load(req.Inventory); err != nil {
+			return nil, err
+		}
+	}
+	policy, err := m.sandbox(req.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	if localctx.GetTaskManager().Get(req.ID) != nil {
+		return nil, ErrJobExists
+	}
+	m.Lock()
+	if old, ok := m.jobs[req.ID]; ok {
+		if !old.Finished() {
+			m.Unlock()
+			return nil, ErrJobExists
+		}
+		m.forget(req.ID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	// 事件总线在执行结束后才关闭, kill只取消执行
+	execCtx, kill := context.WithCancel(ctx)
+	job := &Job{
+		ID:         req.ID,
+		Name:       req.Name,
+		Tags:       req.Tags,
+		ScriptPath: req.Target.ScriptPath,
+		StartTime:  time.Now(),
+		done:       make(chan struct{}),
+		kill:       kill,
+		events:     newStreamer(),
+	}
+	m.jobs[job.ID] = job
+	store := m.history
+	limits := req.Limits.within(m.limits)
+	providers := m.providers
+	m.Unlock()
+
+	v := version.GetVersion()
+	cfg := map[string]interface{}{
+		"job_id":    job.ID,
+		"job_name":  job.Name,
+		"job_tags":  job.Tags,
+		"version":   v.Version,
+		"buildtime": v.BuildTime,
+	}
+	for k, v := range req.Locals {
+		if _, ok := cfg[k]; !ok {
+			cfg[k] = v
+		}
+	}
+
+	bus := event.NewBus(ctx)
+	bus.SubscribeID(func(_ context.Context, ev event.Event) error {
+		job.events.publish(ev)
+		return nil
+	}, job.ID)
+	var recorder *history.Recorder
+	if store != nil {
+		recorder = history.NewRecorder(&history.Record{
+			ID:         job.ID,
+			Name:       job.Name,
+			Tags:       job.Tags,
+			ScriptPath: job.ScriptPath,
+			StartTime:  job.StartTime,
+		})
+		recorder.Subscribe(bus)
+	}
+
+	opts := []func(*ops.ExecOpts){
+		ops.SetEventBus(bus),
+		ops.SetOutputWriter(job),
+		ops.SetLocals(cfg),
+		ops.SetSecrets(req.Secrets),
+		ops.SetTimeout(time.Duration(req.Timeout) * time.Second),
+		ops.SetParams(req.Params),
+		ops.SetLimits(limits.MaxSteps, limits.MaxOutputBytes, limits.MaxShellStdout, limits.MaxHeapBytes),
+		ops.SetSecretProviders(providers),
+	}
+	if req.Resume {
+		opts = append(opts, ops.SetResume())
+	}
+	if inv != nil {
+		opts = append(opts, ops.SetInventory(inv))
+	}
+	if policy != nil {
+		opts = append(opts, ops.SetSandbox(policy))
+	}
+	go func() {
+		err := ops.ExecScript(execCtx, req.Target, opts...)
+		cancel()
+		if recorder != nil {
+			if serr := store.Save(recorder.Finish(err)); serr != nil {
+				fmt.Printf("save job %s history failed: %s\n", job.ID, serr.Error())
+			}
+		}
+		job.finish(err)
+		job.events.close()
+		m.Lock()
+		defer m.Unlock()
+		m.finished = append(m.finished, job.ID)
+		m.evict()
+	}()
+	return job, nil
+}
+
+// forget 删除任务记录，调用方需持有锁
+func (m *JobManager) forget(id string) {
+	delete(m.jobs, id)
+	for i, fid := range m.finished {
+		if fid == id {
+			m.finished = append(m.finished[:i], m.finished[i+1:]...)
+			break
+		}
+	}
+}
+
+// evict 淘汰最早结束的任务记录，调用方需持有锁
+func (m *JobManager) evict() {
+	for len(m.finished) > maxFinishedJobs {
+		delete(m.jobs, m.finished[0])
+		m.finished = m.finished[1:]
+	}
+}
+
+// Get 获取任务记录
+func (m *JobManager) Get(id string) *Job {
+	m.Lock()
+	defer m.Unlock()
+	return m.jobs[id]
+}
+
+// List 返回所有任务快照，包括非api提交但在TaskManager中运行的任务
+func (m *JobManager) List() []*JobInfo {
+	m.Lock()
+	infos := make([]*JobInfo, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		infos = append(infos, job.Info())
+	}
+	tm := localctx.GetTaskManager()
+	for id := range tm.GetAll() {
+		if _, ok := m.jobs[id]; ok {
+			continue
+		}
+		status, ok := tm.Status(id)
+		if !ok {
+			continue
+		}
+		infos = append(infos, &JobInfo{
+			ID:     id,
+			Name:   id,
+			Status: string(status),
+		})
+	}
+	m.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].StartTime == infos[j].StartTime {
+			return infos[i].ID < infos[j].ID
+		}
+		return infos[i].StartTime < infos[j].StartTime
+	})
+	return infos
+}
+
+// Suspend 挂起任务，任务会在下一次调用内置函数前暂停
+func (m *JobManager) Suspend(id string) error {
+	return localctx.GetTaskManager().Suspend(id)
+}
+
+// Recovery 恢复挂起的任务
+func (m *JobManager) Recovery(id string) error {
+	return localctx.GetTaskManager().Recovery(id)
+}
+
+// Kill 结束执行中的任务
+func (m *JobManager) Kill(id string) error {
+	m.Lock()
+	job, ok := m.jobs[id]
+	m.Unlock()
+	tm := localctx.GetTaskManager()
+	if ok && !job.Finished() {
+		// 任务可能尚未注册到TaskManager, 取消执行的context使其开始执行后立即结束
+		job.kill()
+		tm.Kill(id)
+		return nil
+	}
+	if tm.Get(id) == nil {
+		return ErrJobNotRunning
+	}
+	tm.Kill(id)
+	return nil
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, code int, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(code)
+	ctx.SetBody(buf)
+}
+
+func writeError(ctx *fasthttp.RequestCtx, code int, err error) {
+	writeJSON(ctx, code, map[string]string{"error": err.Error()})
+}
+
+func jobID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue("id").(string)
+	return id
+}
+
+// SubmitHandler POST /api/v1/tasks
+func (m *JobManager) SubmitHandler(ctx *fasthttp.RequestCtx) {
+	req := &SubmitRequest{}
+	if err := json.Unmarshal(ctx.PostBody(), req); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Errorf("%w: %s", ErrInvalidRequest, err.Error()))
+		return
+	}
+	if err := m.confineRequest(req); err != nil {
+		writeError(ctx, fasthttp.StatusForbidden, err)
+		return
+	}
+	job, err := m.Submit(req)
+	switch {
+	case errors.Is(err, ErrJobExists):
+		writeError(ctx, fasthttp.StatusConflict, err)
+	case err != nil:
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+	default:
+		writeJSON(ctx, fasthttp.StatusAccepted, job.Info())
+	}
+}
+
+// ListHandler GET /api/v1/tasks
+func (m *JobManager) ListHandler(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, fasthttp.StatusOK, m.List())
+}
+
+// GetHandler GET /api/v1/tasks/:id
+func (m *JobManager) GetHandler(ctx *fasthttp.RequestCtx) {
+	job := m.Get(jobID(ctx))
+	if job == nil {
+		writeError(ctx, fasthttp.StatusNotFound, ErrJobNotFound)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, job.Info())
+}
+
+// OutputHandler GET /api/v1/tasks/:id/output
+func (m *JobManager) OutputHandler(ctx *fasthttp.RequestCtx) {
+	job := m.Get(jobID(ctx))
+	if job == nil {
+		writeError(ctx, fasthttp.StatusNotFound, ErrJobNotFound)
+		return
+	}
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBody(job.Output())
+}
+
+// controlHandler 挂起/恢复/结束任务的通用处理
+func (m *JobManager) controlHandler(action func(id string) error) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := jobID(ctx)
+		if err := action(id); err != nil {
+			code := fasthttp.StatusConflict
+			if errors.Is(err, localctx.ErrSuspendFailed) || errors.Is(err, localctx.ErrRecoveryFailed) || errors.Is(err, ErrJobNotRunning) {
+				code = fasthttp.StatusNotFound
+			}
+			writeError(ctx, code, err)
+			return
+		}
+		if job := m.Get(id); job != nil {
+			writeJSON(ctx, fasthttp.StatusOK, job.Info())
+			return
+		}
+		writeJSON(ctx, fasthttp.StatusOK, map[string]string{"id": id})
+	}
+}
+
+// Register 注册任务管理相关的api
+func (m *JobManager) Register(r *fasthttprouter.Router) {
+	r.GET("/api/v1/tasks", m.authorize(m.ListHandler))
+	r.POST("/api/v1/tasks", m.authorize(m.SubmitHandler))
+	r.GET("/api/v1/tasks/:id", m.authorize(m.GetHandler))
+	r.GET("/api/v1/tasks/:id/output", m.authorize(m.OutputHandler))
+	r.GET("/api/v1/tasks/:id/events", m.authorize(m.EventsHandler))
+	r.POST("/api/v1/tasks/:id/suspend", m.authorize(m.controlHandler(m.Suspend)))
+	r.POST("/api/v1/tasks/:id/recovery", m.authorize(m.controlHandler(m.Recovery)))
+	r.POST("/api/v1/tasks/:id/kill", m.authorize(m.controlHandler(m.Kill)))
+}
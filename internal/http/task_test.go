@@ -0,0 +1,335 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/superops-team/hyperops/pkg/ops"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"github.com/valyala/fasthttp"
+)
+
+func doRequest(r *fasthttprouter.Router, method, uri string, body []byte) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	r.Handler(ctx)
+	return ctx
+}
+
+func waitJob(t *testing.T, r *fasthttprouter.Router, id string, status string) *JobInfo {
+	for i := 0; i < 100; i++ {
+		ctx := doRequest(r, "GET", "/api/v1/tasks/"+id, nil)
+		info := &JobInfo{}
+		if err := json.Unmarshal(ctx.Response.Body(), info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Status == status {
+			return info
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("job %s never reached status %s", id, status)
+	return nil
+}
+
+func TestJobManagerSubmit(t *testing.T) {
+	r := fasthttprouter.New()
+	NewJobManager().Register(r)
+
+	body, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_submit_job",
+		Target: &ops.Target{ScriptContent: []byte(`print("hello", ctx.get_config("x"))`)},
+		Locals: map[string]interface{}{"x": "world", "HYPEROPS_WORKSPACE_KEEP": false},
+	})
+	ctx := doRequest(r, "POST", "/api/v1/tasks", body)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	info := waitJob(t, r, "http_submit_job", "finished")
+	if info.Error != "" {
+		t.Fatalf("unexpected job error: %s", info.Error)
+	}
+
+	ctx = doRequest(r, "GET", "/api/v1/tasks/http_submit_job/output", nil)
+	if !strings.Contains(string(ctx.Response.Body()), "hello world") {
+		t.Errorf("output mismatch. got: %q", ctx.Response.Body())
+	}
+
+	ctx = doRequest(r, "GET", "/api/v1/tasks", nil)
+	infos := []*JobInfo{}
+	if err := json.Unmarshal(ctx.Response.Body(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].ID != "http_submit_job" {
+		t.Errorf("list mismatch. got: %s", ctx.Response.Body())
+	}
+}
+
+func TestJobManagerControl(t *testing.T) {
+	r := fasthttprouter.New()
+	NewJobManager().Register(r)
+
+	script := `
+for i in range(20):
+    sleep("100ms")
+`
+	req, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_control_job",
+		Target: &ops.Target{ScriptContent: []byte(script)},
+		Locals: map[string]interface{}{"HYPEROPS_WORKSPACE_KEEP": false},
+	})
+	ctx := doRequest(r, "POST", "/api/v1/tasks", req)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	waitJob(t, r, "http_control_job", "running")
+
+	ctx = doRequest(r, "POST", "/api/v1/tasks", req)
+	if ctx.Response.StatusCode() != fasthttp.StatusConflict {
+		t.Errorf("duplicated submit should conflict, got %d", ctx.Response.StatusCode())
+	}
+
+	ctx = doRequest(r, "POST", "/api/v1/tasks/http_control_job/suspend", nil)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("suspend failed %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	waitJob(t, r, "http_control_job", "hanging")
+
+	ctx = doRequest(r, "POST", "/api/v1/tasks/http_control_job/kill", nil)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("kill failed %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	info := waitJob(t, r, "http_control_job", "finished")
+	if !strings.Contains(info.Error, "cancel") {
+		t.Errorf("killed job should report cancel error, got %q", info.Error)
+	}
+
+	ctx = doRequest(r, "POST", "/api/v1/tasks/not_exist/suspend", nil)
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("suspend unknown job should be 404, got %d", ctx.Response.StatusCode())
+	}
+}
+
+func TestJobManagerKillShell(t *testing.T) {
+	r := fasthttprouter.New()
+	jm := NewJobManager()
+	jm.Register(r)
+
+	body, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_kill_shell_job",
+		Target: &ops.Target{ScriptContent: []byte(`sh("sleep 30; echo done")`)},
+		Locals: map[string]interface{}{"HYPEROPS_WORKSPACE_KEEP": false},
+	})
+	start := time.Now()
+	doRequest(r, "POST", "/api/v1/tasks", body)
+	// 任务刚提交时可能尚未开始执行, 同样需要结束
+	if err := jm.Kill("http_kill_shell_job"); err != nil {
+		t.Fatal(err)
+	}
+	info := waitJob(t, r, "http_kill_shell_job", "finished")
+	if info.Error == "" || time.Since(start) > 5*time.Second {
+		t.Errorf("killed job should stop the running command, got %q after %s", info.Error, time.Since(start))
+	}
+
+	body, _ = json.Marshal(&SubmitRequest{
+		ID:     "http_kill_running_shell_job",
+		Target: &ops.Target{ScriptContent: []byte(`sh("sleep 30; echo done")`)},
+		Locals: map[string]interface{}{"HYPEROPS_WORKSPACE_KEEP": false},
+	})
+	doRequest(r, "POST", "/api/v1/tasks", body)
+	waitJob(t, r, "http_kill_running_shell_job", "running")
+	start = time.Now()
+	doRequest(r, "POST", "/api/v1/tasks/http_kill_running_shell_job/kill", nil)
+	info = waitJob(t, r, "http_kill_running_shell_job", "finished")
+	if !strings.Contains(info.Error, "cancel") || time.Since(start) > 5*time.Second {
+		t.Errorf("killed job should stop the running command, got %q after %s", info.Error, time.Since(start))
+	}
+}
+
+func TestJobManagerSandbox(t *testing.T) {
+	policy, err := sandbox.Parse([]byte("name: locked"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm := NewJobManager()
+	if err := jm.SetSandboxes(map[string]*sandbox.Policy{"locked": policy}, "missing"); !errors.Is(err, ErrNoSandbox) {
+		t.Fatalf("expected sandbox not found, got %v", err)
+	}
+	if err := jm.SetSandboxes(map[string]*sandbox.Policy{"locked": policy}, "locked"); err != nil {
+		t.Fatal(err)
+	}
+	r := fasthttprouter.New()
+	jm.Register(r)
+
+	body, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_sandbox_job",
+		Target: &ops.Target{ScriptContent: []byte(`sh("id")`)},
+	})
+	doRequest(r, "POST", "/api/v1/tasks", body)
+	info := waitJob(t, r, "http_sandbox_job", "finished")
+	if !strings.Contains(info.Error, "denied by sandbox locked: shell is not allowed") {
+		t.Errorf("expected default sandbox to deny shell, got %q", info.Error)
+	}
+
+	body, _ = json.Marshal(&SubmitRequest{
+		Target:  &ops.Target{ScriptContent: []byte(`print(1)`)},
+		Sandbox: "other",
+	})
+	ctx := doRequest(r, "POST", "/api/v1/tasks", body)
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest || !strings.Contains(string(ctx.Response.Body()), "sandbox not found") {
+		t.Errorf("unexpected response %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+}
+
+func TestJobManagerScriptsDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "scripts")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"scripts/ok.star": `print("from scripts dir")`,
+		"outside.star":    `print("outside")`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "outside.star"), filepath.Join(dir, "link.star")); err != nil {
+		t.Fatal(err)
+	}
+
+	jm := NewJobManager()
+	r := fasthttprouter.New()
+	jm.Register(r)
+	submit := func(req *SubmitRequest) *fasthttp.RequestCtx {
+		body, _ := json.Marshal(req)
+		return doRequest(r, "POST", "/api/v1/tasks", body)
+	}
+
+	// 未设置脚本目录时只能提交脚本内容
+	ctx := submit(&SubmitRequest{Target: &ops.Target{ScriptPath: filepath.Join(dir, "ok.star")}})
+	if ctx.Response.StatusCode() != fasthttp.StatusForbidden {
+		t.Errorf("unexpected response %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	if err := jm.SetScriptsDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*SubmitRequest{
+		{Target: &ops.Target{ScriptPath: "../outside.star"}},
+		{Target: &ops.Target{ScriptPath: filepath.Join(root, "outside.star")}},
+		{Target: &ops.Target{ScriptPath: "link.star"}},
+		{Target: &ops.Target{ScriptPath: "../missing.star"}},
+		{Target: &ops.Target{ScriptContent: []byte(`print(1)`)}, Inventory: "/etc/hosts"},
+	} {
+		ctx := submit(req)
+		if ctx.Response.StatusCode() != fasthttp.StatusForbidden || !strings.Contains(string(ctx.Response.Body()), "path is not allowed") {
+			t.Errorf("expected %s %s to be rejected, got %d: %s", req.Target.ScriptPath, req.Inventory, ctx.Response.StatusCode(), ctx.Response.Body())
+		}
+	}
+
+	// 关闭脚本内容提交后只能执行脚本目录下的文件
+	jm.DisableInlineScripts()
+	ctx = submit(&SubmitRequest{Target: &ops.Target{ScriptContent: []byte(`print(1)`)}})
+	if ctx.Response.StatusCode() != fasthttp.StatusForbidden || !strings.Contains(string(ctx.Response.Body()), "inline scripts are disabled") {
+		t.Errorf("expected inline script to be rejected, got %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+
+	ctx = submit(&SubmitRequest{ID: "http_scripts_dir_job", Target: &ops.Target{ScriptPath: "ok.star"}})
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if info := waitJob(t, r, "http_scripts_dir_job", "finished"); info.Error != "" {
+		t.Errorf("unexpected job error: %s", info.Error)
+	}
+}
+
+func TestJobManagerToken(t *testing.T) {
+	jm := NewJobManager()
+	jm.SetToken("s3cret")
+	r := fasthttprouter.New()
+	jm.Register(r)
+
+	body, _ := json.Marshal(&SubmitRequest{ID: "http_token_job", Target: &ops.Target{ScriptContent: []byte(`print(1)`)}})
+	for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+		for _, req := range []struct {
+			method, uri string
+		}{
+			{"POST", "/api/v1/tasks"},
+			{"GET", "/api/v1/tasks"},
+			{"GET", "/api/v1/tasks/http_token_job/output"},
+			{"POST", "/api/v1/tasks/http_token_job/kill"},
+		} {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod(req.method)
+			ctx.Request.SetRequestURI(req.uri)
+			ctx.Request.Header.Set("Authorization", auth)
+			ctx.Request.SetBody(body)
+			r.Handler(ctx)
+			if ctx.Response.StatusCode() != fasthttp.StatusUnauthorized {
+				t.Errorf("%s %s with %q: expected 401, got %d", req.method, req.uri, auth, ctx.Response.StatusCode())
+			}
+		}
+	}
+	if jm.Get("http_token_job") != nil {
+		t.Fatal("unauthorized request should not submit a job")
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/api/v1/tasks")
+	ctx.Request.Header.Set("Authorization", "Bearer s3cret")
+	ctx.Request.SetBody(body)
+	r.Handler(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Fatalf("unexpected status %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if job := jm.Get("http_token_job"); job == nil {
+		t.Fatal("job not submitted")
+	} else {
+		<-job.Done()
+	}
+}
+
+func TestJobManagerLimits(t *testing.T) {
+	jm := NewJobManager()
+	jm.SetLimits(Limits{MaxSteps: 1000, MaxOutputBytes: 1 << 20})
+	r := fasthttprouter.New()
+	jm.Register(r)
+
+	// 请求中的限制不能超过服务端上限
+	body, _ := json.Marshal(&SubmitRequest{
+		ID:     "http_limits_job",
+		Target: &ops.Target{ScriptContent: []byte("for i in range(1000000):\n    pass")},
+		Limits: Limits{MaxSteps: 1 << 30},
+	})
+	doRequest(r, "POST", "/api/v1/tasks", body)
+	info := waitJob(t, r, "http_limits_job", "finished")
+	if info.Limit != "steps" || !strings.Contains(info.Error, "steps limit exceeded (max 1000)") {
+		t.Errorf("unexpected job info %+v", info)
+	}
+
+	if got := (Limits{MaxOutputBytes: 10}).within(Limits{MaxOutputBytes: 100, MaxHeapBytes: 1 << 30}); got != (Limits{MaxOutputBytes: 10, MaxHeapBytes: 1 << 30}) {
+		t.Errorf("unexpected limits %+v", got)
+	}
+
+	// 堆内存是进程级别的, 不能限制单个任务
+	_, err := jm.Submit(&SubmitRequest{
+		Target: &ops.Target{ScriptContent: []byte("pass")},
+		Limits: Limits{MaxHeapBytes: 1 << 30},
+	})
+	if !errors.Is(err, ErrHeapLimit) {
+		t.Errorf("expected ErrHeapLimit, got %v", err)
+	}
+}
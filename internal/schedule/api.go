@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+)
+
+func writeJSON(ctx *fasthttp.RequestCtx, code int, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(code)
+	ctx.SetBody(buf)
+}
+
+func writeError(ctx *fasthttp.RequestCtx, err error) {
+	code := fasthttp.StatusInternalServerError
+	if errors.Is(err, ErrScheduleNotFound) {
+		code = fasthttp.StatusNotFound
+	}
+	writeJSON(ctx, code, map[string]string{"error": err.Error()})
+}
+
+func scheduleName(ctx *fasthttp.RequestCtx) string {
+	name, _ := ctx.UserValue("name").(string)
+	return name
+}
+
+// ListHandler GET /api/v1/schedules
+func (s *Scheduler) ListHandler(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, fasthttp.StatusOK, s.List())
+}
+
+// GetHandler GET /api/v1/schedules/:name
+func (s *Scheduler) GetHandler(ctx *fasthttp.RequestCtx) {
+	st, err := s.Status(scheduleName(ctx))
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, st)
+}
+
+// TriggerHandler POST /api/v1/schedules/:name/trigger
+func (s *Scheduler) TriggerHandler(ctx *fasthttp.RequestCtx) {
+	name := scheduleName(ctx)
+	if err := s.Trigger(name); err != nil {
+		writeError(ctx, err)
+		return
+	}
+	st, _ := s.Status(name)
+	writeJSON(ctx, fasthttp.StatusAccepted, st)
+}
+
+// Register 注册定时任务相关的api, 每次执行的输出通过history命令按job_id查询
+func (s *Scheduler) Register(r *fasthttprouter.Router) {
+	r.GET("/api/v1/schedules", s.ListHandler)
+	r.GET("/api/v1/schedules/:name", s.GetHandler)
+	r.POST("/api/v1/schedules/:name/trigger", s.TriggerHandler)
+}
@@ -0,0 +1,106 @@
+// Package schedule 按照cron表达式周期性执行脚本
+package schedule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// Policy 上一次执行尚未结束时再次触发的处理策略
+type Policy string
+
+const (
+	// PolicySkip 跳过本次触发
+	PolicySkip Policy = "skip"
+	// PolicyQueue 排队等待上一次执行结束后再执行
+	PolicyQueue Policy = "queue"
+	// PolicyReplace 结束上一次执行后立即执行
+	PolicyReplace Policy = "replace"
+)
+
+// Schedule 单个定时任务配置
+type Schedule struct {
+	Name        string            `yaml:"name" json:"name"`
+	Cron        string            `yaml:"cron" json:"cron"`                                   // 标准5段cron表达式，也支持@every 1m, @hourly等
+	File        string            `yaml:"file" json:"file"`                                   // 脚本路径，相对路径基于manifest所在目录
+	CtxConfig   string            `yaml:"ctxconfig,omitempty" json:"ctxconfig,omitempty"`     // ctx配置文件，每次执行时重新读取
+	Timeout     int               `yaml:"timeout,omitempty" json:"timeout,omitempty"`         // 超时时间(秒)
+	Tags        string            `yaml:"tags,omitempty" json:"tags,omitempty"`               // 任务标签
+	Concurrency Policy            `yaml:"concurrency,omitempty" json:"concurrency,omitempty"` // skip/queue/replace, 默认skip
+	Inventory   string            `yaml:"inventory,omitempty" json:"inventory,omitempty"`     // 主机清单文件，每次执行时重新读取
+	Params      map[string]string `yaml:"params,omitempty" json:"params,omitempty"`           // 脚本参数, 与apply --set相同
+	Sandbox     string            `yaml:"sandbox,omitempty" json:"sandbox,omitempty"`         // 沙箱策略名称, 由--sandbox-dir提供
+}
+
+// Manifest 定时任务清单
+type Manifest struct {
+	Schedules []*Schedule `yaml:"schedules"`
+}
+
+// LoadManifest 读取并校验定时任务清单
+func LoadManifest(file string) (*Manifest, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.UnmarshalStrict(buf, m); err != nil {
+		return nil, fmt.Errorf("invalid schedule manifest %s: %w", file, err)
+	}
+	if err := m.validate(filepath.Dir(file)); err != nil {
+		return nil, fmt.Errorf("invalid schedule manifest %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// validate 校验配置并补全默认值，相对路径转换为基于dir的路径
+func (m *Manifest) validate(dir string) error {
+	if len(m.Schedules) == 0 {
+		return fmt.Errorf("no schedules defined")
+	}
+	names := make(map[string]bool, len(m.Schedules))
+	for i, s := range m.Schedules {
+		if s.Name == "" {
+			return fmt.Errorf("schedules[%d]: name is required", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("schedule %s: duplicate name", s.Name)
+		}
+		names[s.Name] = true
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			return fmt.Errorf("schedule %s: invalid cron %q: %w", s.Name, s.Cron, err)
+		}
+		if s.File == "" {
+			return fmt.Errorf("schedule %s: file is required", s.Name)
+		}
+		s.File = resolvePath(dir, s.File)
+		if s.CtxConfig != "" {
+			s.CtxConfig = resolvePath(dir, s.CtxConfig)
+		}
+		if s.Inventory != "" {
+			s.Inventory = resolvePath(dir, s.Inventory)
+		}
+		if s.Timeout < 0 {
+			return fmt.Errorf("schedule %s: invalid timeout %d", s.Name, s.Timeout)
+		}
+		switch s.Concurrency {
+		case "":
+			s.Concurrency = PolicySkip
+		case PolicySkip, PolicyQueue, PolicyReplace:
+		default:
+			return fmt.Errorf("schedule %s: unknown concurrency policy %q, should be skip, queue or replace", s.Name, s.Concurrency)
+		}
+	}
+	return nil
+}
+
+func resolvePath(dir, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "schedules.yaml", `
+schedules:
+  - name: cleanup
+    cron: "*/5 * * * *"
+    file: cleanup.ops
+    ctxconfig: ctx.yaml
+    timeout: 60
+  - name: report
+    cron: "@every 1h"
+    file: /opt/report.ops
+    concurrency: queue
+`)
+	m, err := LoadManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup, report := m.Schedules[0], m.Schedules[1]
+	if cleanup.File != filepath.Join(dir, "cleanup.ops") || cleanup.CtxConfig != filepath.Join(dir, "ctx.yaml") {
+		t.Errorf("relative paths should be resolved from the manifest dir, got %s %s", cleanup.File, cleanup.CtxConfig)
+	}
+	if cleanup.Concurrency != PolicySkip {
+		t.Errorf("default concurrency policy should be skip, got %s", cleanup.Concurrency)
+	}
+	if report.File != "/opt/report.ops" || report.Concurrency != PolicyQueue {
+		t.Errorf("unexpected schedule %+v", report)
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	cases := map[string]string{
+		"no schedules defined": `schedules: []`,
+		"name is required":     "schedules:\n  - cron: '@hourly'\n    file: a.ops\n",
+		"duplicate name":       "schedules:\n  - {name: a, cron: '@hourly', file: a.ops}\n  - {name: a, cron: '@hourly', file: a.ops}\n",
+		"invalid cron":         "schedules:\n  - {name: a, cron: '* *', file: a.ops}\n",
+		"file is required":     "schedules:\n  - {name: a, cron: '@hourly'}\n",
+		"unknown concurrency":  "schedules:\n  - {name: a, cron: '@hourly', file: a.ops, concurrency: parallel}\n",
+		"not found in type":    "schedules:\n  - {name: a, cron: '@hourly', file: a.ops, retry: 3}\n",
+	}
+	for expect, content := range cases {
+		file := writeFile(t, t.TempDir(), "schedules.yaml", content)
+		_, err := LoadManifest(file)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected error containing %q, got %v", expect, err)
+		}
+	}
+}
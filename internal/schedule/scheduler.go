@@ -0,0 +1,263 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/superops-team/hyperops/internal/http"
+	"github.com/superops-team/hyperops/pkg/ctxconfig"
+	"github.com/superops-team/hyperops/pkg/history"
+	"github.com/superops-team/hyperops/pkg/metrics"
+	"github.com/superops-team/hyperops/pkg/ops"
+)
+
+var (
+	// ErrScheduleNotFound 定时任务不存在
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+// Result 单次执行结果
+type Result struct {
+	JobID     string    `json:"job_id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// Status 定时任务状态快照
+type Status struct {
+	*Schedule
+	NextRun    time.Time `json:"next_run"`
+	Running    string    `json:"running,omitempty"` // 执行中的任务ID
+	Queued     int       `json:"queued,omitempty"`  // 排队等待执行的次数
+	LastResult *Result   `json:"last_result,omitempty"`
+}
+
+type entry struct {
+	sync.Mutex
+	schedule *Schedule
+	id       cron.EntryID
+	active   bool      // 执行循环是否在运行
+	running  *http.Job // 执行中的任务
+	replace  bool      // 替换尚未提交的执行, 提交后立即结束
+	queued   int
+	last     *Result
+}
+
+// Scheduler 定时任务调度器，通过JobManager执行脚本，执行记录与api提交的任务一致
+type Scheduler struct {
+	sync.Mutex
+	jm      *http.JobManager
+	cron    *cron.Cron
+	entries []*entry
+	keyFile string // 解密ctxconfig中加密值的密钥文件
+}
+
+// NewScheduler 根据清单创建调度器
+func NewScheduler(m *Manifest, jm *http.JobManager) (*Scheduler, error) {
+	s := &Scheduler{
+		jm:   jm,
+		cron: cron.New(),
+	}
+	for _, sc := range m.Schedules {
+		e := &entry{schedule: sc}
+		id, err := s.cron.AddFunc(sc.Cron, func() { s.trigger(e) })
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sc.Name, err)
+		}
+		e.id = id
+		s.entries = append(s.entries, e)
+	}
+	return s, nil
+}
+
+// Start 开始调度
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop 停止调度，不会结束执行中的任务
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+func (s *Scheduler) get(name string) *entry {
+	s.Lock()
+	defer s.Unlock()
+	for _, e := range s.entries {
+		if e.schedule.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Trigger 立即触发一次执行，与cron触发一样遵循并发策略
+func (s *Scheduler) Trigger(name string) error {
+	e := s.get(name)
+	if e == nil {
+		return ErrScheduleNotFound
+	}
+	s.trigger(e)
+	return nil
+}
+
+// trigger 根据并发策略处理一次触发
+func (s *Scheduler) trigger(e *entry) {
+	e.Lock()
+	defer e.Unlock()
+	name := e.schedule.Name
+	if !e.active {
+		e.active = true
+		metrics.ScheduleTriggerCount.WithLabelValues(name, "run").Inc()
+		go s.run(e)
+		return
+	}
+	switch e.schedule.Concurrency {
+	case PolicyQueue:
+		e.queued++
+		metrics.ScheduleTriggerCount.WithLabelValues(name, "queue").Inc()
+	case PolicyReplace:
+		// 结束当前执行，执行循环会在其结束后立即开始新的执行
+		e.queued = 1
+		if e.running != nil {
+			_ = s.jm.Kill(e.running.ID)
+		} else {
+			e.replace = true
+		}
+		metrics.ScheduleTriggerCount.WithLabelValues(name, "replace").Inc()
+	default:
+		fmt.Printf("schedule %s is still running, skip\n", name)
+		metrics.ScheduleTriggerCount.WithLabelValues(name, "skip").Inc()
+	}
+}
+
+// run 执行循环，执行结束后处理排队的触发
+func (s *Scheduler) run(e *entry) {
+	for {
+		result := s.execute(e)
+		e.Lock()
+		e.running = nil
+		e.replace = false
+		e.last = result
+		if e.queued == 0 {
+			e.active = false
+			e.Unlock()
+			return
+		}
+		e.queued--
+		e.Unlock()
+	}
+}
+
+// execute 提交一次执行并等待结束
+func (s *Scheduler) execute(e *entry) *Result {
+	sc := e.schedule
+	start := time.Now()
+	result := &Result{
+		JobID:     fmt.Sprintf("%s-%s", sc.Name, start.Format("20060102T150405.000")),
+		StartTime: start,
+	}
+	job, err := s.submit(sc, result.JobID)
+	if err == nil {
+		e.Lock()
+		e.running = job
+		replaced := e.replace
+		e.replace = false
+		e.Unlock()
+		if replaced {
+			_ = s.jm.Kill(job.ID)
+		}
+		<-job.Done()
+		err = job.Err
+	}
+	result.EndTime = time.Now()
+	result.Status = history.StatusSucceed
+	if err != nil {
+		result.Status = history.StatusFailed
+		result.Error = err.Error()
+		fmt.Printf("schedule %s job %s failed: %s\n", sc.Name, result.JobID, err.Error())
+	}
+	return result
+}
+
+// submit 每次执行时重新读取脚本和ctx配置，修改后无需重启
+func (s *Scheduler) submit(sc *Schedule, jobID string) (*http.Job, error) {
+	target, err := ops.NewTarget(sc.File)
+	if err != nil {
+		return nil, err
+	}
+	locals := map[string]interface{}{}
+	var secrets map[string]string
+	if sc.CtxConfig != "" {
+		// 加密值解密后同时作为任务的密码
+		if locals, secrets, err = ctxconfig.Load(sc.CtxConfig, s.configKeyFile()); err != nil {
+			return nil, err
+		}
+	}
+	return s.jm.Submit(&http.SubmitRequest{
+		ID:        jobID,
+		Name:      sc.Name,
+		Tags:      sc.Tags,
+		Target:    target,
+		Locals:    locals,
+		Secrets:   secrets,
+		Timeout:   sc.Timeout,
+		Inventory: sc.Inventory,
+		Params:    sc.Params,
+		Sandbox:   sc.Sandbox,
+	})
+}
+
+// SetConfigKeyFile 设置解密ctxconfig中加密值的密钥文件, 为空时使用默认的密钥
+func (s *Scheduler) SetConfigKeyFile(file string) {
+	s.Lock()
+	defer s.Unlock()
+	s.keyFile = file
+}
+
+func (s *Scheduler) configKeyFile() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.keyFile
+}
+
+func (s *Scheduler) status(e *entry) *Status {
+	e.Lock()
+	defer e.Unlock()
+	st := &Status{
+		Schedule:   e.schedule,
+		NextRun:    s.cron.Entry(e.id).Next,
+		Queued:     e.queued,
+		LastResult: e.last,
+	}
+	if e.running != nil {
+		st.Running = e.running.ID
+	}
+	return st
+}
+
+// Status 获取指定定时任务的状态
+func (s *Scheduler) Status(name string) (*Status, error) {
+	e := s.get(name)
+	if e == nil {
+		return nil, ErrScheduleNotFound
+	}
+	return s.status(e), nil
+}
+
+// List 按清单顺序返回所有定时任务的状态
+func (s *Scheduler) List() []*Status {
+	s.Lock()
+	entries := append([]*entry(nil), s.entries...)
+	s.Unlock()
+	list := make([]*Status, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, s.status(e))
+	}
+	return list
+}
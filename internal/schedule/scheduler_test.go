@@ -0,0 +1,200 @@
+package schedule
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buaazp/fasthttprouter"
+	"github.com/superops-team/hyperops/internal/http"
+	"github.com/superops-team/hyperops/pkg/history"
+	"github.com/valyala/fasthttp"
+)
+
+// slowScript 执行约500ms, 每次循环都可以被kill中断
+const slowScript = `
+for i in range(10):
+    sleep("50ms")
+print("done", ctx.get_config("env"))
+`
+
+func newTestScheduler(t *testing.T, policy Policy) (*Scheduler, *http.JobManager) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, dir, "slow.ops", slowScript)
+	writeFile(t, dir, "ctx.yaml", "env: test\n")
+	m := &Manifest{Schedules: []*Schedule{{
+		Name:        "slow",
+		Cron:        "@every 1h",
+		File:        "slow.ops",
+		CtxConfig:   "ctx.yaml",
+		Concurrency: policy,
+	}}}
+	if err := m.validate(dir); err != nil {
+		t.Fatal(err)
+	}
+	jm := http.NewJobManager()
+	s, err := NewScheduler(m, jm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Stop)
+	return s, jm
+}
+
+// waitIdle 等待执行循环结束
+func waitIdle(t *testing.T, s *Scheduler, name string) *Status {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		e := s.get(name)
+		e.Lock()
+		active := e.active
+		e.Unlock()
+		if !active {
+			st, _ := s.Status(name)
+			return st
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("schedule %s still running", name)
+	return nil
+}
+
+// jobsOf 返回定时任务产生的所有执行记录
+func jobsOf(jm *http.JobManager, name string) []*http.JobInfo {
+	var jobs []*http.JobInfo
+	for _, info := range jm.List() {
+		if info.Name == name {
+			jobs = append(jobs, info)
+		}
+	}
+	return jobs
+}
+
+func TestSchedulerSkip(t *testing.T) {
+	s, jm := newTestScheduler(t, PolicySkip)
+	for i := 0; i < 3; i++ {
+		if err := s.Trigger("slow"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := waitIdle(t, s, "slow")
+	if st.LastResult == nil || st.LastResult.Status != history.StatusSucceed {
+		t.Fatalf("unexpected last result %+v", st.LastResult)
+	}
+	jobs := jobsOf(jm, "slow")
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(jobs))
+	}
+	if out := string(jm.Get(jobs[0].ID).Output()); !strings.Contains(out, "done test") {
+		t.Errorf("ctxconfig should be passed to the script, got output %q", out)
+	}
+}
+
+func TestSchedulerQueue(t *testing.T) {
+	s, jm := newTestScheduler(t, PolicyQueue)
+	for i := 0; i < 3; i++ {
+		_ = s.Trigger("slow")
+	}
+	if st, _ := s.Status("slow"); st.Queued != 2 {
+		t.Errorf("expected 2 queued runs, got %d", st.Queued)
+	}
+	waitIdle(t, s, "slow")
+	jobs := jobsOf(jm, "slow")
+	if len(jobs) != 3 {
+		t.Fatalf("expected 3 runs, got %d", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Error != "" {
+			t.Errorf("job %s failed: %s", job.ID, job.Error)
+		}
+	}
+}
+
+func TestSchedulerReplace(t *testing.T) {
+	s, jm := newTestScheduler(t, PolicyReplace)
+	_ = s.Trigger("slow")
+	// 等待第一次执行开始后再触发
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, _ := s.Status("slow"); st.Running != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first run not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = s.Trigger("slow")
+	st := waitIdle(t, s, "slow")
+	if st.LastResult.Status != history.StatusSucceed {
+		t.Errorf("replacing run should succeed, got %+v", st.LastResult)
+	}
+	jobs := jobsOf(jm, "slow")
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(jobs))
+	}
+	if !strings.Contains(jobs[0].Error, "cancel") {
+		t.Errorf("first run should be killed, got error %q", jobs[0].Error)
+	}
+}
+
+func TestSchedulerReplaceStarting(t *testing.T) {
+	s, jm := newTestScheduler(t, PolicyReplace)
+	// 第二次触发时第一次执行可能还在提交中, 也需要被结束
+	_ = s.Trigger("slow")
+	_ = s.Trigger("slow")
+	st := waitIdle(t, s, "slow")
+	if st.LastResult.Status != history.StatusSucceed {
+		t.Errorf("replacing run should succeed, got %+v", st.LastResult)
+	}
+	jobs := jobsOf(jm, "slow")
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(jobs))
+	}
+	if !strings.Contains(jobs[0].Error, "cancel") {
+		t.Errorf("first run should be killed, got error %q", jobs[0].Error)
+	}
+}
+
+func TestSchedulerAPI(t *testing.T) {
+	s, _ := newTestScheduler(t, PolicySkip)
+	r := fasthttprouter.New()
+	s.Register(r)
+	do := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		r.Handler(ctx)
+		return ctx
+	}
+
+	ctx := do("GET", "/api/v1/schedules")
+	var list []*Status
+	if err := json.Unmarshal(ctx.Response.Body(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "slow" || list[0].NextRun.IsZero() {
+		t.Fatalf("unexpected schedules %s", ctx.Response.Body())
+	}
+
+	if ctx := do("POST", "/api/v1/schedules/slow/trigger"); ctx.Response.StatusCode() != fasthttp.StatusAccepted {
+		t.Errorf("unexpected trigger status %d", ctx.Response.StatusCode())
+	}
+	waitIdle(t, s, "slow")
+	ctx = do("GET", "/api/v1/schedules/slow")
+	st := &Status{}
+	if err := json.Unmarshal(ctx.Response.Body(), st); err != nil {
+		t.Fatal(err)
+	}
+	if st.LastResult == nil || st.LastResult.Status != history.StatusSucceed {
+		t.Errorf("unexpected status %s", ctx.Response.Body())
+	}
+
+	if ctx := do("GET", "/api/v1/schedules/unknown"); ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("expected 404, got %d", ctx.Response.StatusCode())
+	}
+}
@@ -0,0 +1,164 @@
+package ctxconfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+)
+
+const (
+	// KeyEnv base64编码的密钥, 未指定密钥文件时使用
+	KeyEnv = "HYPEROPS_CONFIG_KEY"
+	// KeySize AES-256密钥长度
+	KeySize = 32
+
+	encPrefix = "ENC[AES256_GCM,"
+	tagSize   = 16
+)
+
+// ErrNoKey 配置中包含加密值但没有可用的密钥
+var ErrNoKey = errors.New("no config key, use --config-key-file or $" + KeyEnv)
+
+// encRe 加密值的格式, 与sops一致: ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]
+var encRe = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|int|float|bool)\]$`)
+
+// Key 加解密配置值的AES-256密钥
+type Key []byte
+
+// GenerateKey 生成随机密钥
+func GenerateKey() (Key, error) {
+	key := make(Key, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseKey 解析base64编码的密钥
+func ParseKey(s string) (Key, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid config key, should be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// String base64编码的密钥, 写入密钥文件或环境变量
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+// DefaultKeyFile 默认密钥文件$HOME/.hyperops/config.key
+func DefaultKeyFile() string {
+	return filepath.Join(environment.NewEnvStorage().Get("HOME"), ".hyperops", "config.key")
+}
+
+// LoadKey 读取密钥, 依次使用file, $HYPEROPS_CONFIG_KEY和默认密钥文件
+func LoadKey(file string) (Key, error) {
+	if file == "" {
+		if s := environment.NewEnvStorage().Get(KeyEnv); s != "" {
+			return ParseKey(s)
+		}
+		file = DefaultKeyFile()
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return nil, ErrNoKey
+		}
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(buf))
+}
+
+// IsEncrypted 是否为加密值
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encPrefix)
+}
+
+// Encrypt 加密标量值, path作为附加数据, 加密值只能在相同的配置路径下解密
+func (k Key) Encrypt(value interface{}, path string) (string, error) {
+	var typ, plain string
+	switch v := value.(type) {
+	case string:
+		typ, plain = "str", v
+	case int, int64, uint64:
+		typ, plain = "int", fmt.Sprint(v)
+	case float64:
+		typ, plain = "float", strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		typ, plain = "bool", strconv.FormatBool(v)
+	default:
+		return "", fmt.Errorf("%s: cannot encrypt %T", path, value)
+	}
+	gcm, err := k.gcm()
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plain), []byte(path))
+	data, tag := sealed[:len(sealed)-tagSize], sealed[len(sealed)-tagSize:]
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%sdata:%s,iv:%s,tag:%s,type:%s]", encPrefix, b64(data), b64(iv), b64(tag), typ), nil
+}
+
+// Decrypt 解密加密值并还原类型
+func (k Key) Decrypt(s, path string) (interface{}, error) {
+	m := encRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%s: invalid encrypted value", path)
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid encrypted value: %w", path, err)
+		}
+		parts[i] = b
+	}
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts[1]) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%s: invalid encrypted value iv", path)
+	}
+	plain, err := gcm.Open(nil, parts[1], append(parts[0], parts[2]...), []byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decrypt failed, wrong key or the value was moved from another path", path)
+	}
+	switch m[4] {
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	}
+	return string(plain), nil
+}
+
+func (k Key) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
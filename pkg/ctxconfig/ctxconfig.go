@@ -0,0 +1,131 @@
+// Package ctxconfig 读取ctx配置文件, 配置中的ENC[AES256_GCM,...]加密值在读取时解密并作为密码处理
+package ctxconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// visitFunc 处理配置中的标量值, path为完整路径, name为所在字段的名称, 返回替换后的值
+type visitFunc func(path, name string, value interface{}) (interface{}, error)
+
+// walk 遍历配置中的所有标量值, 路径使用.连接字段, 列表元素为[i]
+func walk(path, name string, v interface{}, fn visitFunc) (interface{}, error) {
+	var err error
+	switch val := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range val {
+			k := fmt.Sprint(item.Key)
+			if val[i].Value, err = walk(join(path, k), k, item.Value, fn); err != nil {
+				return nil, err
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, item := range val {
+			k := fmt.Sprint(key)
+			if val[key], err = walk(join(path, k), k, item, fn); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range val {
+			if val[k], err = walk(join(path, k), k, item, fn); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			if val[i], err = walk(path+"["+strconv.Itoa(i)+"]", name, item, fn); err != nil {
+				return nil, err
+			}
+		}
+	case nil:
+	default:
+		return fn(path, name, v)
+	}
+	return v, nil
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// EncryptedPaths 配置中已加密的值的路径
+func EncryptedPaths(v interface{}) (map[string]bool, error) {
+	paths := map[string]bool{}
+	_, err := walk("", "", v, func(path, _ string, value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok && IsEncrypted(s) {
+			paths[path] = true
+		}
+		return value, nil
+	})
+	return paths, err
+}
+
+// Decrypt 解密配置中的加密值, 返回解密后作为密码的值, 非字符串的值格式化为字符串, 按路径索引
+func Decrypt(v interface{}, key Key) (map[string]string, error) {
+	secrets := map[string]string{}
+	_, err := walk("", "", v, func(path, _ string, value interface{}) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok || !IsEncrypted(s) {
+			return value, nil
+		}
+		if key == nil {
+			return nil, fmt.Errorf("%s is encrypted: %w", path, ErrNoKey)
+		}
+		plain, err := key.Decrypt(s, path)
+		if err != nil {
+			return nil, err
+		}
+		// 所有解密的值都作为密码, 过短的密码只替换原文, 不替换base64等编码形式
+		secrets[path] = fmt.Sprint(plain)
+		return plain, nil
+	})
+	return secrets, err
+}
+
+// Encrypt 加密match返回true的标量值, 已加密的值保持不变
+func Encrypt(v interface{}, key Key, match func(path, name string) bool) error {
+	_, err := walk("", "", v, func(path, name string, value interface{}) (interface{}, error) {
+		if s, ok := value.(string); ok && IsEncrypted(s) {
+			return value, nil
+		}
+		if !match(path, name) {
+			return value, nil
+		}
+		return key.Encrypt(value, path)
+	})
+	return err
+}
+
+// Load 读取ctx配置文件, 包含加密值时使用keyFile(为空时按LoadKey的顺序查找)中的密钥解密
+// 返回解密后的配置和解密出的密码, 密码按配置路径索引, 例如db.password
+func Load(file, keyFile string) (map[string]interface{}, map[string]string, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		return nil, nil, fmt.Errorf("invalid ctxconfig %s: %w", file, err)
+	}
+	paths, err := EncryptedPaths(config)
+	if err != nil || len(paths) == 0 {
+		return config, nil, err
+	}
+	key, err := LoadKey(keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ctxconfig %s has encrypted values: %w", file, err)
+	}
+	secrets, err := Decrypt(config, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ctxconfig %s: %w", file, err)
+	}
+	return config, secrets, nil
+}
@@ -0,0 +1,149 @@
+package ctxconfig
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const plainConfig = `
+db:
+  host: 127.0.0.1
+  port: 5432
+  user: ops
+  password: db-pass
+  tls: true
+tokens:
+- token-a
+- token-b
+`
+
+func encryptConfig(t *testing.T, key Key, match func(path, name string) bool) []byte {
+	var data yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(plainConfig), &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encrypt(data, key, match); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := yaml.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := encryptConfig(t, key, func(path, name string) bool {
+		return name != "host"
+	})
+	if strings.Contains(string(buf), "db-pass") || strings.Contains(string(buf), "token-a") {
+		t.Fatalf("plain values left in %s", buf)
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(buf, &config); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := EncryptedPaths(config)
+	expectPaths := map[string]bool{"db.port": true, "db.user": true, "db.password": true, "db.tls": true, "tokens[0]": true, "tokens[1]": true}
+	if !reflect.DeepEqual(paths, expectPaths) {
+		t.Errorf("unexpected encrypted paths %v", paths)
+	}
+
+	secrets, err := Decrypt(config, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{}
+	_ = yaml.Unmarshal([]byte(plainConfig), &expect)
+	if !reflect.DeepEqual(config, expect) {
+		t.Errorf("expected %v, got %v", expect, config)
+	}
+	// 数字, 布尔值和较短的字符串也作为密码
+	expectSecrets := map[string]string{"db.port": "5432", "db.user": "ops", "db.password": "db-pass", "db.tls": "true", "tokens[0]": "token-a", "tokens[1]": "token-b"}
+	if !reflect.DeepEqual(secrets, expectSecrets) {
+		t.Errorf("unexpected secrets %v", secrets)
+	}
+}
+
+func TestDecryptFailed(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+	value, err := key.Encrypt("db-pass", "db.password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(value, "db.password"); err == nil {
+		t.Error("expected wrong key error")
+	}
+	// 加密值绑定配置路径, 移动到其他字段后无法解密
+	if _, err := key.Decrypt(value, "db.user"); err == nil {
+		t.Error("expected moved value error")
+	}
+	if _, err := key.Decrypt(strings.Replace(value, "type:str", "type:raw", 1), "db.password"); err == nil {
+		t.Error("expected invalid value error")
+	}
+	if _, err := Decrypt(map[string]interface{}{"password": value}, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected no key, got %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := GenerateKey()
+	keyFile := filepath.Join(dir, "config.key")
+	if err := ioutil.WriteFile(keyFile, []byte(key.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.yaml")
+	encrypted := filepath.Join(dir, "ctx.yaml")
+	if err := ioutil.WriteFile(plain, []byte(plainConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	buf := encryptConfig(t, key, func(path, name string) bool {
+		return name == "password"
+	})
+	if err := ioutil.WriteFile(encrypted, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有加密值时不需要密钥
+	config, secrets, err := Load(plain, filepath.Join(dir, "missing.key"))
+	if err != nil || len(secrets) != 0 || config["db"] == nil {
+		t.Errorf("unexpected %v %v %v", config, secrets, err)
+	}
+
+	config, secrets, err = Load(encrypted, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := config["db"].(map[interface{}]interface{})
+	if db["password"] != "db-pass" || db["port"] != 5432 || !reflect.DeepEqual(secrets, map[string]string{"db.password": "db-pass"}) {
+		t.Errorf("unexpected %v %v", config, secrets)
+	}
+
+	if _, _, err := Load(encrypted, filepath.Join(dir, "missing.key")); err == nil {
+		t.Error("expected missing key file error")
+	}
+
+	// 未指定密钥文件时使用环境变量中的密钥
+	t.Setenv(KeyEnv, key.String())
+	if _, secrets, err = Load(encrypted, ""); err != nil || secrets["db.password"] != "db-pass" {
+		t.Errorf("unexpected %v %v", secrets, err)
+	}
+	t.Setenv(KeyEnv, "")
+	t.Setenv("HOME", dir)
+	if _, _, err := Load(encrypted, ""); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected no key, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	recordPrefix = "record/" // record/<id>/<start> -> Record
+	timePrefix   = "time/"   // time/<start>/<id> -> record key
+)
+
+// BadgerStore 基于badger的本地执行记录存储
+type BadgerStore struct {
+	db *badger.DB
+}
+
+// assert at compile time that BadgerStore implements the Store interface
+var _ Store = (*BadgerStore)(nil)
+
+// NewBadgerStore 打开指定目录的存储, 同一目录同时只能被一个进程打开
+func NewBadgerStore(dir string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(dir).WithLoggingLevel(badger.ERROR)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerStore{db: db}, nil
+}
+
+// OpenBadgerStore 打开存储, 目录被其他进程占用时在timeout内重试
+func OpenBadgerStore(dir string, timeout time.Duration) (*BadgerStore, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		store, err := NewBadgerStore(dir)
+		if err == nil || time.Now().After(deadline) {
+			return store, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// keyEscaper 转义key中的ID, 避免包含"/"的ID与其他ID的记录前缀冲突
+var keyEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// recordKey 执行记录的key前缀, record/<escaped id>/
+func recordKey(id string) string {
+	return recordPrefix + keyEscaper.Replace(id) + "/"
+}
+
+// nano时间戳补齐位数，保证按字典序即时间序
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// Save 保存执行记录
+func (s *BadgerStore) Save(rec *Record) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ts := timeKey(rec.StartTime)
+	key := recordKey(rec.ID) + ts
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(key), buf); err != nil {
+			return err
+		}
+		return txn.Set([]byte(timePrefix+ts+"/"+keyEscaper.Replace(rec.ID)), []byte(key))
+	})
+}
+
+// Get 获取指定ID最近一次的执行记录
+func (s *BadgerStore) Get(id string) (*Record, error) {
+	var rec *Record
+	prefix := []byte(recordKey(id))
+	err := s.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = prefix
+		opt.Reverse = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		// 反向遍历需要从前缀的最大值开始
+		it.Seek(append(append([]byte{}, prefix...), 0xFF))
+		if !it.ValidForPrefix(prefix) {
+			return ErrRecordNotFound
+		}
+		return it.Item().Value(func(val []byte) error {
+			rec = &Record{}
+			return json.Unmarshal(val, rec)
+		})
+	})
+	return rec, err
+}
+
+// List 按开始时间倒序返回执行记录
+func (s *BadgerStore) List(filter Filter) ([]*Record, error) {
+	records := []*Record{}
+	prefix := []byte(timePrefix)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.Prefix = prefix
+		opt.Reverse = true
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Seek(append(append([]byte{}, prefix...), 0xFF)); it.ValidForPrefix(prefix); it.Next() {
+			recordKey, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			item, err := txn.Get(recordKey)
+			if err != nil {
+				// 索引存在但记录已被删除
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				return err
+			}
+			rec := &Record{}
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, rec)
+			}); err != nil {
+				return err
+			}
+			if filter.Name != "" && !strings.Contains(rec.Name, filter.Name) {
+				continue
+			}
+			if filter.Status != "" && rec.Status != filter.Status {
+				continue
+			}
+			records = append(records, rec)
+			if filter.Limit > 0 && len(records) >= filter.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Close 关闭存储
+func (s *BadgerStore) Close() error {
+	return s.db.Close()
+}
+
+// DirStore 每次读写时打开目录中的BadgerStore并在结束后关闭,
+// 长期运行的server和schedule使用它保存记录, 不会一直占用目录导致history命令和apply等待超时
+type DirStore struct {
+	sync.Mutex // 同一进程内串行打开, 避免互相等待目录锁
+	dir        string
+	timeout    time.Duration
+}
+
+// assert at compile time that DirStore implements the Store interface
+var _ Store = (*DirStore)(nil)
+
+// NewDirStore 创建存储, 目录被其他进程占用时在timeout内重试
+func NewDirStore(dir string, timeout time.Duration) *DirStore {
+	return &DirStore{dir: dir, timeout: timeout}
+}
+
+func (s *DirStore) with(fn func(store *BadgerStore) error) error {
+	s.Lock()
+	defer s.Unlock()
+	store, err := OpenBadgerStore(s.dir, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return fn(store)
+}
+
+// Save 保存执行记录
+func (s *DirStore) Save(rec *Record) error {
+	return s.with(func(store *BadgerStore) error {
+		return store.Save(rec)
+	})
+}
+
+// Get 获取指定ID最近一次的执行记录
+func (s *DirStore) Get(id string) (rec *Record, err error) {
+	err = s.with(func(store *BadgerStore) error {
+		rec, err = store.Get(id)
+		return err
+	})
+	return rec, err
+}
+
+// List 按开始时间倒序返回执行记录
+func (s *DirStore) List(filter Filter) (records []*Record, err error) {
+	err = s.with(func(store *BadgerStore) error {
+		records, err = store.List(filter)
+		return err
+	})
+	return records, err
+}
+
+// Close 存储只在读写期间打开, 无需关闭
+func (s *DirStore) Close() error {
+	return nil
+}
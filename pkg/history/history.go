@@ -0,0 +1,60 @@
+// Package history 持久化记录每次脚本执行的结果, 便于事后查询
+package history
+
+import (
+	"errors"
+	"time"
+
+	"github.com/superops-team/hyperops/pkg/ops/event"
+)
+
+const (
+	StatusSucceed = "succeed"
+	StatusFailed  = "failed"
+)
+
+var (
+	// ErrRecordNotFound 未找到执行记录
+	ErrRecordNotFound = errors.New("history record not found")
+)
+
+// Record 单次执行记录
+type Record struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Tags        string                 `json:"tags,omitempty"`
+	ScriptPath  string                 `json:"script_path,omitempty"`
+	StartTime   time.Time              `json:"start_time"`
+	EndTime     time.Time              `json:"end_time"`
+	Status      string                 `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	Limit       string                 `json:"limit,omitempty"` // 因超出资源限制失败时触发的限制
+	Output      string                 `json:"output,omitempty"`
+	Truncated   bool                   `json:"truncated,omitempty"`
+	Transitions []event.TaskEvent      `json:"transitions,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// Duration 执行耗时
+func (r *Record) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// Filter 查询条件
+type Filter struct {
+	Name   string // 按任务名过滤
+	Status string // 按最终状态过滤
+	Limit  int    // 返回的最大条数, <=0表示不限制
+}
+
+// Store 执行记录存储接口
+type Store interface {
+	// Save 保存执行记录, 同一个ID多次执行会保存多条记录
+	Save(rec *Record) error
+	// Get 获取指定ID最近一次的执行记录
+	Get(id string) (*Record, error)
+	// List 按开始时间倒序返回执行记录
+	List(filter Filter) ([]*Record, error)
+	// Close 关闭存储
+	Close() error
+}
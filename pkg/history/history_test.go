@@ -0,0 +1,192 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/event"
+)
+
+func TestBadgerStore(t *testing.T) {
+	store, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	records := []*Record{
+		{ID: "a", Name: "deploy", Status: StatusSucceed, StartTime: now.Add(-3 * time.Minute)},
+		{ID: "b", Name: "backup", Status: StatusFailed, StartTime: now.Add(-2 * time.Minute)},
+		{ID: "a", Name: "deploy", Status: StatusFailed, StartTime: now.Add(-1 * time.Minute)},
+	}
+	for _, rec := range records {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, err := store.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != StatusFailed {
+		t.Errorf("get should return the latest run, got status %s", rec.Status)
+	}
+	if _, err := store.Get("c"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	cases := []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{}, []string{"a", "b", "a"}},
+		{Filter{Limit: 2}, []string{"a", "b"}},
+		{Filter{Name: "deploy"}, []string{"a", "a"}},
+		{Filter{Status: StatusFailed}, []string{"a", "b"}},
+		{Filter{Name: "backup", Status: StatusSucceed}, []string{}},
+	}
+	for _, c := range cases {
+		got, err := store.List(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, rec := range got {
+			ids = append(ids, rec.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(c.want, ",") {
+			t.Errorf("list %+v: expected %v, got %v", c.filter, c.want, ids)
+		}
+	}
+}
+
+func TestBadgerStoreSlashID(t *testing.T) {
+	store, err := NewBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	for _, rec := range []*Record{
+		{ID: "a", Status: StatusSucceed, StartTime: now.Add(-time.Minute)},
+		{ID: "a/b", Status: StatusFailed, StartTime: now},
+		{ID: "a%2Fb", Status: StatusSucceed, StartTime: now},
+	} {
+		if err := store.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, status := range map[string]string{"a": StatusSucceed, "a/b": StatusFailed, "a%2Fb": StatusSucceed} {
+		rec, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.ID != id || rec.Status != status {
+			t.Errorf("get %s: unexpected record %s %s", id, rec.ID, rec.Status)
+		}
+	}
+	if _, err := store.Get("a/"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewDirStore(dir, time.Second)
+	if err := store.Save(&Record{ID: "a", Status: StatusSucceed, StartTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// 保存后不占用目录, 其他进程可以打开
+	other, err := NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("store should be closed after save: %v", err)
+	}
+	if _, err := other.Get("a"); err != nil {
+		t.Error(err)
+	}
+	if _, err := store.List(Filter{}); err == nil {
+		t.Error("list should time out while the dir is opened")
+	}
+	other.Close()
+
+	records, err := store.List(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "a" {
+		t.Errorf("unexpected records %v", records)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := event.NewBus(ctx)
+
+	r := NewRecorder(&Record{ID: "job", Name: "test"})
+	r.Subscribe(bus)
+	_ = bus.PublishID(ctx, event.ETTask, "job", event.TaskEvent{ID: "job", From: "init", To: "running"})
+	_ = bus.PublishID(ctx, event.ETPrint, "job", event.PrintEvent{ID: "job", Msg: "hello"})
+	_ = bus.PublishID(ctx, event.ETPrint, "other", event.PrintEvent{ID: "other", Msg: "ignored"})
+	_ = bus.PublishID(ctx, event.ETData, "job", event.DataEvent{ID: "job", Data: map[string]interface{}{"k": "v"}})
+
+	rec := r.Finish(errors.New("boom"))
+	if rec.Output != "hello\n" {
+		t.Errorf("unexpected output %q", rec.Output)
+	}
+	if len(rec.Transitions) != 1 || rec.Transitions[0].To != "running" {
+		t.Errorf("unexpected transitions %+v", rec.Transitions)
+	}
+	if rec.Data["k"] != "v" {
+		t.Errorf("unexpected data %+v", rec.Data)
+	}
+	if rec.Status != StatusFailed || rec.Error != "boom" {
+		t.Errorf("unexpected result %s %s", rec.Status, rec.Error)
+	}
+}
+
+func TestRecorderTruncate(t *testing.T) {
+	r := NewRecorder(&Record{ID: "job"})
+	msg := strings.Repeat("x", MaxOutputSize/2)
+	for i := 0; i < 3; i++ {
+		_ = r.Handle(context.Background(), event.MakeEvent(event.ETPrint, "job", event.PrintEvent{Msg: msg}))
+	}
+	rec := r.Finish(nil)
+	if !rec.Truncated || len(rec.Output) != MaxOutputSize {
+		t.Errorf("expected output truncated to %d, got %d", MaxOutputSize, len(rec.Output))
+	}
+	if rec.Status != StatusSucceed {
+		t.Errorf("unexpected status %s", rec.Status)
+	}
+}
+
+func TestRecorderTruncateUTF8(t *testing.T) {
+	r := NewRecorder(&Record{ID: "job"})
+	// 每个字符3个字节, 输出上限不是3的整数倍时截断点落在字符中间
+	msg := strings.Repeat("日志", MaxOutputSize/6+1)
+	_ = r.Handle(context.Background(), event.MakeEvent(event.ETPrint, "job", event.PrintEvent{Msg: msg}))
+	rec := r.Finish(nil)
+	if !rec.Truncated || len(rec.Output) > MaxOutputSize || len(rec.Output) < MaxOutputSize-utf8.UTFMax {
+		t.Errorf("expected output truncated to about %d bytes, got %d", MaxOutputSize, len(rec.Output))
+	}
+	if !utf8.ValidString(rec.Output) {
+		t.Error("truncated output is not valid UTF-8")
+	}
+}
+
+func TestRecorderLimit(t *testing.T) {
+	r := NewRecorder(&Record{ID: "job"})
+	err := fmt.Errorf("exec failed: %w", &localctx.LimitError{Limit: localctx.LimitOutput, Max: 100})
+	if rec := r.Finish(err); rec.Limit != localctx.LimitOutput {
+		t.Errorf("expected limit %s, got %q", localctx.LimitOutput, rec.Limit)
+	}
+}
@@ -0,0 +1,85 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/superops-team/hyperops/pkg/ops/event"
+)
+
+// MaxOutputSize 单条记录保存的最大输出字节数，超出部分丢弃
+const MaxOutputSize = 4 << 20
+
+// Recorder 订阅任务事件，在执行结束后生成执行记录
+type Recorder struct {
+	sync.Mutex
+	rec    *Record
+	output strings.Builder
+}
+
+// NewRecorder 创建记录器, rec中需要填充任务的基本信息
+func NewRecorder(rec *Record) *Recorder {
+	if rec.StartTime.IsZero() {
+		rec.StartTime = time.Now()
+	}
+	return &Recorder{rec: rec}
+}
+
+// Subscribe 订阅指定任务在bus上的事件
+func (r *Recorder) Subscribe(bus event.Bus) {
+	bus.SubscribeID(r.Handle, r.rec.ID)
+}
+
+// Handle 处理打印、状态变更以及数据事件
+func (r *Recorder) Handle(_ context.Context, e event.Event) error {
+	r.Lock()
+	defer r.Unlock()
+	switch payload := e.Payload.(type) {
+	case event.PrintEvent:
+		r.appendOutput(payload.Msg + "\n")
+	case event.TaskEvent:
+		r.rec.Transitions = append(r.rec.Transitions, payload)
+	case event.DataEvent:
+		r.rec.Data = payload.Data
+	}
+	return nil
+}
+
+func (r *Recorder) appendOutput(msg string) {
+	if r.rec.Truncated {
+		return
+	}
+	if r.output.Len()+len(msg) > MaxOutputSize {
+		// 在字符的起始位置截断, 避免截断多字节字符后记录不是合法的UTF-8
+		n := MaxOutputSize - r.output.Len()
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		r.output.WriteString(msg[:n])
+		r.rec.Truncated = true
+		return
+	}
+	r.output.WriteString(msg)
+}
+
+// Finish 根据执行结果生成最终记录
+func (r *Recorder) Finish(err error) *Record {
+	r.Lock()
+	defer r.Unlock()
+	r.rec.EndTime = time.Now()
+	r.rec.Output = r.output.String()
+	r.rec.Status = StatusSucceed
+	if err != nil {
+		r.rec.Status = StatusFailed
+		r.rec.Error = err.Error()
+		var le interface{ LimitName() string }
+		if errors.As(err, &le) {
+			r.rec.Limit = le.LimitName()
+		}
+	}
+	return r.rec
+}
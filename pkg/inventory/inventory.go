@@ -0,0 +1,307 @@
+// Package inventory 主机清单, 支持YAML和INI两种格式, 用于脚本批量操作主机
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// AllGroup 包含所有主机的隐式分组
+	AllGroup = "all"
+	// UngroupedGroup 未归属任何分组的主机
+	UngroupedGroup = "ungrouped"
+)
+
+// Host 主机及其变量
+type Host struct {
+	Name   string
+	Groups []string               // 直接或间接所属的分组, 不包括all
+	Vars   map[string]interface{} // 合并后的变量, 优先级: 主机 > 子分组 > 父分组 > all
+}
+
+// Group 主机分组
+type Group struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]interface{}
+}
+
+// Inventory 主机清单
+type Inventory struct {
+	hosts  map[string]map[string]interface{} // 主机名 -> 主机变量
+	order  []string                          // 主机在清单中出现的顺序
+	groups map[string]*Group
+}
+
+// New 创建空的主机清单
+func New() *Inventory {
+	return &Inventory{
+		hosts:  make(map[string]map[string]interface{}),
+		groups: map[string]*Group{AllGroup: {Name: AllGroup, Vars: map[string]interface{}{}}},
+	}
+}
+
+// Load 读取主机清单, .yaml/.yml后缀按YAML解析, 其余按INI解析
+func Load(file string) (*Inventory, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var inv *Inventory
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		inv, err = ParseYAML(buf)
+	default:
+		inv, err = ParseINI(buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory %s: %w", file, err)
+	}
+	return inv, nil
+}
+
+// group 获取或创建分组
+func (inv *Inventory) group(name string) *Group {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &Group{Name: name, Vars: map[string]interface{}{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// addHost 添加主机到分组, 主机变量合并
+func (inv *Inventory) addHost(group, name string, vars map[string]interface{}) {
+	hostVars, ok := inv.hosts[name]
+	if !ok {
+		hostVars = map[string]interface{}{}
+		inv.hosts[name] = hostVars
+		inv.order = append(inv.order, name)
+	}
+	for k, v := range vars {
+		hostVars[k] = v
+	}
+	if group == "" || group == AllGroup {
+		return
+	}
+	g := inv.group(group)
+	for _, h := range g.Hosts {
+		if h == name {
+			return
+		}
+	}
+	g.Hosts = append(g.Hosts, name)
+}
+
+// addChild 添加子分组
+func (inv *Inventory) addChild(parent, child string) {
+	g := inv.group(parent)
+	inv.group(child)
+	for _, c := range g.Children {
+		if c == child {
+			return
+		}
+	}
+	g.Children = append(g.Children, child)
+}
+
+// validate 检查分组之间不存在循环引用, 并将不属于任何分组的主机归入ungrouped
+func (inv *Inventory) validate() error {
+	state := map[string]int{} // 1: visiting, 2: done
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("group cycle detected: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, child := range inv.groups[name].Children {
+			if err := visit(child, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, name := range inv.GroupNames() {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	grouped := map[string]bool{}
+	for _, g := range inv.groups {
+		for _, h := range g.Hosts {
+			grouped[h] = true
+		}
+	}
+	for _, h := range inv.order {
+		if !grouped[h] {
+			inv.addHost(UngroupedGroup, h, nil)
+		}
+	}
+	return nil
+}
+
+// GroupNames 返回所有分组名, 按名称排序
+func (inv *Inventory) GroupNames() []string {
+	names := make([]string, 0, len(inv.groups))
+	for name := range inv.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupHosts 返回分组及其子分组中的所有主机
+func (inv *Inventory) groupHosts(name string, set map[string]bool) {
+	if name == AllGroup {
+		for _, h := range inv.order {
+			set[h] = true
+		}
+		return
+	}
+	g := inv.groups[name]
+	for _, h := range g.Hosts {
+		set[h] = true
+	}
+	for _, child := range g.Children {
+		inv.groupHosts(child, set)
+	}
+}
+
+// match 匹配单个分组名或主机名
+func (inv *Inventory) match(name string) (map[string]bool, error) {
+	set := map[string]bool{}
+	if _, ok := inv.groups[name]; ok {
+		inv.groupHosts(name, set)
+		return set, nil
+	}
+	if _, ok := inv.hosts[name]; ok {
+		set[name] = true
+		return set, nil
+	}
+	return nil, fmt.Errorf("no group or host named %q in inventory", name)
+}
+
+// Hosts 按模式匹配主机, 结果按清单中的顺序返回.
+// 模式由分组名或主机名组成, 使用:或,分隔表示并集, &前缀表示交集, !前缀表示排除, 例如"web:db:&prod:!web3"
+func (inv *Inventory) Hosts(pattern string) ([]*Host, error) {
+	if pattern == "" {
+		pattern = AllGroup
+	}
+	selected := map[string]bool{}
+	var intersections, exclusions []map[string]bool
+	for _, term := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' }) {
+		term = strings.TrimSpace(term)
+		var set map[string]bool
+		var err error
+		switch {
+		case strings.HasPrefix(term, "&"):
+			set, err = inv.match(term[1:])
+			intersections = append(intersections, set)
+		case strings.HasPrefix(term, "!"):
+			set, err = inv.match(term[1:])
+			exclusions = append(exclusions, set)
+		default:
+			set, err = inv.match(term)
+			for h := range set {
+				selected[h] = true
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := []*Host{}
+	for _, name := range inv.order {
+		if !selected[name] {
+			continue
+		}
+		keep := true
+		for _, set := range intersections {
+			keep = keep && set[name]
+		}
+		for _, set := range exclusions {
+			keep = keep && !set[name]
+		}
+		if keep {
+			hosts = append(hosts, inv.Host(name))
+		}
+	}
+	return hosts, nil
+}
+
+// contains 分组及其子分组中是否包含主机
+func (inv *Inventory) contains(group, host string) bool {
+	g := inv.groups[group]
+	for _, h := range g.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	for _, child := range g.Children {
+		if inv.contains(child, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// depth 分组的层级, 顶层分组为1
+func (inv *Inventory) depth(group string) int {
+	d := 0
+	for _, g := range inv.groups {
+		for _, child := range g.Children {
+			if child == group {
+				if pd := inv.depth(g.Name); pd > d {
+					d = pd
+				}
+			}
+		}
+	}
+	return d + 1
+}
+
+// Host 获取主机及合并后的变量, 主机不存在时返回nil
+func (inv *Inventory) Host(name string) *Host {
+	hostVars, ok := inv.hosts[name]
+	if !ok {
+		return nil
+	}
+	host := &Host{Name: name, Vars: map[string]interface{}{}}
+	for _, group := range inv.GroupNames() {
+		if group != AllGroup && inv.contains(group, name) {
+			host.Groups = append(host.Groups, group)
+		}
+	}
+	// 父分组的变量先合并, 同层级按名称排序
+	groups := append([]string(nil), host.Groups...)
+	depths := make(map[string]int, len(groups))
+	for _, g := range groups {
+		depths[g] = inv.depth(g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return depths[groups[i]] < depths[groups[j]]
+	})
+	for k, v := range inv.groups[AllGroup].Vars {
+		host.Vars[k] = v
+	}
+	for _, g := range groups {
+		for k, v := range inv.groups[g].Vars {
+			host.Vars[k] = v
+		}
+	}
+	for k, v := range hostVars {
+		host.Vars[k] = v
+	}
+	return host
+}
@@ -0,0 +1,115 @@
+package inventory
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func names(hosts []*Host) []string {
+	result := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		result = append(result, h.Name)
+	}
+	return result
+}
+
+func TestLoadYAML(t *testing.T) {
+	inv, err := Load("testdata/hosts.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inv.Hosts("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(hosts), []string{"db1", "web1", "web2", "web3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("prod hosts = %v, want %v", got, want)
+	}
+
+	web2 := inv.Host("web2")
+	want := map[string]interface{}{"user": "deploy", "http_port": 9090, "env": "prod", "role": "frontend"}
+	if !reflect.DeepEqual(web2.Vars, want) {
+		t.Errorf("web2 vars = %v, want %v", web2.Vars, want)
+	}
+	if got, want := web2.Groups, []string{"prod", "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("web2 groups = %v, want %v", got, want)
+	}
+	if got := inv.Host("db1").Vars["role"]; got != "primary" {
+		t.Errorf("db1 role = %v, want primary", got)
+	}
+	if got := inv.Host("bastion").Groups; !reflect.DeepEqual(got, []string{UngroupedGroup}) {
+		t.Errorf("bastion groups = %v, want [ungrouped]", got)
+	}
+	if inv.Host("missing") != nil {
+		t.Error("missing host should be nil")
+	}
+}
+
+func TestLoadINI(t *testing.T) {
+	inv, err := Load("testdata/hosts.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inv.GroupNames(), []string{"all", "db", "prod", "ungrouped", "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+	web2 := inv.Host("web2")
+	want := map[string]interface{}{"http_port": 9090, "motd": "hello world", "tls": true, "env": "prod"}
+	if !reflect.DeepEqual(web2.Vars, want) {
+		t.Errorf("web2 vars = %v, want %v", web2.Vars, want)
+	}
+	if got := inv.Host("bastion").Vars["ansible_port"]; got != 2222 {
+		t.Errorf("bastion port = %v, want 2222", got)
+	}
+}
+
+func TestHostsPattern(t *testing.T) {
+	inv, err := Load("testdata/hosts.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"", []string{"bastion", "web1", "web2", "web3", "db1"}},
+		{"all:!prod", []string{"bastion"}},
+		{"web:db", []string{"web1", "web2", "web3", "db1"}},
+		{"web,bastion", []string{"bastion", "web1", "web2", "web3"}},
+		{"prod:&web:!web3", []string{"web1", "web2"}},
+		{"db1", []string{"db1"}},
+	}
+	for _, c := range cases {
+		hosts, err := inv.Hosts(c.pattern)
+		if err != nil {
+			t.Errorf("pattern %q: %v", c.pattern, err)
+			continue
+		}
+		if got := names(hosts); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("pattern %q = %v, want %v", c.pattern, got, c.want)
+		}
+	}
+	if _, err := inv.Hosts("cache"); err == nil {
+		t.Error("unknown group should return error")
+	}
+}
+
+func TestInvalidInventory(t *testing.T) {
+	cases := map[string]string{
+		"cycle":   "[a:children]\nb\n[b:children]\na\n",
+		"section": "[web\nweb1\n",
+		"kind":    "[web:hosts2]\nweb1\n",
+		"var":     "[web:vars]\nport\n",
+		"quote":   "[web]\nweb1 motd=\"hello\n",
+	}
+	for name, content := range cases {
+		if _, err := ParseINI([]byte(content)); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+	_, err := ParseYAML([]byte("a:\n  children:\n    b:\n      children:\n        a:\n"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("yaml cycle error = %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlGroup YAML清单中的分组定义, 与ansible的YAML清单格式兼容
+//
+//	all:
+//	  vars:
+//	    user: deploy
+//	  children:
+//	    web:
+//	      hosts:
+//	        web1:
+//	        web2: {port: 2222}
+//	      vars:
+//	        http_port: 80
+type yamlGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlGroup             `yaml:"children"`
+}
+
+// ParseYAML 解析YAML格式的主机清单
+func ParseYAML(buf []byte) (*Inventory, error) {
+	groups := map[string]*yamlGroup{}
+	if err := yaml.UnmarshalStrict(buf, &groups); err != nil {
+		return nil, err
+	}
+	inv := New()
+	var add func(name string, g *yamlGroup)
+	add = func(name string, g *yamlGroup) {
+		group := inv.group(name)
+		if g == nil {
+			return
+		}
+		for k, v := range g.Vars {
+			group.Vars[k] = v
+		}
+		for _, host := range sortedHosts(g.Hosts) {
+			inv.addHost(name, host, g.Hosts[host])
+		}
+		for _, child := range sortedGroups(g.Children) {
+			if name != AllGroup {
+				inv.addChild(name, child)
+			}
+			add(child, g.Children[child])
+		}
+	}
+	for _, name := range sortedGroups(groups) {
+		add(name, groups[name])
+	}
+	if err := inv.validate(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// sortedGroups 按名称排序的分组, 保证解析结果稳定
+func sortedGroups(m map[string]*yamlGroup) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedHosts YAML的map无法保留书写顺序, 主机按名称排序
+func sortedHosts(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseINI 解析INI格式的主机清单, 与ansible的INI清单格式兼容
+//
+//	web0 port=2222
+//	[web]
+//	web1 role=frontend
+//	web2
+//	[web:vars]
+//	http_port=80
+//	[prod:children]
+//	web
+func ParseINI(buf []byte) (*Inventory, error) {
+	inv := New()
+	section, kind := AllGroup, "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %s", lineno, line)
+			}
+			section, kind = strings.TrimSpace(line[1:len(line)-1]), "hosts"
+			if i := strings.LastIndex(section, ":"); i >= 0 {
+				section, kind = section[:i], section[i+1:]
+			}
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty group name", lineno)
+			}
+			switch kind {
+			case "hosts", "vars", "children":
+			default:
+				return nil, fmt.Errorf("line %d: unknown section type %s", lineno, kind)
+			}
+			inv.group(section)
+			continue
+		}
+
+		switch kind {
+		case "hosts":
+			fields, err := splitFields(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+			vars := map[string]interface{}{}
+			for _, field := range fields[1:] {
+				k, v, err := parseVar(field)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineno, err)
+				}
+				vars[k] = v
+			}
+			inv.addHost(section, fields[0], vars)
+		case "vars":
+			k, v, err := parseVar(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineno, err)
+			}
+			inv.group(section).Vars[k] = v
+		case "children":
+			inv.addChild(section, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := inv.validate(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// splitFields 按空白分割, 支持引号包含空格
+func splitFields(line string) ([]string, error) {
+	var (
+		fields []string
+		cur    strings.Builder
+		quote  rune
+	)
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			cur.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", line)
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// parseVar 解析key=value, 值按YAML标量解析得到数字和布尔类型
+func parseVar(s string) (string, interface{}, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", nil, fmt.Errorf("invalid variable %s, should be key=value", s)
+	}
+	key, raw := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		return key, raw, nil
+	}
+	switch value.(type) {
+	case string, int, float64, bool:
+		// 带引号的字符串会去掉引号
+		return key, value, nil
+	}
+	return key, raw, nil
+}
@@ -0,0 +1,34 @@
+package localexec
+
+import "bytes"
+
+// LimitedBuffer 最多保存Max字节, 超出的部分丢弃并标记Truncated, Max<=0时不限制
+type LimitedBuffer struct {
+	Max       int64
+	Truncated bool
+	buf       bytes.Buffer
+}
+
+// NewLimitedBuffer 创建最多保存max字节的buffer
+func NewLimitedBuffer(max int64) *LimitedBuffer {
+	return &LimitedBuffer{Max: max}
+}
+
+// Write 实现io.Writer, 超出上限时仍然返回len(p)使命令继续执行
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if b.Max > 0 {
+		if left := b.Max - int64(b.buf.Len()); left < int64(len(p)) {
+			b.Truncated = true
+			if left > 0 {
+				b.buf.Write(p[:left])
+			}
+			return len(p), nil
+		}
+	}
+	return b.buf.Write(p)
+}
+
+// String 返回保存的内容
+func (b *LimitedBuffer) String() string {
+	return b.buf.String()
+}
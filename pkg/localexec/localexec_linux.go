@@ -26,6 +26,8 @@ type Result struct {
 	Code   int    `json:"code"`
 	Stdout string `json:"stdout"`
 	Stderr string `json:"stderr"`
+	// Stdout超出上限被截断
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // ExecCmd 执行linux命令
@@ -58,37 +60,35 @@ func ExecCmd(timeout time.Duration, dir string, cmdName string, args ...string)
 
 // ExecBatchCmdS 批量执行shell命令
 func ExecBatchCmdS(timeout time.Duration, dir string, cmds string) (*Result, error) {
-	ctx, cancle := context.WithTimeout(context.Background(), timeout)
-	defer cancle()
-	cmd := exec.CommandContext(ctx, "sh", "-c", cmds) // ByteSec: ignore RCE
-	cmd.Dir = dir
-
-	outBuf := bytes.NewBuffer(make([]byte, 0))
-	outWriter := bufio.NewWriter(outBuf)
-	cmd.Stdout = outWriter
+	return ExecBatchCmdSLimit(timeout, dir, cmds, 0)
+}
 
-	errBuf := bytes.NewBuffer(make([]byte, 0))
-	errWriter := bufio.NewWriter(errBuf)
-	cmd.Stderr = errWriter
+// ExecBatchCmdSLimit 批量执行shell命令, 最多保存maxStdout字节的标准输出, maxStdout<=0时不限制
+func ExecBatchCmdSLimit(timeout time.Duration, dir string, cmds string, maxStdout int64) (*Result, error) {
+	return ExecBatchCmdSContext(context.Background(), timeout, dir, cmds, maxStdout)
+}
 
-	exitCode := 0
-	err := cmd.Run()
-	if exitError, ok := err.(*exec.ExitError); ok {
-		ws := exitError.Sys().(syscall.WaitStatus)
-		exitCode = ws.ExitStatus()
-		err = nil
-	}
-	return &Result{
-		Code:   exitCode,
-		Stdout: outBuf.String(),
-		Stderr: errBuf.String(),
-	}, err
+// ExecBatchCmdSContext 批量执行shell命令, ctx取消时结束命令并返回ctx的错误
+func ExecBatchCmdSContext(ctx context.Context, timeout time.Duration, dir string, cmds string, maxStdout int64) (*Result, error) {
+	cmd := exec.Command("sh", "-c", cmds) // ByteSec: ignore RCE
+	cmd.Dir = dir
+	return runCmd(ctx, timeout, cmd, maxStdout)
 }
 
 // ExecRestrictedBatchCmdS 批量执行受限的shell命令
 // relativePath中不得包含..
 // cmds中不得包含/的前缀或..
 func ExecRestrictedBatchCmdS(timeout time.Duration, basedir, relativePath, cmds string) (*Result, error) {
+	return ExecRestrictedBatchCmdSLimit(timeout, basedir, relativePath, cmds, 0)
+}
+
+// ExecRestrictedBatchCmdSLimit 批量执行受限的shell命令, 最多保存maxStdout字节的标准输出
+func ExecRestrictedBatchCmdSLimit(timeout time.Duration, basedir, relativePath, cmds string, maxStdout int64) (*Result, error) {
+	return ExecRestrictedBatchCmdSContext(context.Background(), timeout, basedir, relativePath, cmds, maxStdout)
+}
+
+// ExecRestrictedBatchCmdSContext 批量执行受限的shell命令, ctx取消时结束命令并返回ctx的错误
+func ExecRestrictedBatchCmdSContext(ctx context.Context, timeout time.Duration, basedir, relativePath, cmds string, maxStdout int64) (*Result, error) {
 	err := ValidateRelativePath(relativePath)
 	if err != nil {
 		return nil, err
@@ -97,31 +97,52 @@ func ExecRestrictedBatchCmdS(timeout time.Duration, basedir, relativePath, cmds
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancle := context.WithTimeout(context.Background(), timeout)
-	defer cancle()
-
-	cmd := exec.CommandContext(ctx, "sh", "-c", cmds) // ByteSec: ignore RCE
+	cmd := exec.Command("sh", "-c", cmds) // ByteSec: ignore RCE
 	cmd.Dir = basedir + "/" + relativePath
+	return runCmd(ctx, timeout, cmd, maxStdout)
+}
 
-	outBuf := bytes.NewBuffer(make([]byte, 0))
-	outWriter := bufio.NewWriter(outBuf)
-	cmd.Stdout = outWriter
+// runCmd 执行命令并收集输出, 非0退出码不作为错误返回, 超时时返回被结束的命令的结果
+func runCmd(parent context.Context, timeout time.Duration, cmd *exec.Cmd, maxStdout int64) (*Result, error) {
+	ctx, cancle := context.WithTimeout(parent, timeout)
+	defer cancle()
+
+	outBuf := NewLimitedBuffer(maxStdout)
+	cmd.Stdout = outBuf
 
 	errBuf := bytes.NewBuffer(make([]byte, 0))
 	errWriter := bufio.NewWriter(errBuf)
 	cmd.Stderr = errWriter
 
+	// 命令在独立的进程组中执行, 结束时一起结束后台子进程, 否则子进程持有输出管道, 命令无法返回
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
 	exitCode := 0
-	err = cmd.Run()
 	if exitError, ok := err.(*exec.ExitError); ok {
 		ws := exitError.Sys().(syscall.WaitStatus)
 		exitCode = ws.ExitStatus()
 		err = nil
 	}
+	if err == nil && parent.Err() != nil {
+		err = parent.Err()
+	}
 	return &Result{
-		Code:   exitCode,
-		Stdout: outBuf.String(),
-		Stderr: errBuf.String(),
+		Code:      exitCode,
+		Stdout:    outBuf.String(),
+		Stderr:    errBuf.String(),
+		Truncated: outBuf.Truncated,
 	}, err
 }
 
@@ -1,6 +1,8 @@
 package localexec
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	_ "os"
 	"testing"
@@ -60,3 +62,22 @@ func TestExecWithNilConfig(t *testing.T) {
 	}
 	fmt.Printf("res: %v\n", res)
 }
+
+func TestExecContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	// 后台的sleep持有输出管道, 需要结束整个进程组才能返回
+	_, err := ExecBatchCmdSContext(ctx, 10*time.Second, "", "sleep 10 & sleep 10; echo done", 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command should be killed with the context, took %s", elapsed)
+	}
+
+	res, err := ExecBatchCmdSContext(context.Background(), 200*time.Millisecond, "", "sleep 10 & sleep 10", 0)
+	if err != nil || res.Code != -1 {
+		t.Errorf("timeout should return the killed command, got %v %v", res, err)
+	}
+}
@@ -67,6 +67,30 @@ var (
 		},
 		[]string{"name"},
 	)
+	// LimitExceededCount 超出资源限制的次数, limit为steps/output/shell_stdout/heap
+	LimitExceededCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hyperops_limit_exceeded_count",
+			Help: "Count the scripts exceeding the resource limits",
+		},
+		[]string{"name", "limit"},
+	)
+	// EventPublishFailedCount 发布失败的事件, 例如消费者超时未读取或者事件总线已关闭
+	EventPublishFailedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hyperops_event_publish_failed_count",
+			Help: "Count the events failed to publish",
+		},
+		[]string{"type"},
+	)
+	// ScheduleTriggerCount 定时任务触发统计, action为run/skip/queue/replace
+	ScheduleTriggerCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hyperops_schedule_trigger_count",
+			Help: "Count the triggers of the scheduled scripts",
+		},
+		[]string{"name", "action"},
+	)
 )
 
 func init() {
@@ -0,0 +1,101 @@
+package mod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cachePath 缓存目录按内容哈希区分, 同一个模块包的不同版本可以共存
+func cachePath(name, hash string) string {
+	return filepath.Join(CacheDir(), name, hashHex(hash))
+}
+
+// fetch 下载模块包到缓存目录, 返回锁定信息
+func fetch(name, source, version string) (*Locked, error) {
+	if err := os.MkdirAll(CacheDir(), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir(CacheDir(), ".fetch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, name)
+	resolved, err := Fetch(source, version, dir)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s from %s failed: %w", name, source, err)
+	}
+	hash, err := HashDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	locked := &Locked{Source: source, Version: version, Resolved: resolved, Hash: hash}
+	target := cachePath(name, hash)
+	if _, err := os.Stat(target); err == nil {
+		if err := Verify(target, hash); err == nil {
+			return locked, nil
+		}
+		// 缓存被修改过, 使用新下载的内容替换
+		if err := os.RemoveAll(target); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	return locked, os.Rename(dir, target)
+}
+
+// Get 下载模块包并锁定版本
+func Get(name string, req Require) (*Locked, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+	return fetch(name, req.Source, req.Version)
+}
+
+// Download 确保锁定的模块包在缓存中, 重新下载的内容与锁定的哈希不一致时返回错误
+func Download(name string, locked *Locked) (string, error) {
+	dir := cachePath(name, locked.Hash)
+	if err := Verify(dir, locked.Hash); err == nil {
+		return dir, nil
+	}
+	version := locked.Version
+	if locked.Resolved != "" {
+		version = locked.Resolved
+	}
+	got, err := fetch(name, locked.Source, version)
+	if err != nil {
+		return "", err
+	}
+	if got.Hash != locked.Hash {
+		return "", fmt.Errorf("%w: %s from %s has %s, want %s", ErrIntegrity, name, locked.Source, got.Hash, locked.Hash)
+	}
+	return dir, nil
+}
+
+// Resolve 返回lockDir下lockfile锁定的模块包目录, 优先使用vendor目录, 每次调用都会校验内容哈希
+func Resolve(lockDir, name string) (string, error) {
+	lock, err := LoadLock(lockDir)
+	if err != nil {
+		return "", err
+	}
+	locked, ok := lock.Packages[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s, run hyperops mod get %s <source>", ErrNotLocked, name, name)
+	}
+	dir := filepath.Join(lockDir, VendorDir, name)
+	if _, err := os.Stat(dir); err != nil {
+		dir = cachePath(name, locked.Hash)
+		if _, err := os.Stat(dir); err != nil {
+			return "", fmt.Errorf("module package %s is not in cache %s, run hyperops mod vendor or hyperops mod tidy", name, CacheDir())
+		}
+	}
+	if err := Verify(dir, locked.Hash); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
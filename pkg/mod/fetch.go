@@ -0,0 +1,214 @@
+package mod
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const fetchTimeout = 5 * time.Minute
+
+// Fetch 下载模块包到dst目录, 返回git仓库解析得到的commit.
+// 支持的来源:
+//
+//	git+https://host/repo.git, git+ssh://git@host/repo.git, git+file:///path/to/repo 或以.git结尾的地址
+//	https://host/pkg.tar.gz, http://host/pkg.tgz
+//	file:///path/to/dir, file:///path/to/pkg.tar.gz
+func Fetch(source, version, dst string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "git+"):
+		return fetchGit(strings.TrimPrefix(source, "git+"), version, dst)
+	case strings.HasSuffix(source, ".git"):
+		return fetchGit(source, version, dst)
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		if !isTarball(source) {
+			return "", fmt.Errorf("unsupported http source %s, should be a .tar.gz or .tgz file", source)
+		}
+		return "", fetchHTTP(source, dst)
+	case strings.HasPrefix(source, "file://"):
+		u, err := url.Parse(source)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(u.Path)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			return "", copyDir(u.Path, dst)
+		}
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return "", extractTarGz(f, dst)
+	}
+	return "", fmt.Errorf("unsupported module source %s", source)
+}
+
+func isTarball(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Path, ".tar.gz") || strings.HasSuffix(u.Path, ".tgz")
+}
+
+// fetchGit 克隆仓库并切换到指定版本, 删除.git目录后返回commit
+func fetchGit(repo, version, dst string) (string, error) {
+	if _, err := git("", "clone", "--quiet", repo, dst); err != nil {
+		return "", err
+	}
+	if version != "" {
+		if _, err := git(dst, "checkout", "--quiet", version); err != nil {
+			return "", err
+		}
+	}
+	commit, err := git(dst, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return commit, os.RemoveAll(filepath.Join(dst, ".git"))
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	// 禁止交互式输入用户名密码
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func fetchHTTP(source, dst string) error {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s failed: %s", source, resp.Status)
+	}
+	return extractTarGz(resp.Body, dst)
+}
+
+// extractTarGz 解压tar.gz到dst, 只有一个顶层目录时去掉该层目录
+func extractTarGz(r io.Reader, dst string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path %s in tarball", hdr.Name)
+		}
+		target := filepath.Join(dst, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return fmt.Errorf("unsupported file %s in tarball, only regular files are allowed", hdr.Name)
+		}
+	}
+	return stripSingleDir(dst)
+}
+
+func writeFile(file string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stripSingleDir 压缩包通常带有一层pkg-v1.0.0/目录, 将其内容移动到dir
+func stripSingleDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return nil
+	}
+	top := filepath.Join(dir, entries[0].Name())
+	children, err := ioutil.ReadDir(top)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := os.Rename(filepath.Join(top, child.Name()), filepath.Join(dir, child.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(top)
+}
+
+// copyDir 复制目录中的普通文件, 忽略.git目录
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("unsupported file %s in module package, only regular files are allowed", path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeFile(target, f)
+	})
+}
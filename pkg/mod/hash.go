@@ -0,0 +1,83 @@
+package mod
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const hashPrefix = "sha256:"
+
+// HashDir 计算目录内容哈希: 按相对路径排序后, 对每个文件的"sha256 路径"行再做sha256, 与文件的修改时间和权限无关
+func HashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("unsupported file %s in module package, only regular files are allowed", path)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range files {
+		sum, err := hashFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s  %s\n", sum, file)
+	}
+	return hashPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify 校验目录内容与哈希一致
+func Verify(dir, hash string) error {
+	got, err := HashDir(dir)
+	if err != nil {
+		return err
+	}
+	if got != hash {
+		return fmt.Errorf("%w: %s has %s, want %s", ErrIntegrity, dir, got, hash)
+	}
+	return nil
+}
+
+// hashHex 哈希值去掉算法前缀, 用作缓存目录名
+func hashHex(hash string) string {
+	return strings.TrimPrefix(hash, hashPrefix)
+}
@@ -0,0 +1,170 @@
+// Package mod 脚本模块包管理, 从git仓库或者http压缩包获取模块包, 按版本和内容哈希锁定在lockfile中,
+// 执行时通过load("@name//path.ops")从本地缓存离线加载
+package mod
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ManifestFile 声明依赖的模块包, 与脚本放在同一目录
+	ManifestFile = "hyperops.mod"
+	// LockFile 锁定模块包的版本和内容哈希
+	LockFile = "hyperops.lock"
+	// VendorDir 执行mod vendor后模块包所在目录, 加载时优先使用
+	VendorDir = "hyperops_modules"
+	// CacheEnv 模块包缓存目录的环境变量, 默认为$HOME/.hyperops/mod
+	CacheEnv = "HYPEROPS_MOD_CACHE"
+)
+
+var (
+	// ErrNoLockFile 没有找到lockfile
+	ErrNoLockFile = errors.New("no " + LockFile + " found, run hyperops mod get first")
+	// ErrNotLocked 模块包未在lockfile中锁定
+	ErrNotLocked = errors.New("module package is not locked")
+	// ErrIntegrity 模块包内容与lockfile中的哈希不一致
+	ErrIntegrity = errors.New("module package integrity check failed")
+
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+)
+
+// Require 依赖的模块包
+type Require struct {
+	Source  string `yaml:"source"`            // git+https://host/repo.git, https://host/pkg.tar.gz, file:///path
+	Version string `yaml:"version,omitempty"` // git的tag/分支/commit, 压缩包的版本标记
+}
+
+// Manifest hyperops.mod文件
+//
+//	requires:
+//	  netops:
+//	    source: git+https://github.com/example/netops.git
+//	    version: v1.2.0
+type Manifest struct {
+	Requires map[string]Require `yaml:"requires"`
+}
+
+// Locked 锁定的模块包
+type Locked struct {
+	Source   string `yaml:"source"`
+	Version  string `yaml:"version,omitempty"`
+	Resolved string `yaml:"resolved,omitempty"` // git仓库解析得到的commit
+	Hash     string `yaml:"hash"`               // 模块包内容哈希, sha256:<hex>
+}
+
+// Lock hyperops.lock文件
+type Lock struct {
+	Packages map[string]*Locked `yaml:"packages"`
+}
+
+// ValidName 模块包名只能包含字母数字和_.-
+func ValidName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid module package name %q", name)
+	}
+	return nil
+}
+
+// LoadManifest 读取dir下的hyperops.mod, 文件不存在时返回空清单
+func LoadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{}
+	if err := loadYAML(filepath.Join(dir, ManifestFile), m); err != nil {
+		return nil, err
+	}
+	if m.Requires == nil {
+		m.Requires = map[string]Require{}
+	}
+	return m, nil
+}
+
+// Save 保存到dir下的hyperops.mod
+func (m *Manifest) Save(dir string) error {
+	return saveYAML(filepath.Join(dir, ManifestFile), m)
+}
+
+// Names 按名称排序的依赖
+func (m *Manifest) Names() []string {
+	names := make([]string, 0, len(m.Requires))
+	for name := range m.Requires {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadLock 读取dir下的hyperops.lock, 文件不存在时返回空的lock
+func LoadLock(dir string) (*Lock, error) {
+	l := &Lock{}
+	if err := loadYAML(filepath.Join(dir, LockFile), l); err != nil {
+		return nil, err
+	}
+	if l.Packages == nil {
+		l.Packages = map[string]*Locked{}
+	}
+	return l, nil
+}
+
+// Save 保存到dir下的hyperops.lock
+func (l *Lock) Save(dir string) error {
+	return saveYAML(filepath.Join(dir, LockFile), l)
+}
+
+func loadYAML(file string, v interface{}) error {
+	buf, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(buf, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", file, err)
+	}
+	return nil
+}
+
+// saveYAML 先写临时文件再重命名, 避免中断时留下不完整的文件
+func saveYAML(file string, v interface{}) error {
+	buf, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// CacheDir 模块包缓存目录
+func CacheDir() string {
+	env := environment.NewEnvStorage()
+	_ = environment.InitEnvironmentVariables(env)
+	if dir := env.Get(CacheEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(env.Get("HOME"), ".hyperops", "mod")
+}
+
+// FindLock 从dir开始向上查找hyperops.lock, 最多查找到root为止
+func FindLock(dir, root string) (string, error) {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, LockFile)); err == nil {
+			return d, nil
+		}
+		parent := filepath.Dir(d)
+		if d == root || parent == d {
+			return "", ErrNoLockFile
+		}
+		d = parent
+	}
+}
@@ -0,0 +1,224 @@
+package mod
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func setCache(t *testing.T) string {
+	dir := t.TempDir()
+	old, ok := os.LookupEnv(CacheEnv)
+	os.Setenv(CacheEnv, dir)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(CacheEnv, old)
+		} else {
+			os.Unsetenv(CacheEnv)
+		}
+	})
+	return dir
+}
+
+func TestHashDir(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	files := map[string]string{"bgp.ops": "def peer(): pass\n", "lib/util.ops": "x = 1\n"}
+	writeFiles(t, a, files)
+	writeFiles(t, b, files)
+	ha, err := HashDir(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, _ := HashDir(b)
+	if ha != hb || !strings.HasPrefix(ha, "sha256:") {
+		t.Fatalf("hash of the same content should be equal, got %s and %s", ha, hb)
+	}
+	writeFiles(t, b, map[string]string{"lib/util.ops": "x = 2\n"})
+	if err := Verify(b, ha); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+}
+
+func TestGetHTTP(t *testing.T) {
+	setCache(t)
+	content := tarball(t, map[string]string{"netops-1.0.0/bgp.ops": "def peer(): pass\n"})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/netops-1.0.0.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(dir); err == nil {
+		t.Error("init twice should fail")
+	}
+	locked, err := Add(dir, "netops", Require{Source: srv.URL + "/netops-1.0.0.tar.gz", Version: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := Resolve(dir, "netops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 压缩包中的顶层目录会被去掉
+	if _, err := os.Stat(filepath.Join(pkg, "bgp.ops")); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := LoadLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Packages["netops"].Hash != locked.Hash {
+		t.Errorf("lockfile mismatch: %+v", lock.Packages["netops"])
+	}
+
+	// 缓存被修改后加载失败
+	writeFiles(t, pkg, map[string]string{"bgp.ops": "def peer(): fail('hacked')\n"})
+	if _, err := Resolve(dir, "netops"); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+	if _, err := Resolve(dir, "missing"); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("expected not locked error, got %v", err)
+	}
+	if _, err := Get("netops", Require{Source: srv.URL + "/missing.tar.gz"}); err == nil {
+		t.Error("expected download error")
+	}
+}
+
+func TestGetGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	setCache(t)
+	repo := t.TempDir()
+	writeFiles(t, repo, map[string]string{"bgp.ops": "version = 1\n"})
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	run("init", "--quiet")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "v1")
+	run("tag", "v1.0.0")
+	writeFiles(t, repo, map[string]string{"bgp.ops": "version = 2\n"})
+	run("commit", "--quiet", "-am", "v2")
+
+	dir := t.TempDir()
+	locked, err := Add(dir, "netops", Require{Source: "git+file://" + repo, Version: "v1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked.Resolved) != 40 {
+		t.Errorf("expected resolved commit, got %q", locked.Resolved)
+	}
+	pkg, err := Resolve(dir, "netops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(filepath.Join(pkg, "bgp.ops"))
+	if string(buf) != "version = 1\n" {
+		t.Errorf("expected content of v1.0.0, got %q", buf)
+	}
+	if _, err := os.Stat(filepath.Join(pkg, ".git")); err == nil {
+		t.Error(".git should be removed from the package")
+	}
+}
+
+func TestTidyAndVendor(t *testing.T) {
+	cache := setCache(t)
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"bgp.ops": "def peer(): pass\n"})
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"main.ops": `load("@netops//bgp.ops", "peer")` + "\n",
+	})
+	m := &Manifest{Requires: map[string]Require{
+		"netops": {Source: "file://" + src},
+		"unused": {Source: "file://" + src},
+	}}
+	if err := m.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Tidy(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Removed, ",") != "unused" || strings.Join(result.Fetched, ",") != "netops" {
+		t.Errorf("unexpected tidy result %+v", result)
+	}
+	lock, _ := LoadLock(dir)
+	if _, ok := lock.Packages["unused"]; ok || lock.Packages["netops"] == nil {
+		t.Errorf("unexpected lock %+v", lock.Packages)
+	}
+
+	if err := Vendor(dir); err != nil {
+		t.Fatal(err)
+	}
+	// 缓存清空后使用vendor目录离线加载
+	os.RemoveAll(cache)
+	pkg, err := Resolve(dir, "netops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg != filepath.Join(dir, VendorDir, "netops") {
+		t.Errorf("expected vendor dir, got %s", pkg)
+	}
+
+	writeFiles(t, dir, map[string]string{"other.ops": `load("@dnsops//zone.ops", "zone")` + "\n"})
+	if _, err := Tidy(dir); err == nil || !strings.Contains(err.Error(), "dnsops") {
+		t.Errorf("expected missing require error, got %v", err)
+	}
+}
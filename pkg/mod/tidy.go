@@ -0,0 +1,176 @@
+package mod
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// loadPattern 匹配脚本中load("@name//...")引用的模块包
+var loadPattern = regexp.MustCompile(`load\(\s*["']@([A-Za-z0-9_][A-Za-z0-9_.-]*)//`)
+
+// Init 在dir下创建空的hyperops.mod
+func Init(dir string) error {
+	file := filepath.Join(dir, ManifestFile)
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists", file)
+	}
+	return (&Manifest{Requires: map[string]Require{}}).Save(dir)
+}
+
+// Add 添加或者更新依赖, 下载后写入hyperops.mod和hyperops.lock
+func Add(dir, name string, req Require) (*Locked, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := LoadLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := Get(name, req)
+	if err != nil {
+		return nil, err
+	}
+	m.Requires[name] = req
+	lock.Packages[name] = locked
+	if err := m.Save(dir); err != nil {
+		return nil, err
+	}
+	return locked, lock.Save(dir)
+}
+
+// Used 扫描dir下的脚本, 返回引用的模块包名
+func Used(dir string) ([]string, error) {
+	used := map[string]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (info.Name() == VendorDir || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".ops" && ext != ".star" {
+			return nil
+		}
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range loadPattern.FindAllSubmatch(buf, -1) {
+			used[string(m[1])] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// TidyResult 整理依赖的结果
+type TidyResult struct {
+	Removed []string // 未被脚本引用而删除的依赖
+	Fetched []string // 新锁定或者版本变化后重新锁定的依赖
+}
+
+// Tidy 删除未被引用的依赖, 锁定hyperops.mod中新增或修改的依赖, 并确保所有锁定的模块包都在缓存中
+func Tidy(dir string) (*TidyResult, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := LoadLock(dir)
+	if err != nil {
+		return nil, err
+	}
+	used, err := Used(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TidyResult{}
+	var missing []string
+	usedSet := map[string]bool{}
+	for _, name := range used {
+		usedSet[name] = true
+		if _, ok := m.Requires[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("module packages %s are used but not required, run hyperops mod get <name> <source>", strings.Join(missing, ", "))
+	}
+	for _, name := range m.Names() {
+		if !usedSet[name] {
+			delete(m.Requires, name)
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for name := range lock.Packages {
+		if _, ok := m.Requires[name]; !ok {
+			delete(lock.Packages, name)
+		}
+	}
+	for _, name := range m.Names() {
+		req := m.Requires[name]
+		locked, ok := lock.Packages[name]
+		if !ok || locked.Source != req.Source || locked.Version != req.Version {
+			if locked, err = Get(name, req); err != nil {
+				return nil, err
+			}
+			lock.Packages[name] = locked
+			result.Fetched = append(result.Fetched, name)
+			continue
+		}
+		if _, err := Download(name, locked); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.Save(dir); err != nil {
+		return nil, err
+	}
+	return result, lock.Save(dir)
+}
+
+// Vendor 将锁定的模块包复制到dir下的hyperops_modules目录, 用于无法访问缓存的环境
+func Vendor(dir string) error {
+	lock, err := LoadLock(dir)
+	if err != nil {
+		return err
+	}
+	if len(lock.Packages) == 0 {
+		return errors.New("no module packages locked in " + LockFile)
+	}
+	vendor := filepath.Join(dir, VendorDir)
+	if err := os.RemoveAll(vendor); err != nil {
+		return err
+	}
+	for name, locked := range lock.Packages {
+		src, err := Download(name, locked)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(vendor, name)
+		if err := copyDir(src, dst); err != nil {
+			return err
+		}
+		if err := Verify(dst, locked.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package ops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// 静态检查发现的问题类别
+const (
+	CheckSyntax        = "syntax"         // 语法错误
+	CheckUndefined     = "undefined"      // 未定义的名称
+	CheckResolve       = "resolve"        // 其他名称解析错误, 例如重复定义
+	CheckUnknownModule = "unknown-module" // load了不存在的模块
+	CheckUnknownMember = "unknown-member" // 模块中不存在的成员
+	CheckUnusedLoad    = "unused-load"    // load后未使用的名称
+	CheckArity         = "arity"          // 内置函数的参数个数或名称错误
+)
+
+// Diagnostic 静态检查发现的问题, 位置为问题所在的文件, 行和列
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// String file:line:col: message (kind)
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.File, d.Line, d.Col, d.Message, d.Kind)
+}
+
+// signature 内置函数的参数, 用于检查调用时的参数个数和名称
+type signature struct {
+	params     []string // 参数名, 按位置顺序
+	required   int      // 前required个参数必填
+	positional bool     // 只接受位置参数
+}
+
+// builtinSignatures 参数固定的内置函数, 可变参数的函数(print, min, dict等)不做检查
+var builtinSignatures = map[string]signature{
+	// 预声明的内置函数
+	"sh":    {params: []string{"cmd", "dir", "timeout", "host"}, required: 1},
+	"sleep": {params: []string{"dur"}, required: 1, positional: true},
+	"step":  {params: []string{"name", "fn"}, required: 2},
+	"param": {params: []string{"type", "default", "required", "choices", "secret", "help"}},
+	// starlark内置函数
+	"abs":       {params: []string{"x"}, required: 1, positional: true},
+	"all":       {params: []string{"iterable"}, required: 1, positional: true},
+	"any":       {params: []string{"iterable"}, required: 1, positional: true},
+	"bool":      {params: []string{"x"}, positional: true},
+	"chr":       {params: []string{"i"}, required: 1, positional: true},
+	"dir":       {params: []string{"x"}, required: 1, positional: true},
+	"enumerate": {params: []string{"iterable", "start"}, required: 1, positional: true},
+	"float":     {params: []string{"x"}, positional: true},
+	"getattr":   {params: []string{"x", "name", "default"}, required: 2, positional: true},
+	"hasattr":   {params: []string{"x", "name"}, required: 2, positional: true},
+	"hash":      {params: []string{"x"}, required: 1, positional: true},
+	"int":       {params: []string{"x", "base"}, required: 1},
+	"len":       {params: []string{"x"}, required: 1, positional: true},
+	"list":      {params: []string{"iterable"}, positional: true},
+	"ord":       {params: []string{"s"}, required: 1, positional: true},
+	"range":     {params: []string{"start", "stop", "step"}, required: 1, positional: true},
+	"repr":      {params: []string{"x"}, required: 1, positional: true},
+	"reversed":  {params: []string{"iterable"}, required: 1, positional: true},
+	"set":       {params: []string{"iterable"}, positional: true},
+	"sorted":    {params: []string{"iterable", "key", "reverse"}, required: 1},
+	"str":       {params: []string{"x"}, required: 1, positional: true},
+	"tuple":     {params: []string{"iterable"}, positional: true},
+	"type":      {params: []string{"x"}, required: 1, positional: true},
+}
+
+// checkedModule 已检查的本地模块, globals为nil表示模块无法解析
+type checkedModule struct {
+	done    bool
+	globals starlark.StringDict
+}
+
+// checker 不执行脚本, 按执行时相同的内置函数和模块解析脚本及其load的本地模块
+type checker struct {
+	loader      *fileLoader
+	predeclared starlark.StringDict
+	dialect     Dialect
+	thread      *starlark.Thread
+	modules     map[string]*checkedModule
+	diags       []*Diagnostic
+}
+
+// CheckScript 静态检查脚本, 报告语法错误, 未定义的名称, 不存在的模块和成员, 未使用的load以及内置函数的参数错误
+// 脚本load的本地模块同样会被检查, 返回的问题按文件和位置排序
+func CheckScript(target *Target, opts ...func(o *ExecOpts)) ([]*Diagnostic, error) {
+	o := &ExecOpts{}
+	DefaultExecOpts(o)
+	for _, opt := range opts {
+		if opt == nil {
+			return nil, fmt.Errorf("nil option passed to CheckScript")
+		}
+		opt(o)
+	}
+	c := &checker{
+		predeclared: newPredeclared(localctx.NewContext(nil, nil), starlark.None),
+		dialect:     dialectOf(o),
+		thread:      &starlark.Thread{Name: "check"},
+		modules:     make(map[string]*checkedModule),
+	}
+	moduleLoader := o.ModuleLoader
+	if o.TestReporter != nil || strings.HasSuffix(target.ScriptPath, TestSuffix) {
+		// 测试脚本按测试模式检查, 可以load("assert.star")
+		moduleLoader = testModuleLoader(moduleLoader)
+	}
+	loader, err := newFileLoader(target, o.ModuleRoot, moduleLoader, c.predeclared, c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	c.loader = loader
+
+	var src interface{}
+	if len(target.ScriptContent) > 0 {
+		src = target.ScriptContent
+	}
+	c.checkFile(target.ScriptPath, "", src)
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		a, b := c.diags[i], c.diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+	return c.diags, nil
+}
+
+func (c *checker) report(pos syntax.Position, kind, format string, args ...interface{}) {
+	c.diags = append(c.diags, &Diagnostic{
+		File:    pos.Filename(),
+		Line:    int(pos.Line),
+		Col:     int(pos.Col),
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkFile 检查一个文件, path为模块的绝对路径, 入口脚本为空, 返回文件的全局名称
+func (c *checker) checkFile(name, path string, src interface{}) starlark.StringDict {
+	f, err := c.dialect.Parse(name, src)
+	if err != nil {
+		if serr, ok := err.(syntax.Error); ok {
+			c.report(serr.Pos, CheckSyntax, "%s", serr.Msg)
+		} else {
+			c.report(syntax.MakePosition(&name, 1, 1), CheckSyntax, "%s", err)
+		}
+		return nil
+	}
+	err = resolve.File(f, c.predeclared.Has, starlark.Universe.Has)
+	if errs, ok := err.(resolve.ErrorList); ok {
+		for _, e := range errs {
+			kind := CheckResolve
+			if strings.HasPrefix(e.Msg, "undefined:") {
+				kind = CheckUndefined
+			}
+			c.report(e.Pos, kind, "%s", e.Msg)
+		}
+	}
+
+	// 内置模块中的成员值, 用于检查模块成员的访问
+	// 函数中使用的load名称会成为新的free绑定, 因此按绑定的首个标识符, 即load语句中的名称索引
+	values := map[*syntax.Ident]starlark.Value{}
+	// 模块或成员不存在时已经报告, 不再报告未使用
+	missing := map[*syntax.Ident]bool{}
+	var loads []*syntax.LoadStmt
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		loads = append(loads, load)
+		members := c.loadModule(path, load)
+		if members == nil {
+			for _, to := range load.To {
+				missing[to] = true
+			}
+			continue
+		}
+		for i, from := range load.From {
+			// 以_开头的名称已经由resolve报告
+			if strings.HasPrefix(from.Name, "_") {
+				continue
+			}
+			v, ok := members[from.Name]
+			if !ok {
+				c.report(from.NamePos, CheckUnknownMember, "module %q has no member %s", load.ModuleName(), from.Name)
+				missing[load.To[i]] = true
+				continue
+			}
+			if v != nil {
+				values[load.To[i]] = v
+			}
+		}
+	}
+
+	uses := map[*syntax.Ident]int{}
+	for _, stmt := range f.Stmts {
+		if _, ok := stmt.(*syntax.LoadStmt); ok {
+			continue
+		}
+		syntax.Walk(stmt, func(n syntax.Node) bool {
+			switch n := n.(type) {
+			case *syntax.Ident:
+				if b, ok := n.Binding.(*resolve.Binding); ok && b.First != nil {
+					uses[b.First]++
+				}
+			case *syntax.DotExpr:
+				c.checkMember(n, values)
+			case *syntax.CallExpr:
+				c.checkCall(n)
+			}
+			return true
+		})
+	}
+	for _, load := range loads {
+		for _, to := range load.To {
+			if uses[to] == 0 && !missing[to] {
+				c.report(to.NamePos, CheckUnusedLoad, "%s loaded from %q is not used", to.Name, load.ModuleName())
+			}
+		}
+	}
+
+	globals := starlark.StringDict{}
+	if m, ok := f.Module.(*resolve.Module); ok {
+		for _, b := range m.Globals {
+			if b.First != nil {
+				globals[b.First.Name] = nil
+			}
+		}
+	}
+	return globals
+}
+
+// loadModule 与fileLoader.Load相同的顺序查找模块, 返回模块的成员, 本地模块的成员值为nil, 模块不存在或无法解析时返回nil
+func (c *checker) loadModule(from string, load *syntax.LoadStmt) starlark.StringDict {
+	module := load.ModuleName()
+	pos := load.Module.TokenPos
+	if !isLocalModule(module) {
+		dict, err := c.loader.fallback(c.thread, module)
+		if err == nil {
+			return dict
+		}
+		// 内置模块中不存在时, 再尝试同名的本地文件
+		path, _ := c.loader.resolveFrom(from, module)
+		if info, statErr := os.Stat(path); statErr != nil || info.IsDir() {
+			c.report(pos, CheckUnknownModule, "%s", err)
+			return nil
+		}
+	}
+	path, err := c.loader.resolveFrom(from, module)
+	if err != nil {
+		c.report(pos, CheckUnknownModule, "%s", err)
+		return nil
+	}
+	if m, ok := c.modules[path]; ok {
+		if !m.done {
+			c.report(pos, CheckUnknownModule, "load cycle detected: %s is loading", c.loader.display(path))
+		}
+		return m.globals
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.report(pos, CheckUnknownModule, "load %s: %s", module, err)
+		return nil
+	}
+	m := &checkedModule{}
+	c.modules[path] = m
+	m.globals = c.checkFile(c.loader.display(path), path, src)
+	m.done = true
+	return m.globals
+}
+
+// checkMember 检查内置模块和预声明结构体的成员访问, 例如time.nwo和ctx.get_confg
+func (c *checker) checkMember(dot *syntax.DotExpr, values map[*syntax.Ident]starlark.Value) {
+	x, ok := dot.X.(*syntax.Ident)
+	if !ok {
+		return
+	}
+	b, ok := x.Binding.(*resolve.Binding)
+	if !ok {
+		return
+	}
+	var v starlark.Value
+	switch {
+	case b.Scope == resolve.Predeclared:
+		v = c.predeclared[x.Name]
+	case b.First != nil:
+		v = values[b.First]
+	}
+	var names []string
+	switch v := v.(type) {
+	case *starlarkstruct.Module:
+		names = v.AttrNames()
+	case *starlarkstruct.Struct:
+		names = v.AttrNames()
+	default:
+		return
+	}
+	for _, name := range names {
+		if name == dot.Name.Name {
+			return
+		}
+	}
+	c.report(dot.Name.NamePos, CheckUnknownMember, "%s has no member %s", x.Name, dot.Name.Name)
+}
+
+// checkCall 按内置函数的参数检查调用, 使用*args或**kwargs的调用不做检查
+func (c *checker) checkCall(call *syntax.CallExpr) {
+	fn, ok := call.Fn.(*syntax.Ident)
+	if !ok {
+		return
+	}
+	b, ok := fn.Binding.(*resolve.Binding)
+	if !ok || (b.Scope != resolve.Predeclared && b.Scope != resolve.Universal) {
+		return
+	}
+	sig, ok := builtinSignatures[fn.Name]
+	if !ok {
+		return
+	}
+	npos := 0
+	var keywords []*syntax.Ident
+	for _, arg := range call.Args {
+		switch arg := arg.(type) {
+		case *syntax.UnaryExpr:
+			if arg.Op == syntax.STAR || arg.Op == syntax.STARSTAR {
+				return
+			}
+			npos++
+		case *syntax.BinaryExpr:
+			if arg.Op == syntax.EQ {
+				keywords = append(keywords, arg.X.(*syntax.Ident))
+				continue
+			}
+			npos++
+		default:
+			npos++
+		}
+	}
+
+	if npos > len(sig.params) {
+		c.report(fn.NamePos, CheckArity, "%s: got %d arguments, want at most %d", fn.Name, npos, len(sig.params))
+		return
+	}
+	filled := make([]bool, len(sig.params))
+	for i := 0; i < npos; i++ {
+		filled[i] = true
+	}
+	seen := map[string]bool{}
+	for _, kw := range keywords {
+		// 重复的关键字参数已经由resolve报告
+		if seen[kw.Name] {
+			return
+		}
+		seen[kw.Name] = true
+		if sig.positional {
+			c.report(kw.NamePos, CheckArity, "%s does not accept keyword arguments", fn.Name)
+			return
+		}
+		i := indexOf(sig.params, kw.Name)
+		if i < 0 {
+			c.report(kw.NamePos, CheckArity, "%s: unexpected keyword argument %s", fn.Name, kw.Name)
+			return
+		}
+		if filled[i] {
+			c.report(kw.NamePos, CheckArity, "%s: got multiple values for parameter %s", fn.Name, kw.Name)
+			return
+		}
+		filled[i] = true
+	}
+	for i := 0; i < sig.required; i++ {
+		if !filled[i] {
+			c.report(fn.NamePos, CheckArity, "%s: missing argument for %s", fn.Name, sig.params[i])
+			return
+		}
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,116 @@
+package ops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckScript(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".git/HEAD": "ref: refs/heads/master\n",
+		"lib/common.ops": `
+def drain_node(node):
+    return sh("kubectl drain %s" % node)
+`,
+		"cycle/a.ops": `load("b.ops", "b")
+a = b
+`,
+		"cycle/b.ops": `load("//cycle/a.ops", "a")
+b = a + c
+`,
+		"jobs/ok.ops": `
+load("time.star", "time")
+load("../lib/common.ops", "drain_node")
+p = params(replicas = param("int", default=1))
+
+def main():
+    step("drain", lambda: drain_node("n1"))
+    sleep("1s")
+    print(time.now(), ctx.get_config("cluster"), sorted([2, 1], reverse=True), len(range(p.replicas)))
+
+main()
+`,
+		"jobs/bad.ops": `
+load("time.star", "time")
+load("re.star", "re")
+load("nosuch.star", "foo")
+load("../lib/common.ops", "drain_node", "cordon_node")
+
+def main():
+    print(time.nwo(), ctx.get_confg("cluster"), undefined_name)
+    drain_node("n1")
+    sleep("1s", "2s")
+    sh(dirr="/tmp")
+    step("drain")
+    len(x=[])
+    sorted([], key=len, key=len)
+`,
+		"jobs/cycle.ops": `load("../cycle/a.ops", "a")
+print(a)
+`,
+		"jobs/syntax.ops": `def main(:
+    pass
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(script string) []string {
+		target, err := NewTarget(filepath.Join(root, script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		diags, err := CheckScript(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, d := range diags {
+			file, _ := filepath.Rel(root, d.File)
+			if !filepath.IsAbs(d.File) {
+				file = d.File
+			}
+			found = append(found, fmt.Sprintf("%s:%d:%d %s", file, d.Line, d.Col, d.Kind))
+		}
+		return found
+	}
+
+	for script, expect := range map[string][]string{
+		"jobs/ok.ops": nil,
+		"jobs/bad.ops": {
+			"jobs/bad.ops:3:18 unused-load",
+			"jobs/bad.ops:4:6 unknown-module",
+			"jobs/bad.ops:5:42 unknown-member",
+			"jobs/bad.ops:8:16 unknown-member",
+			"jobs/bad.ops:8:27 unknown-member",
+			"jobs/bad.ops:8:49 undefined",
+			"jobs/bad.ops:10:5 arity",
+			"jobs/bad.ops:11:8 arity",
+			"jobs/bad.ops:12:5 arity",
+			"jobs/bad.ops:13:9 arity",
+			"jobs/bad.ops:14:25 resolve",
+		},
+		"jobs/cycle.ops": {
+			"cycle/b.ops:1:6 unknown-module",
+			"cycle/b.ops:2:9 undefined",
+		},
+		"jobs/syntax.ops": {
+			"jobs/syntax.ops:1:11 syntax",
+		},
+	} {
+		if found := check(script); !reflect.DeepEqual(found, expect) {
+			t.Errorf("%s: expected %v, got %v", script, expect, found)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package context
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"go.starlark.net/starlark"
+)
+
+// CheckpointFile 任务工作目录下保存checkpoint的文件名
+const CheckpointFile = ".hyperops_checkpoint.json"
+
+// StepRecord 已完成的步骤
+type StepRecord struct {
+	Name    string      `json:"name"`
+	Result  interface{} `json:"result,omitempty"`
+	EndTime time.Time   `json:"end_time"`
+}
+
+// Checkpoint 记录已完成的步骤以及最近一次完成步骤时的ctx.values快照,
+// 进程重启后使用相同的job id恢复执行时跳过已完成的步骤
+type Checkpoint struct {
+	sync.Mutex
+	dir    string
+	path   string
+	Steps  []StepRecord           `json:"steps"`
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// NewCheckpoint 在工作目录dir下创建空的checkpoint, 不会覆盖磁盘上已有的文件,
+// 第一个步骤完成时才创建目录和文件, dir为空时只在内存中记录
+func NewCheckpoint(dir string) *Checkpoint {
+	cp := &Checkpoint{dir: dir}
+	if dir != "" {
+		cp.path = path.Join(dir, CheckpointFile)
+	}
+	return cp
+}
+
+// LoadCheckpoint 读取工作目录dir下的checkpoint, 文件不存在时返回空的checkpoint
+func LoadCheckpoint(dir string) (*Checkpoint, error) {
+	cp := NewCheckpoint(dir)
+	if cp.path == "" {
+		return cp, nil
+	}
+	buf, err := ioutil.ReadFile(cp.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return nil, err
+	}
+	// 保留整数类型, 避免恢复后int变成float
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", cp.path, err)
+	}
+	for i := range cp.Steps {
+		cp.Steps[i].Result = fromJSON(cp.Steps[i].Result)
+	}
+	for k, v := range cp.Values {
+		cp.Values[k] = fromJSON(v)
+	}
+	return cp, nil
+}
+
+// Completed 返回已完成步骤的记录
+func (cp *Checkpoint) Completed(name string) (StepRecord, bool) {
+	cp.Lock()
+	defer cp.Unlock()
+	for _, step := range cp.Steps {
+		if step.Name == name {
+			return step, true
+		}
+	}
+	return StepRecord{}, false
+}
+
+// Complete 标记步骤完成并落盘, values为当前ctx.values快照
+func (cp *Checkpoint) Complete(name string, result interface{}, values map[string]interface{}) error {
+	cp.Lock()
+	defer cp.Unlock()
+	cp.Steps = append(cp.Steps, StepRecord{Name: name, Result: result, EndTime: time.Now()})
+	cp.Values = values
+	if cp.path == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cp.dir, 0700); err != nil {
+		return err
+	}
+	// 先写临时文件再rename, 避免写入过程中宕机导致checkpoint损坏,
+	// 步骤结果和ctx.values需要原样恢复, 不能替换其中的密码, 因此只允许当前用户读写
+	tmp := cp.path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// Remove 删除磁盘上的checkpoint
+func (cp *Checkpoint) Remove() error {
+	cp.Lock()
+	defer cp.Unlock()
+	cp.Steps = nil
+	cp.Values = nil
+	if cp.path == "" {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fromJSON 将json.Number转换为int64或float64, 以便util.Marshal处理
+func fromJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case []interface{}:
+		for i := range x {
+			x[i] = fromJSON(x[i])
+		}
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = fromJSON(x[k])
+		}
+	}
+	return v
+}
+
+// SetCheckpoint 绑定checkpoint, 并使用checkpoint中的快照恢复ctx.values
+func (c *Context) SetCheckpoint(cp *Checkpoint) error {
+	c.Lock()
+	defer c.Unlock()
+	for k, v := range cp.Values {
+		value, err := util.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("restore ctx value %s failed: %w", k, err)
+		}
+		c.values[k] = value
+	}
+	c.checkpoint = cp
+	c.steps = map[string]bool{}
+	return nil
+}
+
+// snapshot 导出ctx.values中可以序列化的值
+func (c *Context) snapshot() map[string]interface{} {
+	c.Lock()
+	defer c.Unlock()
+	values := make(map[string]interface{}, len(c.values))
+	for k, v := range c.values {
+		// 函数等无法序列化的值不做保存
+		if val, err := util.Unmarshal(v); err == nil {
+			values[k] = val
+		}
+	}
+	return values
+}
+
+// Step 执行具名步骤step(name, fn), 步骤完成后记录checkpoint, 恢复执行时跳过已完成的步骤并返回上次的结果
+func (c *Context) Step(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name string
+		fn   starlark.Callable
+	)
+	if err := starlark.UnpackArgs("step", args, kwargs, "name", &name, "fn", &fn); err != nil {
+		return starlark.None, err
+	}
+
+	c.Lock()
+	cp := c.checkpoint
+	if c.steps[name] {
+		c.Unlock()
+		return starlark.None, fmt.Errorf("step %s already run, step name must be unique", name)
+	}
+	if c.steps != nil {
+		c.steps[name] = true
+	}
+	c.Unlock()
+
+	if cp != nil {
+		if record, ok := cp.Completed(name); ok {
+			hookPrint(thread, fmt.Sprintf("[resume] step %s already completed at %s, skip", name, record.EndTime.Format(time.RFC3339)))
+			return util.Marshal(record.Result)
+		}
+	}
+
+	v, err := starlark.Call(thread, fn, nil, nil)
+	if err != nil {
+		return starlark.None, err
+	}
+	if cp == nil || IsDryRun(thread) {
+		return v, nil
+	}
+	// 无法序列化的返回值不做保存, 恢复时返回None
+	result, err := util.Unmarshal(v)
+	if err != nil {
+		result = nil
+	}
+	if err := cp.Complete(name, result, c.snapshot()); err != nil {
+		return starlark.None, fmt.Errorf("save checkpoint of step %s failed: %w", name, err)
+	}
+	return v, nil
+}
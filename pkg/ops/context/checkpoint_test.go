@@ -0,0 +1,96 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "job")
+	cp, err := LoadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cp.Completed("drain"); ok {
+		t.Fatal("empty checkpoint should have no completed step")
+	}
+	if PathExists(dir) {
+		t.Fatal("workdir should be created by the first completed step")
+	}
+	if err := cp.Complete("drain", []interface{}{1, "a"}, map[string]interface{}{"count": 3}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, CheckpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("checkpoint may contain secrets, expected mode 0600, got %s", info.Mode().Perm())
+	}
+
+	cp, err = LoadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, ok := cp.Completed("drain")
+	if !ok {
+		t.Fatal("step drain should be completed")
+	}
+	if result := record.Result.([]interface{}); result[0] != int64(1) || result[1] != "a" {
+		t.Errorf("unexpected step result %#v", record.Result)
+	}
+
+	ctx := NewContext(nil, nil)
+	if err := ctx.SetCheckpoint(cp); err != nil {
+		t.Fatal(err)
+	}
+	val, err := ctx.getValue(&starlark.Thread{}, nil, starlark.Tuple{starlark.String("count")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != starlark.MakeInt(3) {
+		t.Errorf("expected restored value 3, got %s", val)
+	}
+
+	if err := cp.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if PathExists(dir + "/" + CheckpointFile) {
+		t.Error("checkpoint file should be removed")
+	}
+}
+
+func TestStepDuplicateName(t *testing.T) {
+	ctx := NewContext(nil, nil)
+	if err := ctx.SetCheckpoint(NewCheckpoint(t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+	thread := &starlark.Thread{Print: SafePrint}
+	_, err := starlark.ExecFile(thread, "step.star", `
+step("a", lambda: 1)
+step("a", lambda: 2)
+`, starlark.StringDict{"step": starlark.NewBuiltin("step", ctx.Step)})
+	if err == nil || !strings.Contains(err.Error(), "step a already run") {
+		t.Errorf("expected duplicate step error, got %v", err)
+	}
+}
+
+func TestCheckpointInMemory(t *testing.T) {
+	cp := NewCheckpoint("")
+	if err := cp.Complete("drain", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cp.Completed("drain"); !ok {
+		t.Error("step drain should be completed")
+	}
+	if PathExists(CheckpointFile) {
+		t.Error("checkpoint without a workdir should not be saved")
+	}
+	if err := cp.Remove(); err != nil {
+		t.Fatal(err)
+	}
+}
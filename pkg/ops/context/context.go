@@ -4,10 +4,23 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/google/uuid"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 
 	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/secret"
+)
+
+const (
+	// CONTEXT_NAME thread local中保存运行时上下文的key, 供内置模块读取配置和密码
+	CONTEXT_NAME = "HYPEROPS_CONTEXT"
+	// INVENTORY_NAME thread local中保存主机清单的key
+	INVENTORY_NAME = "HYPEROPS_INVENTORY"
+	// SANDBOX_NAME thread local中保存沙箱策略的key
+	SANDBOX_NAME = "HYPEROPS_SANDBOX"
+	// TEST_REPORTER_NAME starlarktest.SetReporter使用的key, assert.star通过它报告失败
+	TEST_REPORTER_NAME = "Reporter"
 )
 
 // Context 当执行脚本时携带上下文
@@ -17,16 +30,16 @@ type Context struct {
 	values  starlark.StringDict
 	config  map[string]interface{}
 	secrets map[string]string
+	job     string // 本次执行的密码作用域, 由线程名和随机id组成, 同名的job并发执行时互不影响
+
+	providers secret.Providers // 解析secret://引用的密码后端
+
+	checkpoint *Checkpoint     // 步骤完成记录, 用于中断后恢复执行
+	steps      map[string]bool // 本次执行中已经运行过的步骤
 }
 
 // NewContext 创建上下文
 func NewContext(config map[string]interface{}, secrets map[string]string) *Context {
-	if secrets != nil {
-		sm := NewSecretsManager()
-		for _, v := range secrets {
-			sm.AddSecret(v)
-		}
-	}
 	return &Context{
 		results: starlark.StringDict{},
 		values:  starlark.StringDict{},
@@ -35,6 +48,84 @@ func NewContext(config map[string]interface{}, secrets map[string]string) *Conte
 	}
 }
 
+// Bind 将上下文绑定到线程, 内置模块通过FromThread获取, 上下文中的密码注册到本次执行的密码作用域中
+func (c *Context) Bind(thread *starlark.Thread) {
+	c.Lock()
+	if c.job == "" {
+		c.job = thread.Name + "#" + uuid.New().String()
+	}
+	sm := NewSecretsManager()
+	for _, v := range c.secrets {
+		if !secret.IsRef(v) {
+			sm.AddJobSecret(c.job, v)
+		}
+	}
+	c.Unlock()
+	thread.SetLocal(CONTEXT_NAME, c)
+}
+
+// FromThread 获取线程绑定的上下文, 未绑定时返回nil
+func FromThread(thread *starlark.Thread) *Context {
+	c, _ := thread.Local(CONTEXT_NAME).(*Context)
+	return c
+}
+
+// SetSecretProviders 设置解析secret://引用的密码后端
+func (c *Context) SetSecretProviders(providers secret.Providers) {
+	c.Lock()
+	defer c.Unlock()
+	c.providers = providers
+}
+
+// Secret 获取密码, 解析失败时返回false
+func (c *Context) Secret(key string) (string, bool) {
+	v, ok, err := c.LookupSecret(key)
+	return v, ok && err == nil
+}
+
+// LookupSecret 获取密码, secrets或者ctx配置中的secret://引用在第一次读取时解析
+func (c *Context) LookupSecret(key string) (string, bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.lookupSecret(key)
+}
+
+// lookupSecret 调用方需持有锁, 解析后的密码缓存在secrets中并注册到job密码
+func (c *Context) lookupSecret(key string) (string, bool, error) {
+	v, ok := c.secrets[key]
+	if !ok {
+		ref, isString := c.config[key].(string)
+		if !isString || !secret.IsRef(ref) {
+			return "", false, nil
+		}
+		v = ref
+	}
+	if !secret.IsRef(v) {
+		return v, true, nil
+	}
+	if c.providers == nil {
+		return "", false, fmt.Errorf("secret %s: no secret providers configured for %s", key, v)
+	}
+	resolved, err := c.providers.Resolve(v)
+	if err != nil {
+		return "", false, fmt.Errorf("secret %s: %w", key, err)
+	}
+	if c.secrets == nil {
+		c.secrets = make(map[string]string)
+	}
+	c.secrets[key] = resolved
+	NewSecretsManager().AddJobSecret(c.job, resolved)
+	return resolved, true, nil
+}
+
+// Config 获取配置项
+func (c *Context) Config(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.config[key]
+	return v, ok
+}
+
 // Struct 作为starlark.Struct方式传递
 func (c *Context) Struct() *starlarkstruct.Struct {
 	dict := starlark.StringDict{
@@ -98,10 +189,6 @@ func (c *Context) getValues(thread *starlark.Thread, _ *starlark.Builtin, args s
 
 // getSecret 获取加密信息
 func (c *Context) getSecret(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	if c.secrets == nil {
-		return starlark.None, fmt.Errorf("no secrets provided")
-	}
-
 	var key starlark.String
 
 	c.Lock()
@@ -110,7 +197,14 @@ func (c *Context) getSecret(thread *starlark.Thread, _ *starlark.Builtin, args s
 		return nil, err
 	}
 
-	return util.Marshal(c.secrets[string(key)])
+	v, ok, err := c.lookupSecret(string(key))
+	if err != nil {
+		return starlark.None, err
+	}
+	if !ok && c.secrets == nil {
+		return starlark.None, fmt.Errorf("no secrets provided")
+	}
+	return util.Marshal(v)
 }
 
 // setSecret 添加加密信息，添加完加密信息后print指令会屏蔽掉该加密信息
@@ -135,9 +229,8 @@ func (c *Context) setSecret(thread *starlark.Thread, _ *starlark.Builtin, args s
 		return starlark.None, err
 	}
 
-	// add secret to secrets manager
-	sm := NewSecretsManager()
-	sm.AddSecret(val)
+	// add secret to secrets manager, 只对当前job生效
+	NewSecretsManager().AddJobSecret(c.job, val)
 
 	c.secrets[key] = val
 	return starlark.None, nil
@@ -0,0 +1,28 @@
+package context
+
+import "go.starlark.net/starlark"
+
+// DEBUGGER_NAME thread local中保存调试器的key
+const DEBUGGER_NAME = "HYPEROPS_DEBUGGER"
+
+// Debugger 调试器, 绑定到job的主线程后由子线程继承
+type Debugger interface {
+	// Attach 接入线程的单步执行hook, 子线程(加载的模块, group/fleet的线程)创建时调用
+	Attach(thread *starlark.Thread)
+	// BeforeBuiltin 内置函数执行前调用, 调试器可以在调用所在的行暂停
+	BeforeBuiltin(thread *starlark.Thread)
+	// BuiltinError 内置函数返回错误时调用, 调试器可以在错误返回给脚本前暂停
+	BuiltinError(thread *starlark.Thread, name string, err error)
+}
+
+// BindDebugger 将调试器绑定到job的主线程
+func BindDebugger(thread *starlark.Thread, d Debugger) {
+	thread.SetLocal(DEBUGGER_NAME, d)
+	d.Attach(thread)
+}
+
+// DebuggerFromThread 获取线程绑定的调试器, 未绑定时返回nil
+func DebuggerFromThread(thread *starlark.Thread) Debugger {
+	d, _ := thread.Local(DEBUGGER_NAME).(Debugger)
+	return d
+}
@@ -0,0 +1,88 @@
+package context
+
+import (
+	"fmt"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// DRYRUN_STUBS_NAME thread local中保存dry-run自定义返回值的key
+	DRYRUN_STUBS_NAME = "HYPEROPS_DRYRUN_STUBS"
+	// DryRunStatus dry-run模式下被拦截调用的状态
+	DryRunStatus = "dryrun"
+)
+
+// DryRunStub 生成dry-run模式下被拦截函数的默认返回值
+type DryRunStub func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+// AddSideEffectBuiltin 注册有副作用的内置函数，dry-run模式下只记录调用并返回stub结果
+func AddSideEffectBuiltin(name string, f Function, stub DryRunStub) *starlark.Builtin {
+	return addBuiltin(name, f, stub, true)
+}
+
+// SetDryRun 设置线程的dry-run模式, stubs为按函数名自定义的返回值
+func SetDryRun(thread *starlark.Thread, stubs map[string]interface{}) error {
+	thread.SetLocal(DRYRUN_NAME, true)
+	if len(stubs) == 0 {
+		return nil
+	}
+	values := make(map[string]starlark.Value, len(stubs))
+	for name, stub := range stubs {
+		v, err := util.Marshal(stub)
+		if err != nil {
+			return fmt.Errorf("invalid dry-run stub for %s: %w", name, err)
+		}
+		values[name] = ToStruct(v)
+	}
+	thread.SetLocal(DRYRUN_STUBS_NAME, values)
+	return nil
+}
+
+// IsDryRun 是否处于dry-run模式, 线程设置或者环境变量HYPEROPS_DRYRUN均可开启
+func IsDryRun(thread *starlark.Thread) bool {
+	if enabled, ok := thread.Local(DRYRUN_NAME).(bool); ok && enabled {
+		return true
+	}
+	return environment.NewEnvStorage().IsTrue(DRYRUN_NAME)
+}
+
+// dryRun 记录将要执行的调用并返回stub结果
+func dryRun(thread *starlark.Thread, name string, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, stub DryRunStub) (starlark.Value, error) {
+	hookPrint(thread, Redact(thread, fmt.Sprintf("[dry-run] would run %s with args=%s, kwargs=%s", name, args, kwargs)))
+
+	if stubs, ok := thread.Local(DRYRUN_STUBS_NAME).(map[string]starlark.Value); ok {
+		if v, ok := stubs[name]; ok {
+			return v, nil
+		}
+	}
+	if stub != nil {
+		return stub(thread, fn, args, kwargs)
+	}
+	return starlark.None, nil
+}
+
+// ToStruct 将dict转换为struct，使得stub结果可以像真实返回值一样通过属性访问
+func ToStruct(v starlark.Value) starlark.Value {
+	dict, ok := v.(*starlark.Dict)
+	if !ok {
+		return v
+	}
+	sd := make(starlark.StringDict, dict.Len())
+	for _, item := range dict.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok {
+			return v
+		}
+		sd[key] = ToStruct(item[1])
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, sd)
+}
+
+// StubTrue dry-run模式下返回True
+func StubTrue(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	return starlark.True, nil
+}
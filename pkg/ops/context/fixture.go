@@ -0,0 +1,343 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FIXTURES_NAME thread local中保存录制或回放的调用的key
+	FIXTURES_NAME = "HYPEROPS_FIXTURES"
+	// ReplayStatus 回放的调用的状态
+	ReplayStatus = "replayed"
+
+	// struct和struct中的方法(例如http响应的body, json)在fixture中的标记
+	fixtureStruct  = "__struct__"
+	fixtureBuiltin = "__builtin__"
+	// yaml中整数值的浮点数会被读取为整数, 需要标记
+	fixtureFloat = "__float__"
+)
+
+// FixtureFuncs 录制和回放的内置函数
+var FixtureFuncs = []string{"sh", "shell.*", "ssh.*", "http.*", "fs.*"}
+
+// FixtureCall 一次录制的调用, 参数和结果中的密码已替换
+type FixtureCall struct {
+	Name   string      `yaml:"name"`
+	Args   string      `yaml:"args"`
+	Kwargs string      `yaml:"kwargs"`
+	Result interface{} `yaml:"result,omitempty"`
+	Error  string      `yaml:"error,omitempty"`
+
+	replayed bool
+}
+
+func (c *FixtureCall) key() string {
+	return c.Name + c.Args + c.Kwargs
+}
+
+// Fixtures 录制真实执行时内置函数的调用和结果, 回放时按函数名和参数依次返回录制的结果
+type Fixtures struct {
+	mu     sync.Mutex
+	Script string         `yaml:"script,omitempty"`
+	Calls  []*FixtureCall `yaml:"calls"`
+
+	replay bool
+	queues map[string][]*FixtureCall
+}
+
+// NewFixtures 创建录制用的fixtures
+func NewFixtures(script string) *Fixtures {
+	return &Fixtures{Script: script}
+}
+
+// LoadFixtures 读取录制的fixtures用于回放
+func LoadFixtures(file string) (*Fixtures, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixtures{}
+	if err := yaml.Unmarshal(buf, f); err != nil {
+		return nil, fmt.Errorf("invalid fixtures %s: %w", file, err)
+	}
+	f.replay = true
+	f.queues = make(map[string][]*FixtureCall)
+	for _, call := range f.Calls {
+		f.queues[call.key()] = append(f.queues[call.key()], call)
+	}
+	return f, nil
+}
+
+// Save 保存录制的调用
+func (f *Fixtures) Save(file string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	buf, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0600)
+}
+
+// Bind 绑定到线程, 之后FixtureFuncs中的内置函数调用会被录制或回放
+func (f *Fixtures) Bind(thread *starlark.Thread) {
+	thread.SetLocal(FIXTURES_NAME, f)
+}
+
+// FixturesFromThread 获取线程绑定的fixtures, 未绑定时返回nil
+func FixturesFromThread(thread *starlark.Thread) *Fixtures {
+	f, _ := thread.Local(FIXTURES_NAME).(*Fixtures)
+	return f
+}
+
+// Replaying 是否处于回放模式
+func (f *Fixtures) Replaying() bool {
+	return f.replay
+}
+
+// Unreplayed 回放结束后没有被调用的录制
+func (f *Fixtures) Unreplayed() []*FixtureCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []*FixtureCall
+	for _, call := range f.Calls {
+		if !call.replayed {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// fixtureFunc 内置函数是否需要录制或回放
+func fixtureFunc(name string) bool {
+	for _, f := range FixtureFuncs {
+		if ok, _ := path.Match(f, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// newFixtureCall 记录调用的函数名和参数, 参数按starlark格式输出后替换密码
+func newFixtureCall(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple) *FixtureCall {
+	return &FixtureCall{
+		Name:   name,
+		Args:   Redact(thread, args.String()),
+		Kwargs: Redact(thread, fmt.Sprintf("%s", kwargs)),
+	}
+}
+
+// record 录制一次调用的结果, 结果无法保存时记录原因, 回放时返回错误
+func (f *Fixtures) record(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple, res starlark.Value, err error) {
+	call := newFixtureCall(thread, name, args, kwargs)
+	if err != nil {
+		call.Error = Redact(thread, err.Error())
+	} else if res != nil {
+		v, encErr := encodeFixture(thread, res)
+		if encErr != nil {
+			call.Error = fmt.Sprintf("result of %s cannot be recorded: %s", name, encErr)
+		} else {
+			call.Result = RedactValue(thread, v)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls = append(f.Calls, call)
+}
+
+// replayCall 按函数名和参数返回下一个录制的结果, 没有录制时返回错误
+func (f *Fixtures) replayCall(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	call := newFixtureCall(thread, name, args, kwargs)
+	f.mu.Lock()
+	queue := f.queues[call.key()]
+	if len(queue) == 0 {
+		f.mu.Unlock()
+		return starlark.None, fmt.Errorf("replay: %s(args=%s, kwargs=%s) was not recorded", name, call.Args, call.Kwargs)
+	}
+	recorded := queue[0]
+	recorded.replayed = true
+	f.queues[call.key()] = queue[1:]
+	f.mu.Unlock()
+
+	if recorded.Error != "" {
+		return starlark.None, errors.New(recorded.Error)
+	}
+	return decodeFixture(recorded.Result)
+}
+
+// encodeFixture 将内置函数的返回值转换为可以保存的值, struct中的无参方法调用后保存结果
+func encodeFixture(thread *starlark.Thread, v starlark.Value) (interface{}, error) {
+	switch x := v.(type) {
+	case *starlarkstruct.Struct:
+		fields := make(map[string]interface{})
+		for _, name := range x.AttrNames() {
+			attr, err := x.Attr(name)
+			if err != nil {
+				return nil, err
+			}
+			if b, ok := attr.(*starlark.Builtin); ok {
+				ret := map[string]interface{}{}
+				res, err := starlark.Call(thread, b, nil, nil)
+				if err != nil {
+					ret["error"] = err.Error()
+				} else if ret["result"], err = encodeFixture(thread, res); err != nil {
+					return nil, err
+				}
+				fields[name] = map[string]interface{}{fixtureBuiltin: ret}
+				continue
+			}
+			if fields[name], err = encodeFixture(thread, attr); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{fixtureStruct: fields}, nil
+	case *starlark.Dict:
+		dict := make(map[interface{}]interface{}, x.Len())
+		for _, item := range x.Items() {
+			key, err := util.Unmarshal(item[0])
+			if err != nil {
+				return nil, err
+			}
+			if dict[key], err = encodeFixture(thread, item[1]); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case starlark.Float:
+		if f := float64(x); f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return map[string]interface{}{fixtureFloat: f}, nil
+		}
+	case *starlark.List:
+		return encodeFixtureList(thread, x)
+	case starlark.Tuple:
+		return encodeFixtureList(thread, x)
+	}
+	return util.Unmarshal(v)
+}
+
+func encodeFixtureList(thread *starlark.Thread, x starlark.Indexable) (interface{}, error) {
+	list := make([]interface{}, x.Len())
+	for i := range list {
+		var err error
+		if list[i], err = encodeFixture(thread, x.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// decodeFixture 将保存的值还原为starlark值
+func decodeFixture(v interface{}) (starlark.Value, error) {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		if fields, ok := x[fixtureStruct]; ok && len(x) == 1 {
+			return decodeStruct(fields)
+		}
+		if f, ok := x[fixtureFloat]; ok && len(x) == 1 {
+			return decodeFloat(f)
+		}
+		// yaml中的map没有顺序, 按key排序使回放结果稳定
+		keys := make([]interface{}, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		dict := starlark.NewDict(len(x))
+		for _, k := range keys {
+			key, err := util.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeFixture(x[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(key, val); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case []interface{}:
+		list := make([]starlark.Value, len(x))
+		for i, item := range x {
+			var err error
+			if list[i], err = decodeFixture(item); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(list), nil
+	}
+	return util.Marshal(v)
+}
+
+func decodeStruct(v interface{}) (starlark.Value, error) {
+	fields, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid fixture %s: %v", fixtureStruct, v)
+	}
+	sd := make(starlark.StringDict, len(fields))
+	for k, item := range fields {
+		name, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid fixture %s field: %v", fixtureStruct, k)
+		}
+		var val starlark.Value
+		var err error
+		if ret, ok := builtinFixture(item); ok {
+			val, err = decodeBuiltin(name, ret)
+		} else {
+			val, err = decodeFixture(item)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sd[name] = val
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, sd), nil
+}
+
+func decodeFloat(v interface{}) (starlark.Value, error) {
+	switch f := v.(type) {
+	case int:
+		return starlark.Float(f), nil
+	case float64:
+		return starlark.Float(f), nil
+	}
+	return nil, fmt.Errorf("invalid fixture %s: %v", fixtureFloat, v)
+}
+
+// builtinFixture 是否为struct中录制的方法
+func builtinFixture(v interface{}) (interface{}, bool) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	ret, ok := m[fixtureBuiltin]
+	return ret, ok
+}
+
+// decodeBuiltin 还原struct中的无参方法, 每次调用返回录制时结果的新副本或者错误
+func decodeBuiltin(name string, v interface{}) (starlark.Value, error) {
+	ret, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid fixture %s: %v", fixtureBuiltin, v)
+	}
+	msg, failed := ret["error"].(string)
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		if failed {
+			return starlark.None, errors.New(msg)
+		}
+		return decodeFixture(ret["result"])
+	}), nil
+}
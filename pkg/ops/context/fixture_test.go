@@ -0,0 +1,94 @@
+package context
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestRecordReplay(t *testing.T) {
+	runs := 0
+	exec := AddSideEffectBuiltin("shell.exec", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		runs++
+		cmd, _ := starlark.AsString(args[0])
+		if cmd == "false" {
+			return starlark.None, errors.New("exit status 1")
+		}
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"code":   starlark.MakeInt(0),
+			"stdout": starlark.String(cmd + " token=s3cr3t"),
+			"ratio":  starlark.Float(2),
+			"lines":  starlark.NewList([]starlark.Value{starlark.String("a"), starlark.String("b")}),
+			"text":   starlark.NewBuiltin("text", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) { return starlark.String("body"), nil }),
+		}), nil
+	}, nil)
+	other := AddBuiltin("tools.diff", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		runs++
+		return starlark.String("diff"), nil
+	})
+	globals := starlark.StringDict{"exec": exec, "diff": other}
+	script := `
+ret = exec("uptime")
+out = [ret.code, ret.stdout, ret.ratio, ret.lines, ret.text(), diff()]
+`
+	run := func(fixtures *Fixtures) (starlark.StringDict, error) {
+		thread := &starlark.Thread{Name: "fixture-job", Print: func(*starlark.Thread, string) {}}
+		fixtures.Bind(thread)
+		return starlark.ExecFile(thread, "fixture.star", script+"exec(\"false\")\n", globals)
+	}
+	NewSecretsManager().AddJobSecret("fixture-job", "s3cr3t")
+	defer NewSecretsManager().Release("fixture-job")
+
+	recording := NewFixtures("fixture.star")
+	recorded, err := run(recording)
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("expected exit status error, got %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "fixtures.yaml")
+	if err := recording.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Calls) != 2 || runs != 3 {
+		t.Fatalf("expected 2 recorded calls and 3 runs, got %d and %d", len(recording.Calls), runs)
+	}
+
+	replaying, err := LoadFixtures(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := run(replaying)
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Fatalf("expected the recorded error, got %v", err)
+	}
+	if runs != 4 {
+		t.Errorf("only tools.diff should run in replay, got %d runs", runs)
+	}
+	expect := `[0, "uptime token=******", 2.0, ["a", "b"], "body", "diff"]`
+	if got := recorded["out"].String(); strings.Replace(got, "s3cr3t", "******", 1) != expect {
+		t.Errorf("unexpected recorded output %s", got)
+	}
+	if got := replayed["out"].String(); got != expect {
+		t.Errorf("expected replayed %s, got %s", expect, got)
+	}
+	if unreplayed := replaying.Unreplayed(); len(unreplayed) != 0 {
+		t.Errorf("expected all calls replayed, got %v", unreplayed)
+	}
+
+	replaying, err = LoadFixtures(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &starlark.Thread{Name: "fixture-job", Print: func(*starlark.Thread, string) {}}
+	replaying.Bind(thread)
+	_, err = starlark.ExecFile(thread, "fixture.star", `exec("uptime -s")`, globals)
+	if err == nil || !strings.Contains(err.Error(), "was not recorded") {
+		t.Errorf("expected not recorded error, got %v", err)
+	}
+	if unreplayed := replaying.Unreplayed(); len(unreplayed) != 2 {
+		t.Errorf("expected 2 unreplayed calls, got %d", len(unreplayed))
+	}
+}
@@ -3,10 +3,12 @@ package context
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/superops-team/hyperops/pkg/environment"
 	"github.com/superops-team/hyperops/pkg/metrics"
+	"github.com/superops-team/hyperops/pkg/ops/event"
 	"go.starlark.net/starlark"
 )
 
@@ -40,7 +42,7 @@ func preRun(thread *starlark.Thread) error {
 	if task == nil {
 		return nil
 	}
-	if task.status == PreHangingStatus {
+	if status, _ := tm.Status(thread.Name); status == PreHangingStatus {
 		task.TrigerEvent(HangingStatus)
 		_ = tm.StartHanging(thread.Name)
 		select {
@@ -64,40 +66,124 @@ func preRun(thread *starlark.Thread) error {
 func postRun(thread *starlark.Thread) {
 }
 
+// hookPrint 输出hook日志, thread未设置Print时输出到标准错误
+func hookPrint(thread *starlark.Thread, msg string) {
+	if thread.Print != nil {
+		thread.Print(thread, msg)
+		return
+	}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// emitOplog 发布内置函数调用记录到任务的事件流, 参数和异常信息均已脱敏,
+// group/fleet的子线程名称与task不同, 通过继承的thread local找到所属的task
+func emitOplog(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple, start time.Time, end time.Time, status string, err error) {
+	task := TaskFromThread(thread)
+	if task == nil {
+		return
+	}
+	oplog := event.OplogEvent{
+		Name:      name,
+		Status:    status,
+		StartTime: start.UnixNano(),
+		EndTime:   end.UnixNano(),
+		TimeUsed:  end.Sub(start).Milliseconds(),
+		Details: []string{
+			Redact(thread, fmt.Sprintf("args=%s", args)),
+			Redact(thread, fmt.Sprintf("kwargs=%s", kwargs)),
+		},
+	}
+	if err != nil {
+		oplog.Exception = Redact(thread, err.Error())
+	}
+	task.TrigerOplogEvent(oplog)
+}
+
 // AddBuiltin hook starlark.NewBuiltin for add pre and post func when exec self func
 func AddBuiltin(name string, f Function) *starlark.Builtin {
+	return addBuiltin(name, f, nil, false)
+}
+
+func addBuiltin(name string, f Function, stub DryRunStub, sideEffect bool) *starlark.Builtin {
 	wrapped := starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 		start := time.Now()
-		var err error
+		var (
+			err      error
+			dry      bool
+			mocked   bool
+			replayed bool
+		)
 		defer func() {
-			sm := NewSecretsManager()
+			end := time.Now()
 			var msg string
 			if err != nil {
-				msg = fmt.Sprintf("fn=%s, args=%s, kwargs=%s, dur=%s, err=%s", name, args, kwargs, time.Since(start), err.Error())
+				msg = fmt.Sprintf("fn=%s, args=%s, kwargs=%s, dur=%s, err=%s", name, args, kwargs, end.Sub(start), err.Error())
 			} else {
-				msg = fmt.Sprintf("fn=%s, args=%s, kwargs=%s, dur=%s", name, args, kwargs, time.Since(start))
+				msg = fmt.Sprintf("fn=%s, args=%s, kwargs=%s, dur=%s", name, args, kwargs, end.Sub(start))
 			}
-			safeMsg := sm.SafeReplace(msg)
+			safeMsg := Redact(thread, msg)
 			env := environment.NewEnvStorage()
 			if env.IsTrue(HYPEROPS_FUNC_HOOK) {
-				thread.Print(thread, safeMsg)
+				hookPrint(thread, safeMsg)
 			}
 			status := "success"
+			if dry {
+				status = DryRunStatus
+			}
+			if mocked {
+				status = MockStatus
+			}
+			if replayed {
+				status = ReplayStatus
+			}
 			if err != nil {
+				if d := DebuggerFromThread(thread); d != nil {
+					d.BuiltinError(thread, name, err)
+				}
 				if !env.IsTrue(HYPEROPS_FUNC_HOOK) {
-					thread.Print(thread, safeMsg)
+					hookPrint(thread, safeMsg)
 				}
 				status = "failed"
 			}
+			emitOplog(thread, name, args, kwargs, start, end, status, err)
 			metrics.HyperFnCounter.WithLabelValues(name, status).Inc()
-			metrics.HyperFnDurHis.WithLabelValues(name, status).Observe(float64(time.Since(start).Milliseconds()))
+			metrics.HyperFnDurHis.WithLabelValues(name, status).Observe(float64(end.Sub(start).Milliseconds()))
 		}()
 		err = preRun(thread)
 		if err != nil {
 			return starlark.None, err
 		}
+		if d := DebuggerFromThread(thread); d != nil {
+			d.BeforeBuiltin(thread)
+		}
+		// 测试中mock的调用不会执行, 也不受dry-run影响
+		if hit, res, mockErr := mockCall(thread, name, fn, args, kwargs); hit {
+			mocked = true
+			err = mockErr
+			return res, err
+		}
+		// --replay返回录制的结果, 未录制的调用返回错误
+		fixtures := FixturesFromThread(thread)
+		if fixtures != nil && !fixtureFunc(name) {
+			fixtures = nil
+		}
+		if fixtures != nil && fixtures.Replaying() {
+			replayed = true
+			var res starlark.Value
+			res, err = fixtures.replayCall(thread, name, args, kwargs)
+			return res, err
+		}
+		if sideEffect && IsDryRun(thread) {
+			dry = true
+			var res starlark.Value
+			res, err = dryRun(thread, name, fn, args, kwargs, stub)
+			return res, err
+		}
 		res, err := f(thread, fn, args, kwargs)
 		postRun(thread)
+		if fixtures != nil {
+			fixtures.record(thread, name, args, kwargs, res, err)
+		}
 		return res, err
 	})
 	return wrapped
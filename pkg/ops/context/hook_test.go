@@ -0,0 +1,64 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/superops-team/hyperops/pkg/ops/event"
+	"go.starlark.net/starlark"
+)
+
+func TestAddBuiltinOplog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	NewSecretsManager().AddSecret("oplog_secret_pass")
+
+	bus := event.NewBus(ctx)
+	oplogs := []event.OplogEvent{}
+	bus.SubscribeTypes(func(_ context.Context, e event.Event) error {
+		oplogs = append(oplogs, e.Payload.(event.OplogEvent))
+		return nil
+	}, event.ETOplog)
+
+	echo := AddBuiltin("test.echo", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) > 1 {
+			return starlark.None, fmt.Errorf("login with %s failed", args[1])
+		}
+		return args[0], nil
+	})
+
+	taskid := "oplog_task"
+	thread := &starlark.Thread{Name: taskid}
+	tm := NewTaskManager()
+	tm.AddWithPublisher(taskid, thread, bus)
+	defer tm.Delete(taskid, nil)
+
+	if _, err := starlark.Call(thread, echo, starlark.Tuple{starlark.String("hello")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := starlark.Call(thread, echo, starlark.Tuple{starlark.String("user"), starlark.String("oplog_secret_pass")}, nil); err == nil {
+		t.Fatal("expect error")
+	}
+
+	if len(oplogs) != 2 {
+		t.Fatalf("expect 2 oplog events, got %d", len(oplogs))
+	}
+	if oplogs[0].ID != taskid || oplogs[0].Name != "test.echo" || oplogs[0].Status != "success" || oplogs[0].Exception != "" {
+		t.Errorf("unexpected success oplog: %+v", oplogs[0])
+	}
+	if oplogs[0].EndTime < oplogs[0].StartTime {
+		t.Errorf("unexpected oplog time range: %+v", oplogs[0])
+	}
+	failed := oplogs[1]
+	if failed.Status != "failed" || !strings.Contains(failed.Exception, "login with") {
+		t.Errorf("unexpected failed oplog: %+v", failed)
+	}
+	for _, s := range append(failed.Details, failed.Exception) {
+		if strings.Contains(s, "oplog_secret_pass") {
+			t.Errorf("secret leaked in oplog: %s", s)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	hmetrics "github.com/superops-team/hyperops/pkg/metrics"
+	"go.starlark.net/starlark"
+)
+
+const (
+	// LIMITS_NAME thread local中保存资源限制的key
+	LIMITS_NAME = "HYPEROPS_LIMITS"
+
+	// LimitSteps starlark执行步数
+	LimitSteps = "steps"
+	// LimitOutput print输出的字节数
+	LimitOutput = "output"
+	// LimitShellStdout 单次shell调用保存的标准输出字节数
+	LimitShellStdout = "shell_stdout"
+	// LimitHeap 进程堆内存, 无法区分进程中各个job的内存, 只适用于进程只执行一个job的场景
+	LimitHeap = "heap"
+
+	heapMetric        = "/memory/classes/heap/objects:bytes"
+	heapCheckInterval = 200 * time.Millisecond
+)
+
+// LimitError 超出资源限制, 通过errors.As获取触发的限制
+type LimitError struct {
+	Limit string
+	Max   uint64
+	Err   error // 带调用栈的执行错误
+}
+
+func (e *LimitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Reason()
+}
+
+// Reason 取消线程时使用的原因
+func (e *LimitError) Reason() string {
+	unit := " bytes"
+	if e.Limit == LimitSteps {
+		unit = ""
+	}
+	return fmt.Sprintf("%s limit exceeded (max %d%s)", e.Limit, e.Max, unit)
+}
+
+// LimitName 触发的限制名称
+func (e *LimitError) LimitName() string {
+	return e.Limit
+}
+
+// ExceededLimit 返回err中触发的资源限制名称, 不是资源限制错误时返回空
+func ExceededLimit(err error) string {
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le.Limit
+	}
+	return ""
+}
+
+// Limits 单个job的资源限制, 0表示不限制
+type Limits struct {
+	MaxSteps       uint64 // 每个线程(入口脚本, group/fleet的子线程, 加载的模块)的最大执行步数
+	MaxOutputBytes uint64 // print输出的总字节数
+	MaxShellStdout uint64 // 单次shell/ssh调用保存的标准输出字节数, 超出部分截断
+	MaxHeapBytes   uint64 // 进程堆内存的软上限, 只用于apply等独占进程的job
+
+	name    string
+	output  uint64
+	mu      sync.Mutex
+	threads map[*starlark.Thread]struct{} // 未结束的线程
+	fired   *LimitError
+}
+
+// Enabled 是否设置了任意一项限制
+func (l *Limits) Enabled() bool {
+	return l.MaxSteps > 0 || l.MaxOutputBytes > 0 || l.MaxShellStdout > 0 || l.MaxHeapBytes > 0
+}
+
+// Bind 将资源限制绑定到job的主线程, name用于metrics
+func (l *Limits) Bind(thread *starlark.Thread, name string) {
+	l.name = name
+	thread.SetLocal(LIMITS_NAME, l)
+	l.apply(thread)
+}
+
+// LimitsFromThread 获取线程绑定的资源限制, 未绑定时返回nil
+func LimitsFromThread(thread *starlark.Thread) *Limits {
+	l, _ := thread.Local(LIMITS_NAME).(*Limits)
+	return l
+}
+
+// apply 设置线程的最大执行步数, 并记录线程用于超出限制时一起取消
+func (l *Limits) apply(thread *starlark.Thread) {
+	l.mu.Lock()
+	if l.threads == nil {
+		l.threads = make(map[*starlark.Thread]struct{})
+	}
+	l.threads[thread] = struct{}{}
+	fired := l.fired
+	l.mu.Unlock()
+	if fired != nil {
+		thread.Cancel(fired.Reason())
+	}
+	if l.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(l.MaxSteps)
+		thread.OnMaxSteps = func(thread *starlark.Thread) {
+			l.Exceed(LimitSteps, l.MaxSteps)
+			thread.Cancel((&LimitError{Limit: LimitSteps, Max: l.MaxSteps}).Reason())
+		}
+	}
+}
+
+// release 子线程执行结束后不再记录
+func (l *Limits) release(thread *starlark.Thread) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.threads, thread)
+}
+
+// Exceed 记录第一个触发的限制并取消job的所有线程
+func (l *Limits) Exceed(limit string, max uint64) {
+	l.mu.Lock()
+	if l.fired != nil {
+		l.mu.Unlock()
+		return
+	}
+	l.fired = &LimitError{Limit: limit, Max: max}
+	threads := make([]*starlark.Thread, 0, len(l.threads))
+	for thread := range l.threads {
+		threads = append(threads, thread)
+	}
+	l.mu.Unlock()
+	hmetrics.LimitExceededCount.WithLabelValues(l.name, limit).Inc()
+	for _, thread := range threads {
+		thread.Cancel(l.fired.Reason())
+	}
+}
+
+// Fired 返回触发的限制, err为执行错误
+func (l *Limits) Fired(err error) error {
+	if l == nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fired == nil {
+		return err
+	}
+	return &LimitError{Limit: l.fired.Limit, Max: l.fired.Max, Err: err}
+}
+
+// AddOutput 累计输出的字节数, 超出限制时返回false, 该条输出应当丢弃
+func (l *Limits) AddOutput(n int) bool {
+	if l == nil || l.MaxOutputBytes == 0 {
+		return true
+	}
+	if atomic.AddUint64(&l.output, uint64(n)) > l.MaxOutputBytes {
+		l.Exceed(LimitOutput, l.MaxOutputBytes)
+		return false
+	}
+	return true
+}
+
+// ShellStdout 单次shell调用最多保存的标准输出字节数, 0表示不限制
+func (l *Limits) ShellStdout() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.MaxShellStdout)
+}
+
+// Truncated 记录shell输出被截断, 截断不会结束job
+func (l *Limits) Truncated() {
+	if l == nil {
+		return
+	}
+	hmetrics.LimitExceededCount.WithLabelValues(l.name, LimitShellStdout).Inc()
+}
+
+// WatchHeap 定期检查进程的堆内存, 超出MaxHeapBytes时取消job, ctx结束时停止检查
+// 堆内存包括进程中所有job的分配, 因此server和schedule不支持该限制
+func (l *Limits) WatchHeap(ctx context.Context) {
+	if l == nil || l.MaxHeapBytes == 0 {
+		return
+	}
+	go func() {
+		samples := []metrics.Sample{{Name: heapMetric}}
+		ticker := time.NewTicker(heapCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				metrics.Read(samples)
+				if samples[0].Value.Kind() == metrics.KindUint64 && samples[0].Value.Uint64() > l.MaxHeapBytes {
+					l.Exceed(LimitHeap, l.MaxHeapBytes)
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,40 @@
+package context
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestLimitsThreads(t *testing.T) {
+	l := &Limits{MaxSteps: 1000}
+	main := &starlark.Thread{Name: "limits-job"}
+	l.Bind(main, "limits-job")
+	for i := 0; i < 100; i++ {
+		child := &starlark.Thread{Name: main.Name}
+		InheritLocals(main, child)
+		if _, err := starlark.ExecFile(child, "child.star", "x = 1", nil); err != nil {
+			t.Fatal(err)
+		}
+		ReleaseLocals(child)
+	}
+	running := &starlark.Thread{Name: main.Name}
+	InheritLocals(main, running)
+	// 结束的子线程不再保留
+	if n := len(l.threads); n != 2 {
+		t.Errorf("expected the main and the running thread, got %d", n)
+	}
+
+	l.Exceed(LimitOutput, 10)
+	for _, thread := range []*starlark.Thread{main, running} {
+		if _, err := starlark.ExecFile(thread, "cancelled.star", "x = 1", nil); err == nil {
+			t.Errorf("%s should be cancelled", thread.Name)
+		}
+	}
+	// 超出限制后创建的子线程直接取消
+	late := &starlark.Thread{Name: main.Name}
+	InheritLocals(main, late)
+	if _, err := starlark.ExecFile(late, "late.star", "x = 1", nil); err == nil {
+		t.Error("thread created after the limit fired should be cancelled")
+	}
+}
@@ -0,0 +1,61 @@
+package context
+
+import (
+	"context"
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// childDoneName thread local中保存子线程结束信号的key
+const childDoneName = "HYPEROPS_CHILD_DONE"
+
+// inheritedLocals 子线程(例如group.wait创建的线程)需要继承的thread local
+var inheritedLocals = []string{DRYRUN_NAME, DRYRUN_STUBS_NAME, CONTEXT_NAME, INVENTORY_NAME, SANDBOX_NAME, LIMITS_NAME, TEST_REPORTER_NAME, MOCKS_NAME, FIXTURES_NAME, DEBUGGER_NAME, TASK_NAME}
+
+// InheritLocals 将运行时相关的thread local传递给子线程
+func InheritLocals(parent, child *starlark.Thread) {
+	for _, key := range inheritedLocals {
+		if v := parent.Local(key); v != nil {
+			child.SetLocal(key, v)
+		}
+	}
+	// 子线程使用相同的资源限制, 超出时与主线程一起取消
+	if l := LimitsFromThread(child); l != nil {
+		l.apply(child)
+	}
+	// 调试器的hook在资源限制之后接入, 以便串联步数限制
+	if d := DebuggerFromThread(child); d != nil {
+		d.Attach(child)
+	}
+	// 子线程的context在task被kill或执行超时时取消, 此时一起取消子线程
+	if ctx, ok := child.Local("context").(context.Context); ok {
+		done := make(chan struct{})
+		child.SetLocal(childDoneName, done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				child.Cancel(fmt.Sprintf("cancel %s with the job: %s", child.Name, ctx.Err()))
+			case <-done:
+			}
+		}()
+	}
+}
+
+// JobContext 线程所属job的context, 在job被kill或执行超时时取消, 未绑定时返回context.Background()
+func JobContext(thread *starlark.Thread) context.Context {
+	if ctx, ok := thread.Local("context").(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// ReleaseLocals 子线程执行结束后调用, 与InheritLocals成对使用
+func ReleaseLocals(child *starlark.Thread) {
+	if l := LimitsFromThread(child); l != nil {
+		l.release(child)
+	}
+	if done, ok := child.Local(childDoneName).(chan struct{}); ok {
+		close(done)
+	}
+}
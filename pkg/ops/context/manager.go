@@ -1,10 +1,12 @@
 package context
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -29,6 +31,9 @@ var (
 	ErrTaskKill             = errors.New("error task was killed when hanging")
 )
 
+// TASK_NAME thread local中保存线程所属task的key, 子线程通过InheritLocals继承
+const TASK_NAME = "HYPEROPS_TASK"
+
 // 任务状态定义
 type TaskStatus string
 
@@ -47,36 +52,86 @@ type Task struct {
 	recovering bool
 	thread     *starlark.Thread
 	RecoveryCh chan string
-	eventsCh   chan event.Event
+	events     event.Publisher
 	hangTime   time.Time
+	cancel     context.CancelFunc // 取消job的context, 结束fleet/group等创建的子线程
+	ctx        context.Context    // 调用方的context, 取消后不再等待消费者读取事件
+}
+
+// eventPublishTimeout 等待消费者读取事件的最长时间, 消费者不读取事件时不会一直阻塞任务
+var eventPublishTimeout = 3 * time.Second
+
+// PublishEvent 发布print/状态/oplog等执行过程中的事件, 等待消费者读取直到ctx取消或者超过eventPublishTimeout,
+// 发布失败时记录日志和hyperops_event_publish_failed_count
+func PublishEvent(ctx context.Context, events event.Publisher, typ event.Type, id string, payload interface{}) error {
+	if events == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, eventPublishTimeout)
+	defer cancel()
+	return publishEvent(ctx, events, typ, id, payload)
+}
+
+func publishEvent(ctx context.Context, events event.Publisher, typ event.Type, id string, payload interface{}) error {
+	err := events.PublishID(ctx, typ, id, payload)
+	if err != nil {
+		metrics.EventPublishFailedCount.WithLabelValues(string(typ)).Inc()
+		fmt.Fprintf(os.Stderr, "publish %s event of %s failed: %s\n", typ, id, err)
+	}
+	return err
 }
 
 // TrigerEvent 变更状态后自动触发事件
 func (t *Task) TrigerEvent(curStatus TaskStatus) {
-	// 保证任务存在后执行避免死锁
-	preStatus := t.status
+	t.publishStatus(t.status, curStatus)
+}
+
+// publishStatus 发布状态变更事件, 不能在持有TaskManager锁时调用
+func (t *Task) publishStatus(preStatus, curStatus TaskStatus) {
 	if string(preStatus) == "" {
 		preStatus = PendingStatus
 	}
-	if t.eventsCh != nil {
-		t.eventsCh <- event.MakeEvent(event.ETTask, t.ID, event.TaskEvent{
-			ID:   t.ID,
-			From: string(preStatus),
-			To:   string(curStatus),
-		})
-	}
+	t.publish(event.ETTask, event.TaskEvent{
+		ID:   t.ID,
+		From: string(preStatus),
+		To:   string(curStatus),
+	})
 }
 
 // TrigerDataEvent 触发数据事件,收到事件后进行归档操作
 func (t *Task) TrigerDataEvent(data map[string]interface{}) {
-	if t.eventsCh != nil {
-		t.eventsCh <- event.MakeEvent(event.ETData, t.ID, event.DataEvent{
-			ID:   t.ID,
-			Data: data,
-		})
+	t.publish(event.ETData, event.DataEvent{
+		ID:   t.ID,
+		Data: data,
+	})
+}
+
+// TrigerOplogEvent 触发内置函数调用记录事件
+func (t *Task) TrigerOplogEvent(oplog event.OplogEvent) {
+	oplog.ID = t.ID
+	t.publish(event.ETOplog, oplog)
+}
+
+// publish 发布执行过程中的事件, 与print使用相同的等待策略
+func (t *Task) publish(typ event.Type, payload interface{}) {
+	_ = PublishEvent(t.context(), t.events, typ, t.ID, payload)
+}
+
+// publishFinal 发布结束时的状态和数据事件, 不受eventPublishTimeout限制, 一直等待消费者读取直到调用方取消
+func (t *Task) publishFinal(typ event.Type, payload interface{}) {
+	if t.events != nil {
+		_ = publishEvent(t.context(), t.events, typ, t.ID, payload)
 	}
 }
 
+// context 调用方的context, 未设置时返回context.Background()
+func (t *Task) context() context.Context {
+	if t.ctx != nil {
+		return t.ctx
+	}
+	return context.Background()
+}
+
 func (t *Task) GetStatus() TaskStatus {
 	return t.status
 }
@@ -109,10 +164,17 @@ func PathExists(path string) bool {
 	return false
 }
 
-// Add 添加task
+// Add 添加task, 状态变更事件写入eventsCh
 func (t *TaskManager) Add(taskid string, thread *starlark.Thread, eventsCh chan event.Event) {
-	t.Lock()
-	defer t.Unlock()
+	var events event.Publisher
+	if eventsCh != nil {
+		events = event.NewChanPublisher(eventsCh)
+	}
+	_ = t.AddWithPublisher(taskid, thread, events)
+}
+
+// AddWithPublisher 添加task, 状态变更事件通过events发布, 同名的task已存在时返回false
+func (t *TaskManager) AddWithPublisher(taskid string, thread *starlark.Thread, events event.Publisher) bool {
 	env := environment.NewEnvStorage()
 	pwdpath := env.Get("PWD")
 	workdir := "./"
@@ -122,35 +184,43 @@ func (t *TaskManager) Add(taskid string, thread *starlark.Thread, eventsCh chan
 	if !PathExists(workdir) {
 		_ = os.MkdirAll(workdir, 0755)
 	}
+	t.Lock()
 	_, ok := t.tasks[taskid]
 	if ok {
-		return
+		t.Unlock()
+		return false
 	}
 	task := &Task{
 		ID:         taskid,
 		thread:     thread,
 		recovering: false,
 		RecoveryCh: make(chan string, 1),
-		eventsCh:   eventsCh,
+		events:     events,
+		status:     RunningStatus,
 	}
-	task.TrigerEvent(RunningStatus)
-	task.status = RunningStatus
-
 	t.tasks[taskid] = task
+	t.Unlock()
+	thread.SetLocal(TASK_NAME, task)
+	// 在锁外发布事件, 避免消费者阻塞时其他task无法操作
+	task.publishStatus(PendingStatus, RunningStatus)
+	return true
 }
 
-// Delete 删除task
+// Delete 删除task并释放job的密码
 func (t *TaskManager) Delete(taskid string, dict starlark.StringDict) {
-	t.Lock()
-	defer t.Unlock()
 	var iskeep bool
+	t.Lock()
 	task, ok := t.tasks[taskid]
-	if !ok {
-		return
+	var preStatus TaskStatus
+	if ok && task != nil {
+		preStatus = task.status
 	}
-	if task != nil {
-		task.TrigerEvent(FinishedStatus)
+	t.Unlock()
+	if !ok || task == nil {
+		return
 	}
+	// 事件在锁外发布, 避免消费者阻塞时其他task无法操作, 最终的状态和数据事件不会因为消费者较慢而丢弃
+	task.publishFinal(event.ETTask, event.TaskEvent{ID: task.ID, From: string(preStatus), To: string(FinishedStatus)})
 	thread := task.thread
 	v, err := Call(thread, dict, "ctx.get_config", []interface{}{"HYPEROPS_WORKSPACE_KEEP"}, nil)
 	if err == nil {
@@ -160,22 +230,34 @@ func (t *TaskManager) Delete(taskid string, dict starlark.StringDict) {
 		env := environment.NewEnvStorage()
 		pwd := env.Get("PWD")
 		path := path.Join(pwd, thread.Name)
-		if PathExists(path) {
+		// 存在未完成的checkpoint时保留工作目录, 以便使用相同的job id恢复执行
+		if PathExists(path) && !PathExists(filepath.Join(path, CheckpointFile)) {
 			os.RemoveAll(path)
 		}
 	}
 	values, err := Call(thread, dict, "ctx.values", nil, nil)
 	if err == nil {
 		evData := values.(map[string]interface{})
-		// 当事件非空时才触发
+		// 当事件非空时才触发, 发布前替换其中的密码
 		if len(evData) > 0 {
-			task.TrigerDataEvent(evData)
+			evData = NewSecretsManager().SafeReplaceValue(SecretScope(thread), evData).(map[string]interface{})
+			task.publishFinal(event.ETData, event.DataEvent{ID: task.ID, Data: evData})
 		}
 	}
 
+	// 释放job的密码, 避免长期运行的服务中密码无限增长
+	NewSecretsManager().Release(SecretScope(thread))
+	t.Lock()
+	defer t.Unlock()
 	delete(t.tasks, taskid)
 }
 
+// TaskFromThread 获取线程所属的task, 线程没有注册task时返回nil
+func TaskFromThread(thread *starlark.Thread) *Task {
+	task, _ := thread.Local(TASK_NAME).(*Task)
+	return task
+}
+
 // Get 获取task
 func (t *TaskManager) Get(taskid string) *Task {
 	t.Lock()
@@ -187,6 +269,17 @@ func (t *TaskManager) Get(taskid string) *Task {
 	return task
 }
 
+// Status 获取task当前状态，task不存在时返回false
+func (t *TaskManager) Status(taskid string) (TaskStatus, bool) {
+	t.Lock()
+	defer t.Unlock()
+	task, ok := t.tasks[taskid]
+	if !ok {
+		return "", false
+	}
+	return task.status, true
+}
+
 // Suspend 暂停指定task
 func (t *TaskManager) Suspend(taskid string) error {
 	t.Lock()
@@ -243,6 +336,31 @@ func (t *TaskManager) RecoveryOver(taskid string) error {
 	return nil
 }
 
+// Hang 将运行中的task直接置为hanging, 例如调试器在断点处暂停, 调用方恢复执行后调用RecoveryOver
+func (t *TaskManager) Hang(taskid string) error {
+	t.Lock()
+	task, ok := t.tasks[taskid]
+	if !ok {
+		t.Unlock()
+		return ErrSuspendFailed
+	}
+	if task.status == PreHangingStatus {
+		t.Unlock()
+		return ErrSuspendIsPreHanging
+	}
+	if task.status == HangingStatus {
+		t.Unlock()
+		return ErrSuspendIsHanging
+	}
+	preStatus := task.status
+	task.hangTime = time.Now()
+	metrics.HangGouge.WithLabelValues("hanging").Inc()
+	task.status = HangingStatus
+	t.Unlock()
+	task.publishStatus(preStatus, HangingStatus)
+	return nil
+}
+
 func (t *TaskManager) StartHanging(taskid string) error {
 	t.Lock()
 	defer t.Unlock()
@@ -255,6 +373,24 @@ func (t *TaskManager) StartHanging(taskid string) error {
 	return nil
 }
 
+// SetContext 设置调用方的context, 调用方取消后发布事件不再等待消费者读取
+func (t *TaskManager) SetContext(taskid string, ctx context.Context) {
+	t.Lock()
+	defer t.Unlock()
+	if task, ok := t.tasks[taskid]; ok {
+		task.ctx = ctx
+	}
+}
+
+// SetCancel 设置kill task时取消的job context
+func (t *TaskManager) SetCancel(taskid string, cancel context.CancelFunc) {
+	t.Lock()
+	defer t.Unlock()
+	if task, ok := t.tasks[taskid]; ok {
+		task.cancel = cancel
+	}
+}
+
 // Kill kill a task
 func (t *TaskManager) Kill(taskid string) {
 	_ = t.Recovery(taskid)
@@ -267,6 +403,9 @@ func (t *TaskManager) Kill(taskid string) {
 	if task.thread != nil {
 		task.thread.Cancel(fmt.Sprintf("cancel %s by task manager", task.ID))
 	}
+	if task.cancel != nil {
+		task.cancel()
+	}
 }
 
 // GetAll 获取所有执行中的tasks快照
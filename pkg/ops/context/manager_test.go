@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/superops-team/hyperops/pkg/metrics"
 	"github.com/superops-team/hyperops/pkg/ops/event"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/testdata"
 	"go.starlark.net/starlark"
@@ -272,3 +274,66 @@ func TestTaskManagerKill(t *testing.T) {
 		}
 	}
 }
+
+func TestTaskManagerUnreadEvents(t *testing.T) {
+	timeout := eventPublishTimeout
+	eventPublishTimeout = 200 * time.Millisecond
+	defer func() { eventPublishTimeout = timeout }()
+
+	taskid := "unread_events"
+	thread := &starlark.Thread{Name: taskid}
+	ch := make(chan event.Event)
+	failed := testutil.ToFloat64(metrics.EventPublishFailedCount.WithLabelValues(string(event.ETTask)))
+	tm := NewTaskManager()
+	// 消费者不读取事件时, 执行过程中的事件最多等待eventPublishTimeout, 等待时不能阻塞其他task的操作,
+	// 结束时的状态事件一直等待消费者读取
+	for _, c := range []struct {
+		name  string
+		op    func()
+		final bool
+	}{
+		{"add", func() { tm.Add(taskid, thread, ch) }, false},
+		{"hang", func() { _ = tm.Hang(taskid) }, false},
+		{"delete", func() { tm.Delete(taskid, nil) }, true},
+	} {
+		done := make(chan struct{})
+		go func() {
+			c.op()
+			close(done)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		locked := make(chan struct{})
+		go func() {
+			tm.Get("other")
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(100 * time.Millisecond):
+			t.Errorf("%s: task manager is locked while publishing events", c.name)
+		}
+		if c.final {
+			select {
+			case <-done:
+				t.Fatalf("%s: the final event should not be dropped", c.name)
+			case <-time.After(3 * eventPublishTimeout):
+			}
+			e := <-ch
+			if payload, ok := e.Payload.(event.TaskEvent); !ok || payload.To != string(FinishedStatus) {
+				t.Errorf("expected finished status event, got %+v", e)
+			}
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: blocked by the unread events channel", c.name)
+		}
+	}
+	if tm.Get(taskid) != nil {
+		t.Error("task should be deleted")
+	}
+	// add和hang的状态事件超时后计入发布失败
+	if got := testutil.ToFloat64(metrics.EventPublishFailedCount.WithLabelValues(string(event.ETTask))) - failed; got != 2 {
+		t.Errorf("expected 2 failed events counted, got %v", got)
+	}
+}
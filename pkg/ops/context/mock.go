@@ -0,0 +1,163 @@
+package context
+
+import (
+	"path"
+	"regexp"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// MOCKS_NAME thread local中保存测试mock的key
+const MOCKS_NAME = "HYPEROPS_MOCKS"
+
+// MockStatus 被mock拦截的调用的状态
+const MockStatus = "mocked"
+
+// subjectKwargs 内置函数匹配mock时使用的参数名, 依次为shell命令, url和文件路径
+var subjectKwargs = []string{"cmd", "url", "file", "filepath", "dir"}
+
+// MockResult 生成被mock的调用的返回值
+type MockResult func(thread *starlark.Thread, fn *starlark.Builtin, subject string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+// MockCall 一次内置函数调用的记录
+type MockCall struct {
+	Name    string
+	Subject string
+	Args    starlark.Tuple
+	Kwargs  []starlark.Tuple
+}
+
+// Mock 一条mock规则, 函数名匹配funcs中任意一个glob并且调用对象匹配pattern时返回result
+type Mock struct {
+	funcs   []string
+	pattern *regexp.Regexp
+	result  MockResult
+
+	mu    sync.Mutex
+	calls []*MockCall
+}
+
+// NewMock 创建mock规则, pattern为nil时匹配所有调用
+func NewMock(funcs []string, pattern *regexp.Regexp, result MockResult) *Mock {
+	return &Mock{funcs: funcs, pattern: pattern, result: result}
+}
+
+// Calls 返回命中该规则的调用
+func (m *Mock) Calls() []*MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*MockCall{}, m.calls...)
+}
+
+func (m *Mock) match(name, subject string) bool {
+	if m.pattern != nil && !m.pattern.MatchString(subject) {
+		return false
+	}
+	for _, f := range m.funcs {
+		if ok, _ := path.Match(f, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Mocks 测试中注册的mock规则和所有内置函数的调用记录, 后注册的规则优先
+type Mocks struct {
+	sync.Mutex
+	mocks []*Mock
+	calls []*MockCall
+}
+
+// NewMocks 创建空的mock集合
+func NewMocks() *Mocks {
+	return &Mocks{}
+}
+
+// Bind 绑定到线程, 之后通过AddBuiltin注册的内置函数调用都会先经过mock
+func (m *Mocks) Bind(thread *starlark.Thread) {
+	thread.SetLocal(MOCKS_NAME, m)
+}
+
+// MocksFromThread 获取线程绑定的mock集合, 未绑定时返回nil
+func MocksFromThread(thread *starlark.Thread) *Mocks {
+	m, _ := thread.Local(MOCKS_NAME).(*Mocks)
+	return m
+}
+
+// Add 注册mock规则
+func (m *Mocks) Add(mock *Mock) {
+	m.Lock()
+	defer m.Unlock()
+	m.mocks = append(m.mocks, mock)
+}
+
+// Calls 返回函数名匹配glob的所有调用记录, 包括未被mock的调用
+func (m *Mocks) Calls(name string) []*MockCall {
+	m.Lock()
+	defer m.Unlock()
+	var calls []*MockCall
+	for _, call := range m.calls {
+		if ok, _ := path.Match(name, call.Name); ok {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// intercept 记录调用, 命中mock规则时返回规则的结果
+func (m *Mocks) intercept(name string, args starlark.Tuple, kwargs []starlark.Tuple) (*Mock, *MockCall) {
+	call := &MockCall{Name: name, Subject: callSubject(args, kwargs), Args: args, Kwargs: kwargs}
+	m.Lock()
+	m.calls = append(m.calls, call)
+	var hit *Mock
+	for i := len(m.mocks) - 1; i >= 0; i-- {
+		if m.mocks[i].match(name, call.Subject) {
+			hit = m.mocks[i]
+			break
+		}
+	}
+	m.Unlock()
+	if hit != nil {
+		hit.mu.Lock()
+		hit.calls = append(hit.calls, call)
+		hit.mu.Unlock()
+	}
+	return hit, call
+}
+
+// callSubject 调用对象, 为第一个字符串位置参数或者subjectKwargs中的参数
+func callSubject(args starlark.Tuple, kwargs []starlark.Tuple) string {
+	if len(args) > 0 {
+		if s, ok := starlark.AsString(args[0]); ok {
+			return s
+		}
+	}
+	for _, name := range subjectKwargs {
+		for _, kwarg := range kwargs {
+			if key, _ := starlark.AsString(kwarg[0]); key == name {
+				if s, ok := starlark.AsString(kwarg[1]); ok {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// mockCall 线程绑定了mock时记录调用, 命中规则时返回true和规则的结果
+func mockCall(thread *starlark.Thread, name string, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (bool, starlark.Value, error) {
+	mocks := MocksFromThread(thread)
+	if mocks == nil {
+		return false, nil, nil
+	}
+	hit, call := mocks.intercept(name, args, kwargs)
+	if hit == nil {
+		return false, nil, nil
+	}
+	if hit.result == nil {
+		return true, starlark.None, nil
+	}
+	res, err := hit.result(thread, fn, call.Subject, args, kwargs)
+	return true, res, err
+}
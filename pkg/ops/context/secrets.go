@@ -1,7 +1,13 @@
 package context
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
 
@@ -13,53 +19,269 @@ var (
 	instance *secretsManager
 )
 
+// secretSet 一组密码, 替换器在密码变更后重新构建
+type secretSet struct {
+	secrets  map[string]struct{} // Set类型
+	replacer *strings.Replacer
+	version  uint64 // 构建替换器时全局密码的版本
+}
+
+func newSecretSet() *secretSet {
+	return &secretSet{secrets: make(map[string]struct{})}
+}
+
+// add 添加密码, 已存在时返回false
+func (s *secretSet) add(secret string) bool {
+	if _, ok := s.secrets[secret]; ok || secret == "" {
+		return false
+	}
+	s.secrets[secret] = struct{}{}
+	s.replacer = nil
+	return true
+}
+
 // secretsManager 密码管理器,管理所有安全相关的秘钥信息
+// 全局密码对所有job生效, job密码只用于该job的输出脱敏, job结束时由TaskManager.Delete释放
 type secretsManager struct {
 	sync.Mutex
-	allSecrets map[string]struct{} // Set类型
+	global  *secretSet
+	version uint64 // 全局密码变更次数
+	jobs    map[string]*secretSet
 }
 
 // NewSecretsManager 返回密码管理器对象,该对象记录了所有的密码信息
 func NewSecretsManager() *secretsManager {
 	once.Do(func() {
 		instance = &secretsManager{
-			allSecrets: make(map[string]struct{}),
+			global: newSecretSet(),
+			jobs:   make(map[string]*secretSet),
 		}
 	})
 	return instance
 }
 
-// AddSecret 添加密码
+// AddSecret 添加全局密码, 对所有job的输出生效
 func (s *secretsManager) AddSecret(secret string) {
 	s.Lock()
 	defer s.Unlock()
-	s.allSecrets[secret] = struct{}{}
+	if s.global.add(secret) {
+		s.version++
+	}
+}
+
+// AddJobSecret 添加只对指定job生效的密码, job为空时添加为全局密码
+func (s *secretsManager) AddJobSecret(job, secret string) {
+	if job == "" {
+		s.AddSecret(secret)
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	set, ok := s.jobs[job]
+	if !ok {
+		set = newSecretSet()
+		s.jobs[job] = set
+	}
+	set.add(secret)
+}
+
+// Release 释放job的密码
+func (s *secretsManager) Release(job string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.jobs, job)
 }
 
-// HasSecret 是否包含指定密码
+// HasSecret 是否包含指定的全局密码
 func (s *secretsManager) HasSecret(secret string) bool {
 	s.Lock()
 	defer s.Unlock()
-	_, ok := s.allSecrets[secret]
+	_, ok := s.global.secrets[secret]
 	return ok
 }
 
-// SafeReplace 安全替换
-func (s *secretsManager) SafeReplace(msg string) string {
+// HasJobSecret job的输出中是否会替换指定密码, 包括全局密码
+func (s *secretsManager) HasJobSecret(job, secret string) bool {
 	s.Lock()
 	defer s.Unlock()
-	newMsg := msg
-	// thread safe range
-	for secret := range s.allSecrets {
-		dst := strings.Repeat("*", len(secret))
-		newMsg = strings.Replace(newMsg, secret, dst, -1)
+	if _, ok := s.global.secrets[secret]; ok {
+		return true
+	}
+	set, ok := s.jobs[job]
+	if !ok {
+		return false
+	}
+	_, ok = set.secrets[secret]
+	return ok
+}
+
+// SafeReplace 使用全局密码安全替换
+func (s *secretsManager) SafeReplace(msg string) string {
+	return s.SafeReplaceJob("", msg)
+}
+
+// SafeReplaceJob 使用全局密码和job的密码安全替换, 单次扫描完成所有密码的匹配, 耗时不随密码数量线性增长
+func (s *secretsManager) SafeReplaceJob(job, msg string) string {
+	s.Lock()
+	set, ok := s.jobs[job]
+	if !ok {
+		set = s.global
+	}
+	if set.replacer == nil || (set != s.global && set.version != s.version) {
+		set.replacer = buildReplacer(s.global.secrets, set.secrets)
+		set.version = s.version
+	}
+	replacer := set.replacer
+	s.Unlock()
+	return replacer.Replace(msg)
+}
+
+// SafeReplaceValue 替换事件数据等结构化值中的密码, 返回新的值, 不修改v
+func (s *secretsManager) SafeReplaceValue(job string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return s.SafeReplaceJob(job, val)
+	case []string:
+		res := make([]string, len(val))
+		for i, item := range val {
+			res[i] = s.SafeReplaceJob(job, item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, item := range val {
+			res[i] = s.SafeReplaceValue(job, item)
+		}
+		return res
+	case map[string]string:
+		res := make(map[string]string, len(val))
+		for k, item := range val {
+			res[s.SafeReplaceJob(job, k)] = s.SafeReplaceJob(job, item)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			res[s.SafeReplaceJob(job, k)] = s.SafeReplaceValue(job, item)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[interface{}]interface{}, len(val))
+		for k, item := range val {
+			res[s.SafeReplaceValue(job, k)] = s.SafeReplaceValue(job, item)
+		}
+		return res
+	}
+	return v
+}
+
+// minEncodedLength 替换编码形式的最短密码长度, 较短密码的编码(例如hex的"31")容易与输出中无关的内容重合
+const minEncodedLength = 6
+
+// encodings 密码及其常见编码形式(base64, URL, hex, JSON转义), 编码后出现在输出中同样需要替换
+func encodings(secret string) []string {
+	if len(secret) < minEncodedLength {
+		return []string{secret}
+	}
+	raw := []byte(secret)
+	forms := []string{
+		secret,
+		base64.StdEncoding.EncodeToString(raw),
+		base64.RawStdEncoding.EncodeToString(raw),
+		base64.URLEncoding.EncodeToString(raw),
+		base64.RawURLEncoding.EncodeToString(raw),
+		url.QueryEscape(secret),
+		url.PathEscape(secret),
+		hex.EncodeToString(raw),
+		strings.ToUpper(hex.EncodeToString(raw)),
+	}
+	// json.Marshal默认转义<>&, 两种形式都可能出现
+	if b, err := json.Marshal(secret); err == nil {
+		forms = append(forms, string(b[1:len(b)-1]))
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(secret); err == nil {
+		b := bytes.TrimSpace(buf.Bytes())
+		forms = append(forms, string(b[1:len(b)-1]))
+	}
+	return forms
+}
+
+// buildReplacer 构建多模式替换器, 较长的密码优先匹配, 避免前缀相同的密码只被替换一部分
+func buildReplacer(sets ...map[string]struct{}) *strings.Replacer {
+	var secrets []string
+	seen := make(map[string]struct{})
+	for _, set := range sets {
+		for secret := range set {
+			for _, form := range encodings(secret) {
+				if _, ok := seen[form]; ok || form == "" {
+					continue
+				}
+				seen[form] = struct{}{}
+				secrets = append(secrets, form)
+			}
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+	oldnew := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, strings.Repeat("*", len(secret)))
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// SecretScope 线程所属执行的密码作用域, 子线程通过继承的上下文使用主线程的作用域, 未绑定上下文时使用线程名
+func SecretScope(thread *starlark.Thread) string {
+	if c := FromThread(thread); c != nil && c.job != "" {
+		return c.job
+	}
+	return thread.Name
+}
+
+// Redact 使用线程所属job的密码安全替换
+func Redact(thread *starlark.Thread, msg string) string {
+	return NewSecretsManager().SafeReplaceJob(SecretScope(thread), msg)
+}
+
+// RedactValue 使用线程所属job的密码替换结构化值中的密码
+func RedactValue(thread *starlark.Thread, v interface{}) interface{} {
+	return NewSecretsManager().SafeReplaceValue(SecretScope(thread), v)
+}
+
+// redactedError 替换了密码的错误信息, 保留原始错误用于errors.Is/errors.As
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactError 使用线程所属job的密码替换错误信息, 不包含密码时返回原始错误
+func RedactError(thread *starlark.Thread, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if safeMsg := Redact(thread, msg); safeMsg != msg {
+		return &redactedError{msg: safeMsg, err: err}
 	}
-	return newMsg
+	return err
 }
 
 // SafePrint for replace builtin "print"
 func SafePrint(thread *starlark.Thread, msg string) {
-	sm := NewSecretsManager()
-	safeMsg := sm.SafeReplace(msg)
-	fmt.Println(safeMsg)
+	fmt.Println(Redact(thread, msg))
 }
@@ -1,7 +1,11 @@
 package context
 
 import (
+	"errors"
+	"strings"
 	"testing"
+
+	"go.starlark.net/starlark"
 )
 
 func TestSeretsManager(t *testing.T) {
@@ -23,3 +27,121 @@ func TestSafeReplace(t *testing.T) {
 		t.Errorf("expected: %s, got: %s", expect, newmsg)
 	}
 }
+
+func TestJobSecrets(t *testing.T) {
+	sm := NewSecretsManager()
+	sm.AddSecret("global_pass")
+	sm.AddJobSecret("job_a", "token_a")
+	sm.AddJobSecret("job_a", "token_a_long")
+	sm.AddJobSecret("job_b", "token_b")
+
+	msg := "global_pass token_a token_a_long token_b"
+	for job, expect := range map[string]string{
+		"job_a": "*********** ******* ************ token_b",
+		"job_b": "*********** token_a token_a_long *******",
+		"job_c": "*********** token_a token_a_long token_b",
+	} {
+		if got := sm.SafeReplaceJob(job, msg); got != expect {
+			t.Errorf("%s: expected %s, got %s", job, expect, got)
+		}
+	}
+	if !sm.HasJobSecret("job_a", "global_pass") || sm.HasJobSecret("job_b", "token_a") {
+		t.Error("unexpected job secrets")
+	}
+
+	// 全局密码变更后job的替换器重新构建
+	sm.AddSecret("late_global")
+	if got := sm.SafeReplaceJob("job_a", "late_global"); got != "***********" {
+		t.Errorf("expected global secret replaced, got %s", got)
+	}
+
+	sm.Release("job_a")
+	if got := sm.SafeReplaceJob("job_a", "token_a"); got != "token_a" {
+		t.Errorf("expected released secret kept, got %s", got)
+	}
+}
+
+func TestContextSecretScope(t *testing.T) {
+	thread := &starlark.Thread{Name: "scope_job"}
+	c := NewContext(nil, map[string]string{"pass": "scope_pass"})
+	c.Bind(thread)
+	child := &starlark.Thread{Name: "scope_job/0"}
+	InheritLocals(thread, child)
+	if got := Redact(child, "scope_pass"); got != "**********" {
+		t.Errorf("expected child thread use job secrets, got %s", got)
+	}
+	if got := NewSecretsManager().SafeReplace("scope_pass"); got != "scope_pass" {
+		t.Errorf("job secret should not be global, got %s", got)
+	}
+
+	// 同名的执行使用不同的作用域
+	other := &starlark.Thread{Name: "scope_job"}
+	NewContext(nil, map[string]string{"pass": "other_pass"}).Bind(other)
+	if SecretScope(other) == SecretScope(thread) {
+		t.Errorf("expected runs with the same name use different scopes, got %s", SecretScope(thread))
+	}
+	if got := Redact(thread, "other_pass"); got != "other_pass" {
+		t.Errorf("secret of another run should not be used, got %s", got)
+	}
+
+	tm := NewTaskManager()
+	tm.AddWithPublisher("scope_job", thread, nil)
+	tm.Delete("scope_job", nil)
+	if NewSecretsManager().HasJobSecret(SecretScope(thread), "scope_pass") {
+		t.Error("expected job secrets released")
+	}
+	if got := Redact(other, "other_pass"); got != "**********" {
+		t.Errorf("secrets of the running job should be kept, got %s", got)
+	}
+	NewSecretsManager().Release(SecretScope(other))
+}
+
+func TestEncodedSecrets(t *testing.T) {
+	sm := NewSecretsManager()
+	sm.AddJobSecret("encoded_job", `p@ss/w"rd<1>`)
+	for _, encoded := range []string{
+		"cEBzcy93InJkPDE+",         // base64
+		"p%40ss%2Fw%22rd%3C1%3E",   // URL
+		"704073732f772272643c313e", // hex
+		`p@ss/w\"rd<1>`,            // JSON
+		`p@ss/w\"rd\u003c1\u003e`,  // JSON, 转义HTML字符
+	} {
+		msg := "data: " + encoded + " end"
+		expect := "data: " + strings.Repeat("*", len(encoded)) + " end"
+		if got := sm.SafeReplaceJob("encoded_job", msg); got != expect {
+			t.Errorf("expected %s, got %s", expect, got)
+		}
+	}
+	sm.Release("encoded_job")
+
+	// 较短的密码只替换原文, 不替换编码形式
+	sm.AddJobSecret("short_job", "1")
+	defer sm.Release("short_job")
+	if got := sm.SafeReplaceJob("short_job", "port 3100, hex 31, MQ=="); got != "port 3*00, hex 3*, MQ==" {
+		t.Errorf("unexpected replaced message %s", got)
+	}
+}
+
+func TestRedactValueAndError(t *testing.T) {
+	thread := &starlark.Thread{Name: "redact_job"}
+	NewContext(nil, map[string]string{"pass": "redact_pass"}).Bind(thread)
+	defer NewSecretsManager().Release("redact_job")
+
+	data := map[string]interface{}{
+		"list": []interface{}{"x redact_pass", 1},
+		"map":  map[string]interface{}{"k": "redact_pass"},
+	}
+	got := RedactValue(thread, data).(map[string]interface{})
+	if got["list"].([]interface{})[0] != "x ***********" || got["map"].(map[string]interface{})["k"] != "***********" {
+		t.Errorf("unexpected redacted value %v", got)
+	}
+	if data["map"].(map[string]interface{})["k"] != "redact_pass" {
+		t.Error("original value should not be modified")
+	}
+
+	limitErr := &LimitError{Limit: LimitSteps, Err: errors.New("login with redact_pass failed")}
+	err := RedactError(thread, limitErr)
+	if err.Error() != "login with *********** failed" || ExceededLimit(err) != LimitSteps {
+		t.Errorf("unexpected redacted error %v", err)
+	}
+}
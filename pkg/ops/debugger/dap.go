@@ -0,0 +1,448 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExceptionFilter 内置函数返回错误时暂停的异常断点
+const ExceptionFilter = "builtin"
+
+// request DAP请求
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// response DAP响应
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// eventMessage DAP事件
+type eventMessage struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Session 一个DAP客户端连接, 请求在Serve的goroutine中处理, stopped和output事件由脚本的goroutine发送
+type Session struct {
+	// OnTerminate 客户端要求结束脚本时调用(terminate请求, 或者disconnect时terminateDebuggee为true)
+	OnTerminate func()
+
+	d    *Debugger
+	conn io.ReadWriteCloser
+
+	wmu    sync.Mutex
+	seq    int
+	closed bool
+
+	launched   bool
+	configured bool
+	ready      chan struct{}
+	readyOnce  sync.Once
+	done       chan struct{}
+}
+
+// NewSession 创建DAP会话, 接收调试器的暂停通知
+func NewSession(conn io.ReadWriteCloser, d *Debugger) *Session {
+	s := &Session{
+		d:     d,
+		conn:  conn,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	d.SetHandler(s)
+	return s
+}
+
+// Ready 客户端完成launch/attach和断点配置后关闭, 之后可以开始执行脚本
+func (s *Session) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Done 客户端断开后关闭
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Serve 处理客户端请求直到断开, 断开后调试器不再暂停
+func (s *Session) Serve() error {
+	defer func() {
+		s.d.Detach()
+		s.wmu.Lock()
+		s.closed = true
+		s.wmu.Unlock()
+		_ = s.conn.Close()
+		close(s.done)
+	}()
+	r := bufio.NewReader(s.conn)
+	for {
+		buf, err := readMessage(r)
+		if err != nil {
+			// 客户端断开或执行结束后由命令关闭连接
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(buf, &req); err != nil {
+			return fmt.Errorf("invalid dap message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if !s.handle(&req) {
+			return nil
+		}
+	}
+}
+
+// Write 将脚本的输出作为output事件发送, 客户端断开后丢弃
+func (s *Session) Write(p []byte) (int, error) {
+	s.Output("stdout", string(p))
+	return len(p), nil
+}
+
+// Output 发送output事件
+func (s *Session) Output(category, text string) {
+	s.event("output", map[string]interface{}{"category": category, "output": text})
+}
+
+// Exited 脚本执行结束
+func (s *Session) Exited(code int) {
+	s.event("exited", map[string]interface{}{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+// Stopped 实现Handler
+func (s *Session) Stopped(stop Stop) {
+	body := map[string]interface{}{
+		"reason":            stop.Reason,
+		"threadId":          stop.ThreadID,
+		"allThreadsStopped": true,
+	}
+	if stop.Text != "" {
+		body["text"] = stop.Text
+		body["description"] = "Paused on builtin error"
+	}
+	s.event("stopped", body)
+}
+
+// Continued 实现Handler
+func (s *Session) Continued(threadID int) {
+	s.event("continued", map[string]interface{}{"threadId": threadID, "allThreadsContinued": true})
+}
+
+// handle 处理一个请求, 返回false时结束会话
+func (s *Session) handle(req *request) bool {
+	body, err := s.dispatch(req)
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	s.send(resp)
+	switch req.Command {
+	case "initialize":
+		if err == nil {
+			s.event("initialized", nil)
+		}
+	case "disconnect":
+		return false
+	}
+	return true
+}
+
+// dispatch 按命令处理请求, 返回响应的body
+func (s *Session) dispatch(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+			"supportsExceptionInfoRequest":     true,
+			"exceptionBreakpointFilters": []map[string]interface{}{
+				{"filter": ExceptionFilter, "label": "Builtin errors", "default": false},
+			},
+		}, nil
+	case "launch", "attach":
+		var args struct {
+			StopOnEntry bool `json:"stopOnEntry"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		s.d.SetStopOnEntry(args.StopOnEntry)
+		s.launched = true
+		s.checkReady()
+		return nil, nil
+	case "configurationDone":
+		s.configured = true
+		s.checkReady()
+		return nil, nil
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		var args struct {
+			Filters []string `json:"filters"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		stop := false
+		for _, filter := range args.Filters {
+			stop = stop || filter == ExceptionFilter
+		}
+		s.d.SetStopOnError(stop)
+		return nil, nil
+	case "threads":
+		threads := []map[string]interface{}{}
+		for _, t := range s.d.Threads() {
+			threads = append(threads, map[string]interface{}{"id": t.ID, "name": t.Name})
+		}
+		return map[string]interface{}{"threads": threads}, nil
+	case "stackTrace":
+		return s.stackTrace(req)
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		scopes, err := s.d.Scopes(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		list := []map[string]interface{}{}
+		for _, scope := range scopes {
+			list = append(list, map[string]interface{}{"name": scope.Name, "variablesReference": scope.Ref, "expensive": false})
+		}
+		return map[string]interface{}{"scopes": list}, nil
+	case "variables":
+		var args struct {
+			Ref int `json:"variablesReference"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		vars, err := s.d.Variables(args.Ref)
+		if err != nil {
+			return nil, err
+		}
+		list := []map[string]interface{}{}
+		for _, v := range vars {
+			list = append(list, map[string]interface{}{"name": v.Name, "value": v.Value, "type": v.Type, "variablesReference": v.Ref})
+		}
+		return map[string]interface{}{"variables": list}, nil
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			FrameID    int    `json:"frameId"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		v, err := s.d.Evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.Ref}, nil
+	case "exceptionInfo":
+		stop, ok := s.d.Stopped()
+		if !ok || stop.Reason != ReasonException {
+			return nil, errors.New("not paused on a builtin error")
+		}
+		return map[string]interface{}{"exceptionId": ExceptionFilter, "description": stop.Text, "breakMode": "always"}, nil
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, s.d.Continue()
+	case "next":
+		return nil, s.d.Next()
+	case "stepIn":
+		return nil, s.d.StepIn()
+	case "stepOut":
+		return nil, s.d.StepOut()
+	case "pause":
+		s.d.Pause()
+		return nil, nil
+	case "terminate":
+		s.terminate()
+		return nil, nil
+	case "disconnect":
+		var args struct {
+			TerminateDebuggee bool `json:"terminateDebuggee"`
+		}
+		if err := unmarshalArgs(req, &args); err != nil {
+			return nil, err
+		}
+		if args.TerminateDebuggee {
+			s.terminate()
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+func (s *Session) setBreakpoints(req *request) (interface{}, error) {
+	var args struct {
+		Source struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		Lines []int `json:"lines"`
+	}
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	if args.Source.Path == "" {
+		return nil, errors.New("setBreakpoints: source.path is required")
+	}
+	lines := args.Lines
+	if args.Breakpoints != nil {
+		lines = make([]int, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			lines[i] = bp.Line
+		}
+	}
+	list := []map[string]interface{}{}
+	for _, line := range s.d.SetBreakpoints(args.Source.Path, lines) {
+		list = append(list, map[string]interface{}{"verified": true, "line": line})
+	}
+	return map[string]interface{}{"breakpoints": list}, nil
+}
+
+func (s *Session) stackTrace(req *request) (interface{}, error) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := unmarshalArgs(req, &args); err != nil {
+		return nil, err
+	}
+	frames, err := s.d.StackTrace()
+	if err != nil {
+		return nil, err
+	}
+	total := len(frames)
+	if args.StartFrame > 0 && args.StartFrame < len(frames) {
+		frames = frames[args.StartFrame:]
+	} else if args.StartFrame >= len(frames) {
+		frames = nil
+	}
+	if args.Levels > 0 && args.Levels < len(frames) {
+		frames = frames[:args.Levels]
+	}
+	list := []map[string]interface{}{}
+	for _, f := range frames {
+		frame := map[string]interface{}{"id": f.ID, "name": f.Name, "line": f.Line, "column": f.Column}
+		if f.Path != "" {
+			frame["source"] = map[string]interface{}{"name": filepath.Base(f.Path), "path": f.Path}
+		} else {
+			frame["presentationHint"] = "subtle"
+		}
+		list = append(list, frame)
+	}
+	return map[string]interface{}{"stackFrames": list, "totalFrames": total}, nil
+}
+
+// checkReady launch/attach和configurationDone都收到后开始执行
+func (s *Session) checkReady() {
+	if s.launched && s.configured {
+		s.readyOnce.Do(func() { close(s.ready) })
+	}
+}
+
+// terminate 不再暂停并结束脚本
+func (s *Session) terminate() {
+	s.d.Detach()
+	if s.OnTerminate != nil {
+		s.OnTerminate()
+	}
+}
+
+func (s *Session) event(name string, body interface{}) {
+	s.send(&eventMessage{Type: "event", Event: name, Body: body})
+}
+
+// send 按Content-Length分帧发送消息, 分配递增的seq
+func (s *Session) send(msg interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.closed {
+		return
+	}
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *eventMessage:
+		m.Seq = s.seq
+	}
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(s.conn, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+}
+
+func unmarshalArgs(req *request, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, v); err != nil {
+		return fmt.Errorf("invalid arguments of %s: %w", req.Command, err)
+	}
+	return nil
+}
+
+// readMessage 读取一条Content-Length分帧的消息
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid dap header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("dap header without Content-Length")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,865 @@
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// stateKey thread local中保存线程调试状态的key
+	stateKey = "HYPEROPS_DEBUGGER_STATE"
+	// maxValueLen 变量值展示的最大长度
+	maxValueLen = 512
+
+	// 暂停的原因, 与DAP stopped事件的reason一致
+	ReasonEntry      = "entry"
+	ReasonBreakpoint = "breakpoint"
+	ReasonStep       = "step"
+	ReasonPause      = "pause"
+	ReasonException  = "exception"
+)
+
+// ErrNotPaused 只有暂停时可以查看调用栈, 变量和求值
+var ErrNotPaused = errors.New("debugger: not paused")
+
+// stepMode 恢复执行后的单步方式
+type stepMode int
+
+const (
+	modeRun stepMode = iota
+	modeStepIn
+	modeStepOver
+	modeStepOut
+)
+
+// Stop 一次暂停
+type Stop struct {
+	ThreadID int
+	Reason   string
+	Text     string // 暂停在内置函数错误时为错误信息, 已脱敏
+}
+
+// Handler 接收暂停和恢复的通知, 由DAP会话实现
+type Handler interface {
+	Stopped(stop Stop)
+	// Continued 不是由客户端发起的恢复, 例如通过server的recovery接口
+	Continued(threadID int)
+}
+
+// Thread 调试中的线程
+type Thread struct {
+	ID   int
+	Name string
+}
+
+// Frame 调用栈中的一帧, 内置函数的Path为空
+type Frame struct {
+	ID     int
+	Name   string
+	Path   string
+	Line   int
+	Column int
+}
+
+// Scope 帧的变量作用域
+type Scope struct {
+	Name string
+	Ref  int
+}
+
+// Variable 变量, Ref不为0时可以展开
+type Variable struct {
+	Name  string
+	Value string
+	Type  string
+	Ref   int
+}
+
+// threadState 线程的调试状态, 只在线程自身的goroutine中读写
+type threadState struct {
+	id         int
+	thread     *starlark.Thread
+	lines      []int32 // 每层调用栈当前执行的行
+	evaluating bool
+}
+
+// pause 暂停中的线程, 查看变量和求值通过cmds在线程的goroutine中执行
+type pause struct {
+	ts      *threadState
+	stop    Stop
+	top     int // 暂停的帧, 在调用前暂停时隐藏被调用的函数
+	depth   int // 暂停的帧的深度
+	cmds    chan func()
+	resume  chan stepMode
+	resumed chan struct{}
+	refs    []func() []Variable
+}
+
+// Debugger starlark脚本调试器, 通过每条指令执行前的hook实现断点和单步执行, 同一时间只暂停一个线程
+type Debugger struct {
+	mu          sync.Mutex
+	handler     Handler
+	breakpoints map[string]map[int]bool
+	stopOnError bool
+	stopOnEntry bool
+	pauseReq    bool
+	mode        stepMode
+	stepThread  *starlark.Thread
+	stepDepth   int
+	detached    bool
+	done        chan struct{}
+	active      int32 // 是否有需要检查的暂停条件, 为0时hook直接返回
+
+	script      string // 入口脚本的绝对路径
+	scriptNames map[string]bool
+	predeclared starlark.StringDict
+	main        *threadState
+	nextID      int
+	paused      *pause
+	sources     map[string]*source
+
+	pauseMu sync.Mutex
+}
+
+// New 创建调试器
+func New() *Debugger {
+	return &Debugger{
+		breakpoints: make(map[string]map[int]bool),
+		done:        make(chan struct{}),
+		sources:     make(map[string]*source),
+	}
+}
+
+// SetHandler 设置暂停和恢复的通知
+func (d *Debugger) SetHandler(h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handler = h
+}
+
+// Start 绑定到job的主线程, script为入口脚本路径, predeclared用于表达式求值
+func (d *Debugger) Start(thread *starlark.Thread, script string, predeclared starlark.StringDict) {
+	abs, err := filepath.Abs(script)
+	if err != nil {
+		abs = script
+	}
+	d.mu.Lock()
+	d.script = abs
+	// 入口脚本以文件名或者原路径执行, 都映射到绝对路径
+	d.scriptNames = map[string]bool{script: true, filepath.Base(script): true}
+	d.predeclared = predeclared
+	d.mu.Unlock()
+	localctx.BindDebugger(thread, d)
+}
+
+// Attach 接入线程, 每条指令执行前检查断点和单步, 线程设置了步数限制时串联原有的hook
+func (d *Debugger) Attach(thread *starlark.Thread) {
+	d.mu.Lock()
+	d.nextID++
+	ts := &threadState{id: d.nextID, thread: thread}
+	if d.main == nil {
+		d.main = ts
+	}
+	d.mu.Unlock()
+	thread.SetLocal(stateKey, ts)
+
+	var maxSteps uint64
+	if l := localctx.LimitsFromThread(thread); l != nil {
+		maxSteps = l.MaxSteps
+	}
+	onMaxSteps := thread.OnMaxSteps
+	thread.SetMaxExecutionSteps(1)
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		d.step(ts)
+		if maxSteps > 0 && thread.Steps >= maxSteps && onMaxSteps != nil {
+			onMaxSteps(thread)
+		}
+	}
+}
+
+// BeforeBuiltin 内置函数执行前检查调用方, 断点所在行调用内置函数时在调用前暂停
+func (d *Debugger) BeforeBuiltin(thread *starlark.Thread) {
+	ts, _ := thread.Local(stateKey).(*threadState)
+	if ts == nil || ts.evaluating || atomic.LoadInt32(&d.active) == 0 {
+		return
+	}
+	d.check(ts)
+}
+
+// BuiltinError 开启了异常断点时, 在内置函数的错误返回给脚本前暂停
+func (d *Debugger) BuiltinError(thread *starlark.Thread, name string, err error) {
+	ts, _ := thread.Local(stateKey).(*threadState)
+	if ts == nil || ts.evaluating {
+		return
+	}
+	d.mu.Lock()
+	stop := d.stopOnError && !d.detached
+	d.mu.Unlock()
+	if stop {
+		d.pause(ts, 0, ReasonException, localctx.Redact(thread, fmt.Sprintf("%s: %s", name, err)))
+	}
+}
+
+// SetBreakpoints 替换文件中的行断点, 不在语句起始行的断点移动到所在或者下一条语句, 返回实际的行
+func (d *Debugger) SetBreakpoints(path string, lines []int) []int {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	src := d.source(path)
+	actual := make([]int, len(lines))
+	for i, line := range lines {
+		actual[i] = int(src.breakpointLine(int32(line)))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.breakpoints, path)
+	if len(actual) > 0 {
+		set := make(map[int]bool, len(actual))
+		for _, line := range actual {
+			set[line] = true
+		}
+		d.breakpoints[path] = set
+	}
+	d.update()
+	return actual
+}
+
+// SetStopOnError 是否在内置函数返回错误时暂停
+func (d *Debugger) SetStopOnError(stop bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopOnError = stop
+}
+
+// SetStopOnEntry 是否在执行第一行前暂停, 需要在脚本开始执行前设置
+func (d *Debugger) SetStopOnEntry(stop bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopOnEntry = stop
+	d.update()
+}
+
+// Pause 在任意线程执行到下一行时暂停
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pauseReq = true
+	d.update()
+}
+
+// Continue 恢复执行直到下一个断点
+func (d *Debugger) Continue() error { return d.resume(modeRun) }
+
+// Next 执行到当前函数的下一行
+func (d *Debugger) Next() error { return d.resume(modeStepOver) }
+
+// StepIn 执行到下一行, 包括进入调用的函数
+func (d *Debugger) StepIn() error { return d.resume(modeStepIn) }
+
+// StepOut 执行到当前函数返回
+func (d *Debugger) StepOut() error { return d.resume(modeStepOut) }
+
+// Detach 清除所有断点并恢复执行, 之后不再暂停
+func (d *Debugger) Detach() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.detached {
+		return
+	}
+	d.detached = true
+	d.breakpoints = make(map[string]map[int]bool)
+	d.stopOnError, d.stopOnEntry, d.pauseReq = false, false, false
+	d.mode = modeRun
+	d.update()
+	close(d.done)
+}
+
+// Threads 主线程和暂停中的线程
+func (d *Debugger) Threads() []Thread {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var threads []Thread
+	if d.main != nil {
+		threads = append(threads, Thread{ID: d.main.id, Name: d.main.thread.Name})
+	}
+	if p := d.paused; p != nil && p.ts != d.main {
+		threads = append(threads, Thread{ID: p.ts.id, Name: fmt.Sprintf("%s #%d", p.ts.thread.Name, p.ts.id)})
+	}
+	return threads
+}
+
+// Stopped 当前的暂停, 未暂停时返回false
+func (d *Debugger) Stopped() (Stop, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused == nil {
+		return Stop{}, false
+	}
+	return d.paused.stop, true
+}
+
+// StackTrace 暂停线程的调用栈, 从最内层开始, 帧的ID在本次暂停内有效
+func (d *Debugger) StackTrace() ([]Frame, error) {
+	var frames []Frame
+	err := d.do(func(p *pause) error {
+		thread := p.ts.thread
+		for i := p.top; i < thread.CallStackDepth(); i++ {
+			fr := thread.DebugFrame(i)
+			frame := Frame{ID: i + 1, Name: fr.Callable().Name()}
+			if _, ok := fr.Callable().(*starlark.Function); ok {
+				pos := fr.Position()
+				frame.Path = d.sourcePath(pos.Filename())
+				frame.Line, frame.Column = int(d.source(frame.Path).statementLine(pos.Line)), int(pos.Col)
+			}
+			frames = append(frames, frame)
+		}
+		return nil
+	})
+	return frames, err
+}
+
+// Scopes 帧的局部变量和全局变量
+func (d *Debugger) Scopes(frameID int) ([]Scope, error) {
+	var scopes []Scope
+	err := d.do(func(p *pause) error {
+		fr, err := p.frame(frameID)
+		if err != nil {
+			return err
+		}
+		fn, ok := fr.Callable().(*starlark.Function)
+		if !ok {
+			return nil
+		}
+		locals := d.frameLocals(fr)
+		globals := fn.Globals()
+		scopes = []Scope{
+			{Name: "Locals", Ref: p.ref(func() []Variable { return p.dictVariables(locals) })},
+			{Name: "Globals", Ref: p.ref(func() []Variable { return p.dictVariables(globals) })},
+		}
+		return nil
+	})
+	return scopes, err
+}
+
+// Variables 展开作用域或者容器类型的变量
+func (d *Debugger) Variables(ref int) ([]Variable, error) {
+	var vars []Variable
+	err := d.do(func(p *pause) error {
+		if ref <= 0 || ref > len(p.refs) {
+			return fmt.Errorf("debugger: invalid variables reference %d", ref)
+		}
+		vars = p.refs[ref-1]()
+		return nil
+	})
+	return vars, err
+}
+
+// Evaluate 在暂停的帧中对表达式求值, frameID为0时使用最内层的starlark函数
+func (d *Debugger) Evaluate(frameID int, expr string) (Variable, error) {
+	var ret Variable
+	err := d.do(func(p *pause) error {
+		thread := p.ts.thread
+		if frameID == 0 {
+			for i := p.top; i < thread.CallStackDepth(); i++ {
+				if _, ok := thread.DebugFrame(i).Callable().(*starlark.Function); ok {
+					frameID = i + 1
+					break
+				}
+			}
+		}
+		env := starlark.StringDict{}
+		for name, v := range d.predeclared {
+			env[name] = v
+		}
+		if fr, err := p.frame(frameID); err == nil {
+			if fn, ok := fr.Callable().(*starlark.Function); ok {
+				for name, v := range fn.Globals() {
+					env[name] = v
+				}
+				for name, v := range d.frameLocals(fr) {
+					env[name] = v
+				}
+			}
+		}
+		// 求值中的指令和内置函数错误不触发暂停
+		p.ts.evaluating = true
+		defer func() { p.ts.evaluating = false }()
+		v, err := starlark.Eval(thread, "<eval>", expr, env)
+		if err != nil {
+			return errors.New(localctx.Redact(thread, err.Error()))
+		}
+		ret = p.variable("", v)
+		return nil
+	})
+	return ret, err
+}
+
+// update 重新计算是否有需要检查的暂停条件, 调用时需持有d.mu
+func (d *Debugger) update() {
+	var active int32
+	if len(d.breakpoints) > 0 || d.mode != modeRun || d.pauseReq || d.stopOnEntry {
+		active = 1
+	}
+	atomic.StoreInt32(&d.active, active)
+}
+
+// resume 恢复暂停的线程
+func (d *Debugger) resume(mode stepMode) error {
+	d.mu.Lock()
+	p := d.paused
+	d.mu.Unlock()
+	if p == nil {
+		return ErrNotPaused
+	}
+	select {
+	case p.resume <- mode:
+	default:
+	}
+	return nil
+}
+
+// do 在暂停线程的goroutine中执行fn
+func (d *Debugger) do(fn func(p *pause) error) error {
+	d.mu.Lock()
+	p := d.paused
+	d.mu.Unlock()
+	if p == nil {
+		return ErrNotPaused
+	}
+	errCh := make(chan error, 1)
+	select {
+	case p.cmds <- func() { errCh <- fn(p) }:
+		return <-errCh
+	case <-p.resumed:
+		return ErrNotPaused
+	}
+}
+
+// step 每条指令执行前调用
+func (d *Debugger) step(ts *threadState) {
+	if ts.evaluating || atomic.LoadInt32(&d.active) == 0 {
+		return
+	}
+	d.check(ts)
+}
+
+// check 检查执行到新语句的帧是否需要暂停, 调用栈变化时从外到内检查所有帧, 调用方的位置即为调用所在的语句.
+// 编译后只有可能出错的指令(调用, 运算等)记录了位置, 没有这些指令的语句不会暂停
+func (d *Debugger) check(ts *threadState) {
+	thread := ts.thread
+	depth := thread.CallStackDepth()
+	returned := len(ts.lines) > depth
+	outer := 0
+	if len(ts.lines) != depth {
+		outer = depth - 1
+	}
+	for len(ts.lines) < depth {
+		ts.lines = append(ts.lines, 0)
+	}
+	ts.lines = ts.lines[:depth]
+
+	for i := outer; i >= 0; i-- {
+		fr := thread.DebugFrame(i)
+		fn, ok := fr.Callable().(*starlark.Function)
+		if !ok {
+			continue
+		}
+		pos := fr.Position()
+		// 函数的第一条指令执行前位置为def所在行
+		if start := fn.Position(); i == 0 && pos.Line == start.Line && pos.Col == start.Col {
+			if d.takeEntry() {
+				d.pause(ts, 0, ReasonEntry, "")
+				return
+			}
+			continue
+		}
+		level := depth - i
+		path := d.sourcePath(pos.Filename())
+		line := d.source(path).statementLine(pos.Line)
+		newLine := ts.lines[level-1] != line
+		if !newLine && !(returned && i == 0) {
+			continue
+		}
+		ts.lines[level-1] = line
+		if reason := d.stopReason(thread, level, path, line, newLine); reason != "" {
+			d.pause(ts, i, reason, "")
+			return
+		}
+	}
+}
+
+// takeEntry 是否需要在执行第一行前暂停, 只暂停一次
+func (d *Debugger) takeEntry() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry := d.stopOnEntry && !d.detached
+	d.stopOnEntry = false
+	d.update()
+	return entry
+}
+
+// stopReason 帧执行到新语句或者从调用返回时是否需要暂停, level为帧的深度
+func (d *Debugger) stopReason(thread *starlark.Thread, level int, path string, line int32, newLine bool) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.detached {
+		return ""
+	}
+	if d.stopOnEntry {
+		d.stopOnEntry = false
+		d.update()
+		return ReasonEntry
+	}
+	if d.pauseReq {
+		d.pauseReq = false
+		d.update()
+		return ReasonPause
+	}
+	switch d.mode {
+	case modeStepIn:
+		return ReasonStep
+	case modeStepOver:
+		if thread == d.stepThread && (level < d.stepDepth || level == d.stepDepth && newLine) {
+			return ReasonStep
+		}
+	case modeStepOut:
+		if thread == d.stepThread && level < d.stepDepth {
+			return ReasonStep
+		}
+	}
+	if newLine && d.breakpoints[path][int(line)] {
+		return ReasonBreakpoint
+	}
+	return ""
+}
+
+// pause 在第top帧暂停线程直到客户端恢复执行, 暂停期间task状态为hanging, 通过server的recovery接口也可以恢复
+func (d *Debugger) pause(ts *threadState, top int, reason, text string) {
+	d.pauseMu.Lock()
+	defer d.pauseMu.Unlock()
+	thread := ts.thread
+	p := &pause{
+		ts:      ts,
+		stop:    Stop{ThreadID: ts.id, Reason: reason, Text: text},
+		top:     top,
+		depth:   thread.CallStackDepth() - top,
+		cmds:    make(chan func()),
+		resume:  make(chan stepMode, 1),
+		resumed: make(chan struct{}),
+	}
+	d.mu.Lock()
+	if d.detached {
+		d.mu.Unlock()
+		return
+	}
+	d.paused = p
+	d.mode = modeRun
+	handler := d.handler
+	d.mu.Unlock()
+
+	tm := localctx.GetTaskManager()
+	var recoveryCh chan string
+	task := tm.Get(thread.Name)
+	if task != nil && tm.Hang(thread.Name) == nil {
+		recoveryCh = task.RecoveryCh
+	}
+	if handler != nil {
+		handler.Stopped(p.stop)
+	}
+
+	mode, recovered := modeRun, false
+loop:
+	for {
+		select {
+		case fn := <-p.cmds:
+			fn()
+		case mode = <-p.resume:
+			break loop
+		case <-recoveryCh:
+			recovered = true
+			break loop
+		case <-d.done:
+			break loop
+		}
+	}
+
+	d.mu.Lock()
+	d.paused = nil
+	if !d.detached {
+		d.mode, d.stepThread, d.stepDepth = mode, thread, p.depth
+	}
+	d.update()
+	d.mu.Unlock()
+	close(p.resumed)
+
+	if recoveryCh != nil {
+		task.TrigerEvent(localctx.RunningStatus)
+		_ = tm.RecoveryOver(thread.Name)
+		// 客户端和recovery同时恢复时丢弃多余的recovery
+		select {
+		case <-recoveryCh:
+		default:
+		}
+	}
+	if recovered && handler != nil {
+		handler.Continued(ts.id)
+	}
+}
+
+// sourcePath 文件的绝对路径, 入口脚本以文件名执行, 其他相对路径相对于入口脚本所在目录
+func (d *Debugger) sourcePath(filename string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.scriptNames[filename]:
+		return d.script
+	case filepath.IsAbs(filename):
+		return filepath.Clean(filename)
+	}
+	return filepath.Join(filepath.Dir(d.script), filename)
+}
+
+// frameLocals 帧中已赋值的局部变量
+func (d *Debugger) frameLocals(fr starlark.DebugFrame) (locals starlark.StringDict) {
+	locals = starlark.StringDict{}
+	fn, ok := fr.Callable().(*starlark.Function)
+	if !ok {
+		return locals
+	}
+	// 文件在执行后被修改时变量名可能与帧不一致
+	defer func() {
+		if recover() != nil {
+			locals = starlark.StringDict{}
+		}
+	}()
+	for i, name := range d.localNames(fn) {
+		if v := cellValue(fr.Local(i)); v != nil {
+			locals[name] = v
+		}
+	}
+	return locals
+}
+
+// localNames 函数的局部变量名
+func (d *Debugger) localNames(fn *starlark.Function) []string {
+	src := d.source(d.sourcePath(fn.Position().Filename()))
+	if fn.Name() == "<toplevel>" {
+		return src.locals[[2]int32{}]
+	}
+	pos := fn.Position()
+	return src.locals[[2]int32{pos.Line, pos.Col}]
+}
+
+// source 解析并缓存源文件
+func (d *Debugger) source(path string) *source {
+	d.mu.Lock()
+	src, ok := d.sources[path]
+	d.mu.Unlock()
+	if ok {
+		return src
+	}
+	src = parseSource(path)
+	d.mu.Lock()
+	d.sources[path] = src
+	d.mu.Unlock()
+	return src
+}
+
+// source 编译后的函数不保存变量名和语句的范围, 重新解析源文件获取
+type source struct {
+	locals map[[2]int32][]string // 按def或lambda的位置索引的局部变量名, 顶层使用零值位置
+	lines  map[int32]int32       // 行所在语句的起始行, 复合语句只包含首行
+	starts []int32               // 语句的起始行, 升序
+}
+
+// allSyntax 解析源文件使用的选项, 与执行时的语法选项无关
+var allSyntax = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+// parseSource 解析源文件, 解析失败时返回空的source
+func parseSource(path string) *source {
+	src := &source{locals: make(map[[2]int32][]string), lines: make(map[int32]int32)}
+	// 只需要变量的绑定, 启用全部语法选项并忽略未定义的名称等错误
+	f, err := allSyntax.Parse(path, nil, 0)
+	if err != nil {
+		return src
+	}
+	_ = resolve.File(f, func(string) bool { return true }, starlark.Universe.Has)
+	if m, ok := f.Module.(*resolve.Module); ok {
+		src.locals[[2]int32{}] = bindingNames(m.Locals)
+	}
+	// 先访问外层语句, 内层语句覆盖外层的行
+	syntax.Walk(f, func(n syntax.Node) bool {
+		var fn *resolve.Function
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			fn, _ = n.Function.(*resolve.Function)
+		case *syntax.LambdaExpr:
+			fn, _ = n.Function.(*resolve.Function)
+		}
+		if fn != nil {
+			src.locals[[2]int32{fn.Pos.Line, fn.Pos.Col}] = bindingNames(fn.Locals)
+		}
+		if stmt, ok := n.(syntax.Stmt); ok {
+			start, end := stmt.Span()
+			switch stmt.(type) {
+			case *syntax.DefStmt, *syntax.ForStmt, *syntax.WhileStmt, *syntax.IfStmt:
+				end = start
+			}
+			for line := start.Line; line <= end.Line; line++ {
+				src.lines[line] = start.Line
+			}
+			src.starts = append(src.starts, start.Line)
+		}
+		return true
+	})
+	sort.Slice(src.starts, func(i, j int) bool { return src.starts[i] < src.starts[j] })
+	return src
+}
+
+// statementLine 行所在语句的起始行
+func (s *source) statementLine(line int32) int32 {
+	if start, ok := s.lines[line]; ok {
+		return start
+	}
+	return line
+}
+
+// breakpointLine 断点实际的行, 为所在语句或者之后第一条语句的起始行
+func (s *source) breakpointLine(line int32) int32 {
+	if start, ok := s.lines[line]; ok {
+		return start
+	}
+	i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] >= line })
+	if i < len(s.starts) {
+		return s.starts[i]
+	}
+	return line
+}
+
+func bindingNames(bindings []*resolve.Binding) []string {
+	names := make([]string, len(bindings))
+	for i, b := range bindings {
+		names[i] = b.First.Name
+	}
+	return names
+}
+
+// cellValue 闭包捕获的局部变量在帧中保存为cell, starlark未导出该类型, 通过反射读取其中的值
+func cellValue(v starlark.Value) starlark.Value {
+	if v == nil || v.Type() != "cell" {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct || rv.Elem().NumField() != 1 {
+		return nil
+	}
+	field := rv.Elem().Field(0)
+	inner, _ := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface().(starlark.Value)
+	return inner
+}
+
+// frame 按ID获取暂停线程的帧
+func (p *pause) frame(id int) (starlark.DebugFrame, error) {
+	if id <= p.top || id > p.ts.thread.CallStackDepth() {
+		return nil, fmt.Errorf("debugger: invalid frame %d", id)
+	}
+	return p.ts.thread.DebugFrame(id - 1), nil
+}
+
+// ref 分配可以展开的变量引用, 在本次暂停内有效
+func (p *pause) ref(children func() []Variable) int {
+	p.refs = append(p.refs, children)
+	return len(p.refs)
+}
+
+// variable 变量的展示值, 密码已替换
+func (p *pause) variable(name string, v starlark.Value) Variable {
+	value := localctx.Redact(p.ts.thread, v.String())
+	if len(value) > maxValueLen {
+		value = value[:maxValueLen] + "..."
+	}
+	ret := Variable{Name: name, Value: value, Type: v.Type()}
+	if children := p.children(v); children != nil {
+		ret.Ref = p.ref(children)
+	}
+	return ret
+}
+
+func (p *pause) dictVariables(dict starlark.StringDict) []Variable {
+	vars := make([]Variable, 0, len(dict))
+	for _, name := range dict.Keys() {
+		vars = append(vars, p.variable(name, dict[name]))
+	}
+	return vars
+}
+
+// children 容器类型的元素, struct和模块的属性, 不可展开时返回nil
+func (p *pause) children(v starlark.Value) func() []Variable {
+	switch x := v.(type) {
+	case *starlark.Dict:
+		if x.Len() == 0 {
+			return nil
+		}
+		return func() []Variable {
+			vars := make([]Variable, 0, x.Len())
+			for _, item := range x.Items() {
+				vars = append(vars, p.variable(item[0].String(), item[1]))
+			}
+			return vars
+		}
+	case starlark.String, starlark.Bytes:
+		return nil
+	case starlark.Indexable:
+		if x.Len() == 0 {
+			return nil
+		}
+		return func() []Variable {
+			vars := make([]Variable, x.Len())
+			for i := range vars {
+				vars[i] = p.variable(fmt.Sprint(i), x.Index(i))
+			}
+			return vars
+		}
+	case *starlark.Set:
+		if x.Len() == 0 {
+			return nil
+		}
+		return func() []Variable {
+			var vars []Variable
+			iter := x.Iterate()
+			defer iter.Done()
+			var elem starlark.Value
+			for i := 0; iter.Next(&elem); i++ {
+				vars = append(vars, p.variable(fmt.Sprint(i), elem))
+			}
+			return vars
+		}
+	case starlark.HasAttrs:
+		names := x.AttrNames()
+		if len(names) == 0 {
+			return nil
+		}
+		return func() []Variable {
+			sort.Strings(names)
+			vars := make([]Variable, 0, len(names))
+			for _, name := range names {
+				if attr, err := x.Attr(name); err == nil && attr != nil {
+					vars = append(vars, p.variable(name, attr))
+				}
+			}
+			return vars
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"go.starlark.net/starlark"
+)
+
+const script = `def add(a, b):
+    c = a + b
+    return c
+
+x = add(1, 2)
+y = boom(x)
+`
+
+// client 测试用的DAP客户端, 事件按收到的顺序缓存
+type client struct {
+	t      *testing.T
+	conn   net.Conn
+	seq    int
+	msgs   chan map[string]interface{}
+	events []map[string]interface{}
+}
+
+func newClient(t *testing.T, conn net.Conn) *client {
+	c := &client{t: t, conn: conn, msgs: make(chan map[string]interface{}, 100)}
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			buf, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			msg := map[string]interface{}{}
+			_ = json.Unmarshal(buf, &msg)
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) next() map[string]interface{} {
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for dap message")
+	}
+	return nil
+}
+
+// request 发送请求并返回响应的body, 失败时返回错误信息
+func (c *client) request(command string, args interface{}) (map[string]interface{}, error) {
+	c.seq++
+	buf, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(buf), buf); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.next()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg["request_seq"] != float64(c.seq) {
+			continue
+		}
+		body, _ := msg["body"].(map[string]interface{})
+		if msg["success"] != true {
+			return body, errors.New(fmt.Sprint(msg["message"]))
+		}
+		return body, nil
+	}
+}
+
+func (c *client) mustRequest(command string, args interface{}) map[string]interface{} {
+	body, err := c.request(command, args)
+	if err != nil {
+		c.t.Fatalf("%s failed: %s", command, err)
+	}
+	return body
+}
+
+// event 等待指定的事件, 返回事件的body
+func (c *client) event(name string) map[string]interface{} {
+	for {
+		var msg map[string]interface{}
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+// frames 暂停线程的调用栈, 返回函数名和行号
+func (c *client) frames() []string {
+	body := c.mustRequest("stackTrace", map[string]interface{}{"threadId": 1})
+	var frames []string
+	for _, f := range body["stackFrames"].([]interface{}) {
+		frame := f.(map[string]interface{})
+		frames = append(frames, fmt.Sprintf("%s:%v", frame["name"], frame["line"]))
+	}
+	return frames
+}
+
+func (c *client) evaluate(expr string) string {
+	body := c.mustRequest("evaluate", map[string]interface{}{"expression": expr, "frameId": 1})
+	return fmt.Sprint(body["result"])
+}
+
+func TestDebugger(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.ops")
+	if err := ioutil.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := New()
+	server, conn := net.Pipe()
+	session := NewSession(server, d)
+	go func() { _ = session.Serve() }()
+	c := newClient(t, conn)
+
+	c.mustRequest("initialize", map[string]interface{}{"adapterID": "hyperops"})
+	c.event("initialized")
+	body := c.mustRequest("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 4}, {"line": 2}},
+	})
+	// 空行上的断点移动到下一条语句
+	if bps := body["breakpoints"].([]interface{}); len(bps) != 2 || bps[0].(map[string]interface{})["line"] != float64(5) {
+		t.Fatalf("unexpected breakpoints %v", body)
+	}
+	c.mustRequest("setExceptionBreakpoints", map[string]interface{}{"filters": []string{ExceptionFilter}})
+	c.mustRequest("attach", map[string]interface{}{})
+	c.mustRequest("configurationDone", nil)
+	select {
+	case <-session.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("session should be ready after configurationDone")
+	}
+
+	tm := localctx.GetTaskManager()
+	thread := &starlark.Thread{Name: "debug-job"}
+	tm.AddWithPublisher(thread.Name, thread, nil)
+	defer tm.Delete(thread.Name, nil)
+	calls := 0
+	boom := localctx.AddBuiltin("boom", func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		calls++
+		return starlark.None, fmt.Errorf("boom failed with %s", args[0])
+	})
+	predeclared := starlark.StringDict{"boom": boom}
+	d.Start(thread, path, predeclared)
+	done := make(chan error, 1)
+	go func() {
+		_, err := starlark.ExecFile(thread, filepath.Base(path), script, predeclared)
+		done <- err
+	}()
+
+	// 调用所在行的断点在调用前暂停
+	if stop := c.event("stopped"); stop["reason"] != ReasonBreakpoint {
+		t.Fatalf("expected breakpoint, got %v", stop)
+	}
+	if frames := c.frames(); len(frames) != 1 || frames[0] != "<toplevel>:5" {
+		t.Errorf("expected to stop before calling add, got %v", frames)
+	}
+	c.mustRequest("continue", map[string]interface{}{"threadId": 1})
+	if stop := c.event("stopped"); stop["reason"] != ReasonBreakpoint {
+		t.Fatalf("expected breakpoint, got %v", stop)
+	}
+	if status, _ := tm.Status(thread.Name); status != localctx.HangingStatus {
+		t.Errorf("paused task should be hanging, got %s", status)
+	}
+	if frames := strings.Join(c.frames(), " "); frames != "add:2 <toplevel>:5" {
+		t.Errorf("unexpected stack %s", frames)
+	}
+	scopes := c.mustRequest("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+	locals := c.mustRequest("variables", map[string]interface{}{"variablesReference": scopes[0].(map[string]interface{})["variablesReference"]})
+	var names []string
+	for _, v := range locals["variables"].([]interface{}) {
+		v := v.(map[string]interface{})
+		names = append(names, fmt.Sprintf("%s=%s", v["name"], v["value"]))
+	}
+	if got := strings.Join(names, " "); got != "a=1 b=2" {
+		t.Errorf("unexpected locals %s", got)
+	}
+	if got := c.evaluate("a * 10 + b"); got != "12" {
+		t.Errorf("expected 12, got %s", got)
+	}
+	if _, err := c.request("evaluate", map[string]interface{}{"expression": "undefined_name", "frameId": 1}); err == nil {
+		t.Error("expected evaluate error")
+	}
+
+	c.mustRequest("next", map[string]interface{}{"threadId": 1})
+	c.event("stopped")
+	if frames := c.frames(); frames[0] != "add:3" || c.evaluate("c") != "3" {
+		t.Errorf("next should stop at line 3, got %v", frames)
+	}
+	c.mustRequest("stepOut", map[string]interface{}{"threadId": 1})
+	c.event("stopped")
+	if frames := c.frames(); len(frames) != 1 || frames[0] != "<toplevel>:5" {
+		t.Errorf("stepOut should return to the caller, got %v", frames)
+	}
+
+	c.mustRequest("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 6}},
+	})
+	c.mustRequest("continue", map[string]interface{}{"threadId": 1})
+	c.event("stopped")
+	if frames := c.frames(); len(frames) != 1 || frames[0] != "<toplevel>:6" || calls != 0 {
+		t.Errorf("expected to stop before calling boom, got %v and %d calls", frames, calls)
+	}
+	if got := c.evaluate("x"); got != "3" {
+		t.Errorf("expected global x=3, got %s", got)
+	}
+
+	c.mustRequest("continue", map[string]interface{}{"threadId": 1})
+	stop := c.event("stopped")
+	if stop["reason"] != ReasonException || stop["text"] != "boom: boom failed with 3" {
+		t.Fatalf("expected builtin error, got %v", stop)
+	}
+	if frames := c.frames(); frames[0] != "boom:0" {
+		t.Errorf("expected builtin frame on top, got %v", frames)
+	}
+	// 通过task manager恢复执行, 与server的recovery接口相同
+	if err := tm.Recovery(thread.Name); err != nil {
+		t.Fatal(err)
+	}
+	c.event("continued")
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "boom failed") {
+			t.Errorf("expected boom error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("script did not finish")
+	}
+	if status, _ := tm.Status(thread.Name); status != localctx.RunningStatus {
+		t.Errorf("resumed task should be running, got %s", status)
+	}
+	if _, err := c.request("stackTrace", map[string]interface{}{"threadId": 1}); err == nil {
+		t.Error("stackTrace should fail after the script finished")
+	}
+	c.mustRequest("disconnect", nil)
+	<-session.Done()
+}
@@ -0,0 +1,42 @@
+package ops
+
+import (
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Dialect 单次执行使用的语法选项, 每个文件按执行时的选项解析, 并发执行互不影响
+type Dialect struct {
+	// 是否启用集合类型
+	AllowSet bool
+	// allow reassignment to top-level names; also, allow if/for/while at top-level
+	AllowGlobalReassign bool
+}
+
+// dialectOf 执行配置中的语法选项, 浮点数, lambda和闭包在当前starlark版本中始终启用
+func dialectOf(o *ExecOpts) Dialect {
+	return Dialect{
+		AllowSet:            o.AllowSet,
+		AllowGlobalReassign: o.AllowGlobalReassign,
+	}
+}
+
+// FileOptions 解析文件使用的选项, 不读取go.starlark.net/resolve中已废弃的全局开关,
+// while和递归与原来的resolve.AllowRecursion一样不启用
+func (d Dialect) FileOptions() *syntax.FileOptions {
+	return &syntax.FileOptions{
+		Set:             d.AllowSet,
+		TopLevelControl: d.AllowGlobalReassign,
+		GlobalReassign:  d.AllowGlobalReassign,
+	}
+}
+
+// Parse 使用该选项解析文件, 随后的resolve.File按文件记录的选项检查
+func (d Dialect) Parse(filename string, src interface{}) (*syntax.File, error) {
+	return d.FileOptions().Parse(filename, src, 0)
+}
+
+// ExecFile 与starlark.ExecFile相同, 但使用该选项解析文件
+func (d Dialect) ExecFile(thread *starlark.Thread, filename string, src interface{}, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	return starlark.ExecFileOptions(d.FileOptions(), thread, filename, src, predeclared)
+}
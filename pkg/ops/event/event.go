@@ -20,10 +20,10 @@ type Type string
 
 // Event 事件消息体
 type Event struct {
-	Type      Type
-	Timestamp int64
-	SessionID string
-	Payload   interface{}
+	Type      Type        `json:"type"`
+	Timestamp int64       `json:"timestamp"`
+	SessionID string      `json:"session_id"`
+	Payload   interface{} `json:"payload"`
 }
 
 // MakeEvent 产生event
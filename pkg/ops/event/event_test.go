@@ -169,3 +169,47 @@ func TestEventSubscribeAll(t *testing.T) {
 		t.Errorf("num events (-want +got):\n%s", diff)
 	}
 }
+
+func TestChanPublisher(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+
+	ch := make(chan Event, 1)
+	pub := NewChanPublisher(ch)
+	if err := pub.PublishID(ctx, ETMainSaidHello, "123", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	e := <-ch
+	if diff := cmp.Diff("123", e.SessionID); diff != "" {
+		t.Errorf("session id (-want +got):\n%s", diff)
+	}
+
+	// 没有消费者时，ctx取消后不再阻塞
+	done()
+	if err := NewChanPublisher(make(chan Event)).Publish(ctx, ETMainSaidHello, "hi"); err != context.Canceled {
+		t.Errorf("expect context canceled, got %v", err)
+	}
+}
+
+func TestMultiPublisher(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	bus1, bus2 := NewBus(ctx), NewBus(ctx)
+	var got1, got2 int
+	bus1.SubscribeID(func(ctx context.Context, e Event) error {
+		got1++
+		return fmt.Errorf("handler failed")
+	}, "123")
+	bus2.SubscribeAll(func(ctx context.Context, e Event) error {
+		got2++
+		return nil
+	})
+
+	pub := MultiPublisher(bus1, nil, bus2)
+	if err := pub.PublishID(ctx, ETMainSaidHello, "123", "hi"); err == nil {
+		t.Error("expect error from first publisher")
+	}
+	if got1 != 1 || got2 != 1 {
+		t.Errorf("every publisher should receive the event, got %d and %d", got1, got2)
+	}
+}
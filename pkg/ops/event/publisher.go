@@ -0,0 +1,69 @@
+package event
+
+import (
+	"context"
+)
+
+type chanPublisher struct {
+	ch chan Event
+}
+
+// assert at compile time that chanPublisher implements the Publisher interface
+var _ Publisher = (*chanPublisher)(nil)
+
+// NewChanPublisher 将事件写入channel，兼容直接消费chan Event的使用方式,
+// 写入是同步的，ctx取消后不再阻塞等待消费者
+func NewChanPublisher(ch chan Event) Publisher {
+	return &chanPublisher{ch: ch}
+}
+
+// Publish 发布事件
+func (p *chanPublisher) Publish(ctx context.Context, typ Type, payload interface{}) error {
+	return p.PublishID(ctx, typ, "", payload)
+}
+
+// PublishID 发布指定ID的事件
+func (p *chanPublisher) PublishID(ctx context.Context, typ Type, sessionID string, payload interface{}) error {
+	e := MakeEvent(typ, sessionID, payload)
+	// 消费者可以读取时总是写入, 即使ctx已经取消
+	select {
+	case p.ch <- e:
+		return nil
+	default:
+	}
+	select {
+	case p.ch <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type multiPublisher []Publisher
+
+// MultiPublisher 将事件同时发布到多个发布器，忽略nil发布器
+func MultiPublisher(pubs ...Publisher) Publisher {
+	mp := multiPublisher{}
+	for _, p := range pubs {
+		if p != nil {
+			mp = append(mp, p)
+		}
+	}
+	return mp
+}
+
+// Publish 发布事件，单个发布器失败不影响其他发布器，返回第一个错误
+func (mp multiPublisher) Publish(ctx context.Context, typ Type, payload interface{}) error {
+	return mp.PublishID(ctx, typ, "", payload)
+}
+
+// PublishID 发布指定ID的事件
+func (mp multiPublisher) PublishID(ctx context.Context, typ Type, sessionID string, payload interface{}) error {
+	var first error
+	for _, p := range mp {
+		if err := p.PublishID(ctx, typ, sessionID, payload); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
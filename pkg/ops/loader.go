@@ -0,0 +1,327 @@
+package ops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/superops-team/hyperops/pkg/mod"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"go.starlark.net/starlark"
+)
+
+const (
+	// rootPrefix 以//开头的模块路径相对于模块根目录
+	rootPrefix = "//"
+	// packagePrefix 以@开头的模块路径从模块包中加载, 例如@netops//bgp.ops
+	packagePrefix = "@"
+	// loadChainName thread local中保存当前加载链的key, 用于检测循环加载
+	loadChainName = "HYPEROPS_LOAD_CHAIN"
+	// loadOwnerName thread local中保存执行加载的goroutine的key, 用于检测线程间的循环等待
+	loadOwnerName = "HYPEROPS_LOAD_OWNER"
+)
+
+// moduleEntry 模块加载结果, 同一次执行中每个文件只执行一次
+type moduleEntry struct {
+	ready   chan struct{}
+	owner   *loadOwner // 执行该模块的goroutine
+	globals starlark.StringDict
+	err     error
+}
+
+// loadOwner 一个goroutine中的加载状态, 嵌套加载的模块线程共用同一个
+type loadOwner struct {
+	waiting *moduleEntry // 正在等待其他线程加载完成的模块, 由fileLoader的锁保护
+}
+
+// fileLoader 从本地文件加载.ops/.star脚本模块, 其余模块交给fallback加载
+type fileLoader struct {
+	sync.Mutex
+	root        string // 模块根目录, //lib/common.ops 相对于该目录
+	base        string // 入口脚本所在目录, 入口脚本中的相对路径相对于该目录
+	fallback    ModuleLoader
+	predeclared starlark.StringDict
+	dialect     Dialect // 模块与入口脚本使用相同的语法选项
+	cache       map[string]*moduleEntry
+	packages    map[string]string // 模块包名 -> 校验过的模块包目录
+}
+
+// newFileLoader 创建本地模块加载器, root为空时使用入口脚本所在的git仓库根目录, 不在仓库中时使用脚本所在目录
+func newFileLoader(target *Target, root string, fallback ModuleLoader, predeclared starlark.StringDict, dialect Dialect) (*fileLoader, error) {
+	base, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if target.ScriptPath != "" {
+		if base, err = filepath.Abs(filepath.Dir(target.ScriptPath)); err != nil {
+			return nil, err
+		}
+	}
+	if root == "" {
+		root = findRoot(base)
+	} else if root, err = filepath.Abs(root); err != nil {
+		return nil, err
+	}
+	return &fileLoader{
+		root:        root,
+		base:        base,
+		fallback:    fallback,
+		predeclared: predeclared,
+		dialect:     dialect,
+		cache:       make(map[string]*moduleEntry),
+		packages:    make(map[string]string),
+	}, nil
+}
+
+// findRoot 向上查找包含.git的目录
+func findRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// isLocalModule 是否明确为本地文件模块
+func isLocalModule(module string) bool {
+	return strings.HasPrefix(module, rootPrefix) ||
+		strings.HasPrefix(module, packagePrefix) ||
+		strings.HasPrefix(module, "./") ||
+		strings.HasPrefix(module, "../") ||
+		filepath.IsAbs(module) ||
+		strings.HasSuffix(module, ".ops")
+}
+
+// resolve 计算模块的绝对路径, 相对路径基于发起load的文件所在目录
+func (l *fileLoader) resolve(thread *starlark.Thread, module string) (string, error) {
+	from := ""
+	if thread.CallStackDepth() > 0 {
+		// 加载的模块使用绝对路径执行, 入口脚本使用相对路径或文件名执行
+		if name := thread.CallFrame(0).Pos.Filename(); filepath.IsAbs(name) {
+			from = name
+		}
+	}
+	return l.resolveFrom(from, module)
+}
+
+// resolveFrom 计算文件from中load的模块的绝对路径, from为空时表示入口脚本
+func (l *fileLoader) resolveFrom(from, module string) (string, error) {
+	if strings.HasPrefix(module, packagePrefix) {
+		i := strings.Index(module, rootPrefix)
+		if i < 0 {
+			return "", fmt.Errorf("invalid module %q, should be @name//path/to/module.ops", module)
+		}
+		dir, err := l.packageDir(module[len(packagePrefix):i])
+		if err != nil {
+			return "", err
+		}
+		path := filepath.Join(dir, module[i+len(rootPrefix):])
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return "", fmt.Errorf("invalid module %q, path is outside of the module package", module)
+		}
+		return path, nil
+	}
+	if !strings.HasPrefix(module, rootPrefix) && filepath.IsAbs(module) {
+		return filepath.Clean(module), nil
+	}
+	if strings.HasPrefix(module, rootPrefix) {
+		// 模块包中的//相对于模块包的根目录
+		return filepath.Join(l.rootOf(from), strings.TrimPrefix(module, rootPrefix)), nil
+	}
+	dir := l.base
+	if from != "" {
+		dir = filepath.Dir(from)
+	}
+	return filepath.Join(dir, module), nil
+}
+
+// rootOf 文件所属的模块根目录
+func (l *fileLoader) rootOf(file string) string {
+	l.Lock()
+	defer l.Unlock()
+	for _, dir := range l.packages {
+		if strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return dir
+		}
+	}
+	return l.root
+}
+
+// packageDir 查找lockfile并校验模块包内容, 每次执行时校验一次
+func (l *fileLoader) packageDir(name string) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+	if dir, ok := l.packages[name]; ok {
+		return dir, nil
+	}
+	lockDir, err := mod.FindLock(l.base, l.root)
+	if err != nil {
+		return "", err
+	}
+	dir, err := mod.Resolve(lockDir, name)
+	if err != nil {
+		return "", err
+	}
+	l.packages[name] = dir
+	return dir, nil
+}
+
+// display 错误信息中使用相对于根目录的路径, 模块包中的文件显示为@name//path
+func (l *fileLoader) display(path string) string {
+	l.Lock()
+	for name, dir := range l.packages {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			l.Unlock()
+			return packagePrefix + name + rootPrefix + filepath.ToSlash(strings.TrimPrefix(path, dir+string(filepath.Separator)))
+		}
+	}
+	l.Unlock()
+	if rel, err := filepath.Rel(l.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// Load 实现starlark.Thread的Load方法
+func (l *fileLoader) Load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	policy := sandbox.FromThread(thread)
+	if !isLocalModule(module) {
+		// 沙箱中只能加载允许的内置模块, 不允许时也不能加载同名的本地文件
+		if err := policy.AllowModule(module); err != nil {
+			return nil, err
+		}
+		dict, err := l.fallback(thread, module)
+		if err == nil {
+			return dict, nil
+		}
+		// 内置模块中不存在时, 再尝试同名的本地文件
+		path, _ := l.resolve(thread, module)
+		if info, statErr := os.Stat(path); statErr != nil || info.IsDir() {
+			return nil, err
+		}
+	}
+	path, err := l.resolve(thread, module)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil && !l.confined(path) {
+		return nil, fmt.Errorf("%w %s: module %s is outside of the module root", sandbox.ErrDenied, policy.Name, module)
+	}
+	return l.load(thread, path)
+}
+
+// confined 模块是否位于模块根目录或模块包中, 按解析符号链接后的路径判断
+func (l *fileLoader) confined(path string) bool {
+	path = realPath(path)
+	l.Lock()
+	dirs := []string{l.root}
+	for _, dir := range l.packages {
+		dirs = append(dirs, dir)
+	}
+	l.Unlock()
+	for _, dir := range dirs {
+		if strings.HasPrefix(path, realPath(dir)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath 解析符号链接后的路径, 文件不存在时解析所在的目录
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		return filepath.Join(dir, filepath.Base(path))
+	}
+	return path
+}
+
+func (l *fileLoader) load(thread *starlark.Thread, path string) (starlark.StringDict, error) {
+	chain, _ := thread.Local(loadChainName).([]string)
+	for i, p := range chain {
+		if p == path {
+			cycle := make([]string, 0, len(chain)-i+1)
+			for _, c := range append(chain[i:], path) {
+				cycle = append(cycle, l.display(c))
+			}
+			return nil, fmt.Errorf("load cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	owner, _ := thread.Local(loadOwnerName).(*loadOwner)
+	if owner == nil {
+		owner = &loadOwner{}
+		thread.SetLocal(loadOwnerName, owner)
+	}
+
+	l.Lock()
+	e, ok := l.cache[path]
+	if ok {
+		select {
+		case <-e.ready:
+			l.Unlock()
+			return e.globals, e.err
+		default:
+		}
+		// 其他线程正在加载时等待加载完成, 该线程直接或间接等待当前线程时会互相等待
+		for o := e.owner; o != nil; {
+			if o == owner {
+				l.Unlock()
+				return nil, fmt.Errorf("load cycle detected: %s is being loaded by another thread waiting for this one", l.display(path))
+			}
+			if o.waiting == nil {
+				break
+			}
+			o = o.waiting.owner
+		}
+		owner.waiting = e
+		l.Unlock()
+		<-e.ready
+		l.Lock()
+		owner.waiting = nil
+		l.Unlock()
+		return e.globals, e.err
+	}
+	e = &moduleEntry{ready: make(chan struct{}), owner: owner}
+	l.cache[path] = e
+	l.Unlock()
+
+	e.globals, e.err = l.exec(thread, path, append(append([]string(nil), chain...), path))
+	close(e.ready)
+	return e.globals, e.err
+}
+
+// exec 在新线程中执行模块, 使用与入口脚本相同的内置函数和输出
+func (l *fileLoader) exec(parent *starlark.Thread, path string, chain []string) (starlark.StringDict, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", l.display(path), err)
+	}
+	thread := &starlark.Thread{Name: parent.Name, Print: parent.Print, Load: l.Load}
+	if ctx := parent.Local("context"); ctx != nil {
+		thread.SetLocal("context", ctx)
+	}
+	localctx.InheritLocals(parent, thread)
+	defer localctx.ReleaseLocals(thread)
+	thread.SetLocal(loadChainName, chain)
+	thread.SetLocal(loadOwnerName, parent.Local(loadOwnerName))
+	globals, err := l.dialect.ExecFile(thread, path, src, l.predeclared)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, fmt.Errorf("load %s: %s", l.display(path), evalErr.Backtrace())
+		}
+		return nil, fmt.Errorf("load %s: %w", l.display(path), err)
+	}
+	return globals, nil
+}
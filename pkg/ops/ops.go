@@ -2,6 +2,7 @@ package ops
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -13,15 +14,21 @@ import (
 	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/event"
 	"github.com/superops-team/hyperops/pkg/ops/starlib"
+	starlibinventory "github.com/superops-team/hyperops/pkg/ops/starlib/inventory"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/sh"
-	"go.starlark.net/resolve"
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
 )
 
 const (
 	defaultContextName = "hyperops_context"
 )
 
+// ErrResumeWithoutID 恢复执行时没有指定job id
+var ErrResumeWithoutID = errors.New("resume requires the job id of the interrupted run")
+
 // ModuleLoader 模块加载声明
 type ModuleLoader func(thread *starlark.Thread, module string) (starlark.StringDict, error)
 
@@ -38,11 +45,12 @@ type Runtime struct {
 	globals      starlark.StringDict
 	ctxConfig    map[string]interface{}
 	ctxSecrects  map[string]string
-	EventsCh     chan event.Event
+	events       event.Publisher
 	output       io.Writer
 	moduleLoader ModuleLoader
 	thread       *starlark.Thread
 	predeclared  starlark.StringDict
+	limits       *localctx.Limits
 }
 
 func (r *Runtime) SetThread(thread *starlark.Thread) {
@@ -56,16 +64,19 @@ func (r *Runtime) GetThread() *starlark.Thread {
 
 // hyperopsPrint 提供异步定制化的输出
 func (r *Runtime) hyperopsPrint(thread *starlark.Thread, msg string) {
-	sm := localctx.NewSecretsManager()
-	safeMsg := sm.SafeReplace(msg)
+	safeMsg := localctx.Redact(thread, msg)
+	// 超出输出限制后丢弃后续输出, 线程会被取消
+	if !r.limits.AddOutput(len(safeMsg) + 1) {
+		return
+	}
 
 	// 提供流式实时数据到外部使用者，保证执行过程会同步打印
-	if r.EventsCh != nil {
+	if r.events != nil {
 		payload := event.PrintEvent{
 			ID:  thread.Name,
 			Msg: safeMsg,
 		}
-		r.EventsCh <- event.MakeEvent(event.ETPrint, thread.Name, payload)
+		_ = localctx.PublishEvent(r.ctx, r.events, event.ETPrint, thread.Name, payload)
 	}
 
 	// 统一将所有的输出记录到output，为外部提供存档记录
@@ -75,6 +86,24 @@ func (r *Runtime) hyperopsPrint(thread *starlark.Thread, msg string) {
 	}
 }
 
+// newPublisher 根据执行配置组合事件发布器，EventsCh作为适配器与EventBus同时生效
+func newPublisher(o *ExecOpts) event.Publisher {
+	var pubs []event.Publisher
+	if o.EventBus != nil {
+		pubs = append(pubs, o.EventBus)
+	}
+	if o.EventsCh != nil {
+		pubs = append(pubs, event.NewChanPublisher(o.EventsCh))
+	}
+	switch len(pubs) {
+	case 0:
+		return nil
+	case 1:
+		return pubs[0]
+	}
+	return event.MultiPublisher(pubs...)
+}
+
 // ExecScript 执行脚本
 func ExecScript(ctx context.Context, target *Target, opts ...func(o *ExecOpts)) (err error) {
 	// Recover from errors.
@@ -102,43 +131,116 @@ func ExecScript(ctx context.Context, target *Target, opts ...func(o *ExecOpts))
 		opt(o)
 	}
 
-	resolve.AllowFloat = o.AllowFloat
-	resolve.AllowSet = o.AllowSet
-	resolve.AllowLambda = o.AllowLambda
-	resolve.AllowNestedDef = o.AllowNestedDef
-	resolve.AllowGlobalReassign = o.AllowGlobalReassign
+	// 语法选项只作用于本次执行, 不修改resolve包的全局开关
+	dialect := dialectOf(o)
+
+	// 如果传递了jobID那么上下文可以绑定到jobid上
+	ctxName := defaultContextName
+	jobID, ok := o.Locals["job_id"]
+	if ok {
+		ctxName, _ = jobID.(string)
+	}
+	// 未指定job_id的执行共用默认名称, 不能保存和恢复checkpoint
+	var checkpointID string
+	if ok {
+		checkpointID = ctxName
+	}
+
+	// 执行前校验脚本声明的参数
+	params, err := applyParams(target, o)
+	if err != nil {
+		return err
+	}
+
+	// 每个实例绑定运行时上下文，用于记录该实例的各种状态
+	hctx := localctx.NewContext(o.Locals, o.Secrets)
+	hctx.SetSecretProviders(o.SecretProviders)
+	checkpoint, err := newCheckpoint(checkpointID, o)
+	if err != nil {
+		return err
+	}
+	if err := hctx.SetCheckpoint(checkpoint); err != nil {
+		return err
+	}
 
 	// 增加错误处理内置函数
 	r := &Runtime{
 		ctx:          ctx,
-		EventsCh:     o.EventsCh,
+		events:       newPublisher(o),
 		ctxConfig:    o.Locals,
 		ctxSecrects:  o.Secrets,
 		target:       target,
 		output:       o.OutputWriter,
 		moduleLoader: o.ModuleLoader,
-		predeclared: starlark.StringDict{
-			"sh":    localctx.AddBuiltin("sh", sh.Exec),                // 将sh提升为一级内置函数，无需导入
-			"sleep": localctx.AddBuiltin("sleep", SleepFn),             // 将sleep函数提升为内置，无需导入
-			"ctx":   localctx.NewContext(o.Locals, o.Secrets).Struct(), // 每个实例绑定运行时上下文，用于记录该实例的各种状态
-		},
+		predeclared:  newPredeclared(hctx, params),
+	}
+	moduleLoader := o.ModuleLoader
+	if o.TestReporter != nil {
+		moduleLoader = testModuleLoader(moduleLoader)
 	}
+	// 支持load本地的.ops脚本模块, 模块与入口脚本使用相同的内置函数
+	loader, err := newFileLoader(target, o.ModuleRoot, moduleLoader, r.predeclared, dialect)
+	if err != nil {
+		return err
+	}
+	r.moduleLoader = loader.Load
 	// 收敛所有的print的逻辑，避免使用的时候混淆, 尽最大可能保证和python内置的一致性体验
 	// 后续开发包也一样会遵守该原则
 	thread := &starlark.Thread{Load: r.moduleLoader, Print: r.hyperopsPrint} // replace SafePrint to hyperopsPrint for only one place to print is more easy to use for two
 
-	// 如果传递了jobID那么上下文可以绑定到jobid上
-	ctxName := defaultContextName
-	jobID, ok := r.ctxConfig["job_id"]
-	if ok {
-		ctxName, _ = jobID.(string)
-	}
 	thread.Name = ctxName
+	hctx.Bind(thread)
+	if o.Inventory != nil {
+		starlibinventory.Set(thread, o.Inventory)
+	}
+	if o.Sandbox != nil {
+		sandbox.Bind(thread, o.Sandbox)
+	}
+	if o.Fixtures != nil {
+		o.Fixtures.Bind(thread)
+	}
+	if o.TestReporter != nil {
+		starlarktest.SetReporter(thread, &redactReporter{thread: thread, reporter: o.TestReporter})
+		// 测试中通过mock.star替换内置函数的返回值
+		localctx.NewMocks().Bind(thread)
+	}
+	limits := &localctx.Limits{
+		MaxSteps:       o.MaxSteps,
+		MaxOutputBytes: o.MaxOutputBytes,
+		MaxShellStdout: o.MaxShellStdout,
+		MaxHeapBytes:   o.MaxHeapBytes,
+	}
+	if limits.Enabled() {
+		limits.Bind(thread, target.ScriptPath)
+		r.limits = limits
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		limits.WatchHeap(watchCtx)
+	}
 	r.SetThread(thread)
+	if o.DryRun {
+		if err := localctx.SetDryRun(thread, o.DryRunStubs); err != nil {
+			return err
+		}
+	}
+
+	// job的context在kill和超时时取消, fleet/group和加载模块的子线程随之取消
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+	thread.SetLocal("context", jobCtx)
 
 	// for outside manager all tasks
 	tm := localctx.NewTaskManager()
-	tm.Add(ctxName, thread, r.EventsCh)
+	// 同名的task正在运行时不注册, 也不能删除或者kill其他执行的task
+	registered := tm.AddWithPublisher(ctxName, thread, r.events)
+	if registered {
+		tm.SetCancel(ctxName, cancelJob)
+		tm.SetContext(ctxName, ctx)
+	}
+	// 调试器在task注册后接入, 暂停时可以将task置为hanging
+	if o.Debugger != nil {
+		o.Debugger.Start(thread, target.ScriptPath, r.predeclared)
+	}
 
 	// add timeout when exec time exceeded
 	go func() {
@@ -148,8 +250,13 @@ func ExecScript(ctx context.Context, target *Target, opts ...func(o *ExecOpts))
 				if thread != nil {
 					thread.Cancel(fmt.Sprintf("exec %s timeout %s", thread.Name, o.Timeout))
 				}
+				cancelJob()
 				return
-			case <-ctx.Done():
+			case <-jobCtx.Done():
+				// 调用方取消ctx时结束执行, 例如任务尚未注册到TaskManager时被kill
+				if ctx.Err() != nil {
+					thread.Cancel(fmt.Sprintf("cancel %s: %s", thread.Name, ctx.Err()))
+				}
 				return
 			}
 		}
@@ -158,16 +265,77 @@ func ExecScript(ctx context.Context, target *Target, opts ...func(o *ExecOpts))
 	if len(target.ScriptContent) > 0 {
 		evalName := filepath.Base(target.ScriptPath)
 		thread.SetLocal(evalName, evalName)
-		r.globals, err = starlark.ExecFile(thread, evalName, target.ScriptContent, r.predeclared)
+		r.globals, err = dialect.ExecFile(thread, evalName, target.ScriptContent, r.predeclared)
 	} else {
-		r.globals, err = starlark.ExecFile(thread, target.ScriptPath, nil, r.predeclared)
+		r.globals, err = dialect.ExecFile(thread, target.ScriptPath, nil, r.predeclared)
 	}
-	tm.Delete(ctxName, r.predeclared)
-	if err != nil {
-		if evalErr, ok := err.(*starlark.EvalError); ok {
-			return fmt.Errorf(evalErr.Backtrace())
+	if err == nil && o.Entrypoint != "" {
+		err = callEntrypoint(thread, r.globals, o.Entrypoint)
+	}
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		err = fmt.Errorf(evalErr.Backtrace())
+	}
+	// 超出资源限制时返回LimitError, 即使脚本已经执行结束
+	err = r.limits.Fired(err)
+	// 执行成功后清理checkpoint, 失败时保留用于恢复执行
+	if err == nil && !o.DryRun {
+		if rmErr := checkpoint.Remove(); rmErr != nil {
+			err = rmErr
 		}
-		return err
 	}
+	// 错误中的调用栈可能包含密码, 在释放job的密码前替换
+	err = localctx.RedactError(thread, err)
+	if registered {
+		tm.Delete(ctxName, r.predeclared)
+	}
+	// 没有注册task时Delete不会释放本次执行的密码
+	localctx.NewSecretsManager().Release(localctx.SecretScope(thread))
 	return err
 }
+
+// callEntrypoint 调用脚本中定义的无参函数
+func callEntrypoint(thread *starlark.Thread, globals starlark.StringDict, name string) error {
+	fn, ok := globals[name].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("entrypoint %s is not defined or not callable", name)
+	}
+	_, err := starlark.Call(thread, fn, nil, nil)
+	return err
+}
+
+// newPredeclared 入口脚本和本地模块共用的内置函数, 静态检查使用相同的名称
+func newPredeclared(hctx *localctx.Context, params starlark.Value) starlark.StringDict {
+	return starlark.StringDict{
+		"sh":     localctx.AddSideEffectBuiltin("sh", sh.Exec, sh.DryRunStub), // 将sh提升为一级内置函数，无需导入
+		"sleep":  localctx.AddBuiltin("sleep", SleepFn),                       // 将sleep函数提升为内置，无需导入
+		"step":   localctx.AddBuiltin("step", hctx.Step),                      // 具名步骤，完成后记录checkpoint用于中断后恢复
+		"ctx":    hctx.Struct(),
+		"param":  starlark.NewBuiltin("param", paramFn), // 声明参数
+		"params": paramsBuiltin(params),                 // 返回执行前解析好的参数值
+	}
+}
+
+// newCheckpoint 创建job工作目录下的checkpoint, 恢复执行时加载已完成的步骤，否则清理上一次遗留的记录,
+// 工作目录在第一个步骤完成时才创建, 没有job id时checkpoint只保存在内存中
+func newCheckpoint(jobID string, o *ExecOpts) (*localctx.Checkpoint, error) {
+	if jobID == "" {
+		if o.Resume {
+			return nil, ErrResumeWithoutID
+		}
+		return localctx.NewCheckpoint(""), nil
+	}
+	dir, err := util.Workdir(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if o.Resume {
+		return localctx.LoadCheckpoint(dir)
+	}
+	checkpoint := localctx.NewCheckpoint(dir)
+	if !o.DryRun {
+		if err := checkpoint.Remove(); err != nil {
+			return nil, err
+		}
+	}
+	return checkpoint, nil
+}
@@ -3,16 +3,24 @@ package ops
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/superops-team/hyperops/pkg/mod"
 	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/event"
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"github.com/superops-team/hyperops/pkg/secret"
 	"go.starlark.net/starlark"
 )
 
@@ -85,6 +93,756 @@ func TestExecScriptWithEvent(t *testing.T) {
 	}
 }
 
+func TestExecScriptWithEventBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := event.NewBus(ctx)
+	var logger, archiver []event.Event
+	bus.SubscribeAll(func(_ context.Context, e event.Event) error {
+		logger = append(logger, e)
+		return nil
+	})
+	bus.SubscribeID(func(_ context.Context, e event.Event) error {
+		archiver = append(archiver, e)
+		return nil
+	}, "bus_job")
+
+	eventCh := make(chan event.Event, 100)
+	err := ExecScript(
+		ctx,
+		&Target{
+			ScriptContent: []byte(`
+print("hello bus")
+ctx.set("k", "v")
+`),
+		},
+		SetEventBus(bus),
+		AddEventsChannel(eventCh),
+		SetLocals(map[string]interface{}{
+			"job_id":                  "bus_job",
+			"HYPEROPS_WORKSPACE_KEEP": false,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(eventCh)
+
+	types := func(events []event.Event) []event.Type {
+		ret := []event.Type{}
+		for _, e := range events {
+			if e.SessionID != "bus_job" {
+				t.Errorf("unexpected session id %s", e.SessionID)
+			}
+			ret = append(ret, e.Type)
+		}
+		return ret
+	}
+	expect := []event.Type{event.ETTask, event.ETPrint, event.ETTask, event.ETData}
+	var fromCh []event.Event
+	for e := range eventCh {
+		fromCh = append(fromCh, e)
+	}
+	for name, events := range map[string][]event.Event{"logger": logger, "archiver": archiver, "channel": fromCh} {
+		if got := types(events); fmt.Sprint(got) != fmt.Sprint(expect) {
+			t.Errorf("%s events mismatch. expected: %v, got: %v", name, expect, got)
+		}
+	}
+}
+
+func TestExecScriptGroupOplog(t *testing.T) {
+	eventCh := make(chan event.Event, 100)
+	err := ExecScript(context.Background(), &Target{ScriptContent: []byte(`
+load("group.star", "group")
+g = group.make()
+g.go(sleep, "1ms")
+g.go(sleep, "2ms")
+g.wait()
+`)},
+		AddEventsChannel(eventCh),
+		SetOutputWriter(&bytes.Buffer{}),
+		SetLocals(map[string]interface{}{"job_id": "group_oplog_job"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(eventCh)
+	// group.wait的子线程名称与job不同, 调用的内置函数仍然记录到job的事件流
+	var names []string
+	for e := range eventCh {
+		if oplog, ok := e.Payload.(event.OplogEvent); ok {
+			if oplog.ID != "group_oplog_job" {
+				t.Errorf("unexpected oplog id %s", oplog.ID)
+			}
+			names = append(names, oplog.Name)
+		}
+	}
+	if fmt.Sprint(names) != "[sleep sleep]" {
+		t.Errorf("expected oplog for every sleep in the group, got %v", names)
+	}
+}
+
+func TestExecScriptDryRun(t *testing.T) {
+	dir := t.TempDir()
+	output := &bytes.Buffer{}
+	err := ExecScript(
+		context.Background(),
+		&Target{
+			ScriptContent: []byte(fmt.Sprintf(`
+load("fs.star", "fs")
+load("shell.star", "shell")
+ret = sh("touch %[1]s/sh.txt")
+print("sh code", ret.code)
+print("fs.create", fs.create("%[1]s/fs.txt", "hello"))
+ret = shell.exec("cat /etc/hostname")
+print("shell stdout", ret.stdout)
+`, dir)),
+		},
+		SetOutputWriter(output),
+		SetLocals(map[string]interface{}{
+			"HYPEROPS_WORKSPACE_KEEP": false,
+		}),
+		SetDryRun(map[string]interface{}{
+			"shell.exec": map[string]interface{}{"code": 0, "stdout": "stub-host", "stderr": ""},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"[dry-run] would run sh with args=(\"touch " + dir + "/sh.txt\",)",
+		"sh code 0",
+		"fs.create True",
+		"shell stdout stub-host",
+	} {
+		if !strings.Contains(output.String(), expect) {
+			t.Errorf("output mismatch. expected: '%s', got: '%s'", expect, output.String())
+		}
+	}
+	for _, name := range []string{"sh.txt", "fs.txt"} {
+		if localctx.PathExists(filepath.Join(dir, name)) {
+			t.Errorf("%s should not be created in dry-run mode", name)
+		}
+	}
+}
+
+func TestExecScriptResume(t *testing.T) {
+	script := []byte(`
+def first():
+    print("run first")
+    ctx.set("count", 1)
+    return 10
+
+def second():
+    print("run second")
+    return ctx.get("count") + 1
+
+def third():
+    print("run third")
+    if ctx.get_config("fail"):
+        fail("boom")
+    return ctx.get("count") + 2
+
+a = step("first", first)
+b = step("second", second)
+c = step("third", third)
+print("result", a, b, c)
+`)
+	run := func(fail bool, opts ...func(*ExecOpts)) (string, error) {
+		output := &bytes.Buffer{}
+		opts = append(opts,
+			SetOutputWriter(output),
+			SetLocals(map[string]interface{}{
+				"job_id":                  "resume_job",
+				"fail":                    fail,
+				"HYPEROPS_WORKSPACE_KEEP": false,
+			}),
+		)
+		err := ExecScript(context.Background(), &Target{ScriptContent: script}, opts...)
+		return output.String(), err
+	}
+
+	workdir := util.EnsureWorkdir("resume_job")
+	defer os.RemoveAll(workdir)
+
+	out, err := run(true)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected step third to fail, got %v", err)
+	}
+	if !strings.Contains(out, "run second") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	if !localctx.PathExists(filepath.Join(workdir, localctx.CheckpointFile)) {
+		t.Fatal("checkpoint should be kept after failure")
+	}
+
+	out, err = run(false, SetResume())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"[resume] step first already completed",
+		"[resume] step second already completed",
+		"run third",
+		"result 10 2 3",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("output mismatch. expected: '%s', got: '%s'", expect, out)
+		}
+	}
+	if strings.Contains(out, "run first") || strings.Contains(out, "run second") {
+		t.Errorf("completed steps should be skipped, got: '%s'", out)
+	}
+	if localctx.PathExists(workdir) {
+		t.Error("workspace should be removed after the job succeeded")
+	}
+
+	// 没有job id时无法找到上一次的checkpoint
+	err = ExecScript(context.Background(), &Target{ScriptContent: script}, SetResume(), SetOutputWriter(&bytes.Buffer{}))
+	if !errors.Is(err, ErrResumeWithoutID) {
+		t.Errorf("expected ErrResumeWithoutID, got %v", err)
+	}
+}
+
+func TestExecScriptLoad(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".git/HEAD": "ref: refs/heads/master\n",
+		"lib/util.ops": `
+print("load util")
+def node_name(node):
+    return "%s-%s" % (ctx.get_config("cluster"), node)
+`,
+		"lib/common.ops": `
+load("./util.ops", "node_name")
+def drain_node(node):
+    return "drain " + node_name(node)
+`,
+		"jobs/drain.ops": `
+load("../lib/common.ops", "drain_node")
+load("//lib/util.ops", "node_name")
+load("encoding/json.star", "json")
+print(drain_node("n1"), node_name("n2"))
+`,
+		"cycle/a.ops": `load("b.ops", "b")
+a = 1
+`,
+		"cycle/b.ops": `load("//cycle/a.ops", "a")
+b = 1
+`,
+		"cycle/main.ops": `load("a.ops", "a")
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(script string) (string, error) {
+		target, err := NewTarget(filepath.Join(root, script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := &bytes.Buffer{}
+		err = ExecScript(context.Background(), target,
+			SetOutputWriter(output),
+			SetLocals(map[string]interface{}{
+				"job_id":  "load_job",
+				"cluster": "c1",
+			}),
+		)
+		return output.String(), err
+	}
+
+	out, err := run("jobs/drain.ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "drain c1-n1 c1-n2") {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if n := strings.Count(out, "load util"); n != 1 {
+		t.Errorf("module should be executed once per run, got %d times: %s", n, out)
+	}
+
+	_, err = run("cycle/main.ops")
+	if err == nil || !strings.Contains(err.Error(), "load cycle detected: cycle/a.ops -> cycle/b.ops -> cycle/a.ops") {
+		t.Errorf("expected load cycle error, got %v", err)
+	}
+}
+
+func TestFileLoaderConcurrentCycle(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.ops": "wait()\nload(\"b.ops\", \"b\")\na = 1\n",
+		"b.ops": "wait()\nload(\"a.ops\", \"a\")\nb = 1\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 两个线程分别开始加载a.ops和b.ops后再加载对方的模块
+	var started sync.WaitGroup
+	started.Add(2)
+	predeclared := starlark.StringDict{
+		"wait": starlark.NewBuiltin("wait", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			started.Done()
+			started.Wait()
+			return starlark.None, nil
+		}),
+	}
+	l, err := newFileLoader(&Target{ScriptPath: filepath.Join(root, "main.ops")}, root, nil, predeclared, Dialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	for _, module := range []string{"a.ops", "b.ops"} {
+		go func(module string) {
+			_, err := l.Load(&starlark.Thread{}, module)
+			errs <- err
+		}(module)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err == nil || !strings.Contains(err.Error(), "load cycle detected") {
+				t.Errorf("expected load cycle error, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("loading modules that load each other from two threads should not block")
+		}
+	}
+}
+
+func TestExecScriptLoadPackage(t *testing.T) {
+	os.Setenv(mod.CacheEnv, t.TempDir())
+	defer os.Unsetenv(mod.CacheEnv)
+	src, dir := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		filepath.Join(src, "bgp.ops"): `
+load("//lib/util.ops", "prefix")
+def peer(name):
+    return prefix + name
+`,
+		filepath.Join(src, "lib/util.ops"): `prefix = "bgp-"`,
+		filepath.Join(dir, "main.ops"): `
+load("@netops//bgp.ops", "peer")
+print(peer("r1"))
+`,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func() (string, error) {
+		target, err := NewTarget(filepath.Join(dir, "main.ops"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := &bytes.Buffer{}
+		err = ExecScript(context.Background(), target, SetOutputWriter(output), SetModuleRoot(dir))
+		return output.String(), err
+	}
+
+	if _, err := run(); err == nil || !strings.Contains(err.Error(), mod.LockFile) {
+		t.Fatalf("expected no lockfile error, got %v", err)
+	}
+	if _, err := mod.Add(dir, "netops", mod.Require{Source: "file://" + src}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "bgp-r1") {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	pkg, err := mod.Resolve(dir, "netops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkg, "bgp.ops"), []byte(`def peer(name): return name`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(); err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+		t.Errorf("expected integrity error, got %v", err)
+	}
+}
+
+func TestExecScriptSandbox(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "work"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := sandbox.Parse([]byte(`
+name: team-a
+modules: ["fs.star", "shell.star", "ssh.star"]
+shell: {mode: restricted, basedir: work}
+fs: {roots: [work]}
+`), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(script string, policy *sandbox.Policy) (string, error) {
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(script)},
+			SetOutputWriter(output),
+			SetModuleRoot(dir),
+			SetSandbox(policy),
+		)
+		return output.String(), err
+	}
+
+	out, err := run(fmt.Sprintf(`
+load("fs.star", "fs")
+fs.create("%s/report.txt", "ok")
+print(sh("cat report.txt").stdout)
+`, filepath.Join(dir, "work")), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "ok") {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	for script, expect := range map[string]string{
+		`load("http.star", "http")`:                                     "denied by sandbox team-a: module http.star is not allowed",
+		`load("/etc/hosts.ops", "x")`:                                   "module /etc/hosts.ops is outside of the module root",
+		`load("fs.star", "fs")` + "\n" + `fs.readall("/etc/hosts")`:     "path /etc/hosts is outside of the fs roots",
+		`sh("cat /etc/hosts")`:                                          "illegal prefix",
+		`sh("ls", "../")`:                                               "relativePath contains illegal string",
+		`sh("ls", host="10.0.0.1")`:                                     "remote shell is not allowed in restricted mode",
+		`load("ssh.star", "ssh")` + "\n" + `ssh.exec("10.0.0.1", "id")`: "remote shell is not allowed in restricted mode",
+	} {
+		if _, err := run(script, policy); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected error %q, got %v", script, expect, err)
+		}
+	}
+
+	// 不允许的内置模块不能由同名的本地文件代替, 指向根目录外的符号链接也不能加载
+	outside := filepath.Join(t.TempDir(), "outside.ops")
+	if err := ioutil.WriteFile(outside, []byte("x = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.ops")); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"http.star":   "http = 1\n",
+		"builtin.ops": `load("http.star", "http")` + "\n",
+		"symlink.ops": `load("link.ops", "x")` + "\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for script, expect := range map[string]string{
+		"builtin.ops": "denied by sandbox team-a: module http.star is not allowed",
+		"symlink.ops": "denied by sandbox team-a: module link.ops is outside of the module root",
+	} {
+		target, err := NewTarget(filepath.Join(dir, script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ExecScript(context.Background(), target, SetOutputWriter(ioutil.Discard), SetModuleRoot(dir), SetSandbox(policy))
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected error %q, got %v", script, expect, err)
+		}
+	}
+
+	policy.Shell.Mode = sandbox.ShellDeny
+	if _, err := run(`load("shell.star", "shell")`+"\n"+`shell.exec("id")`, policy); err == nil || !strings.Contains(err.Error(), "shell is not allowed") {
+		t.Errorf("expected shell denied, got %v", err)
+	}
+	if _, err := run(`load("ssh.star", "ssh")`+"\n"+`ssh.exec("10.0.0.1", "id")`, policy); err == nil || !strings.Contains(err.Error(), "shell is not allowed") {
+		t.Errorf("expected ssh denied, got %v", err)
+	}
+}
+
+func TestExecScriptLimits(t *testing.T) {
+	run := func(script string, steps, maxOutput, shellStdout, heap uint64) (string, error) {
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(script)},
+			SetOutputWriter(output),
+			SetLimits(steps, maxOutput, shellStdout, heap),
+		)
+		return output.String(), err
+	}
+
+	cases := []struct {
+		script                           string
+		steps, output, shellStdout, heap uint64
+		limit, expect                    string
+	}{
+		{"for i in range(1000000):\n    pass", 1000, 0, 0, 0, localctx.LimitSteps, "steps limit exceeded (max 1000)"},
+		{"for i in range(1000):\n    print(i)", 0, 100, 0, 0, localctx.LimitOutput, "output limit exceeded (max 100 bytes)"},
+		{"for i in range(100):\n    sleep('50ms')", 0, 0, 0, 1, localctx.LimitHeap, "heap limit exceeded (max 1 bytes)"},
+		{`load("group.star", "group")
+def loop(n):
+    for i in range(n):
+        pass
+g = group.make()
+g.go(loop, 1000000)
+g.wait()`, 1000, 0, 0, 0, localctx.LimitSteps, "steps limit exceeded"},
+	}
+	for _, c := range cases {
+		_, err := run(c.script, c.steps, c.output, c.shellStdout, c.heap)
+		if err == nil || !strings.Contains(err.Error(), c.expect) || localctx.ExceededLimit(err) != c.limit {
+			t.Errorf("%s: expected %s limit error %q, got %v", c.script, c.limit, c.expect, err)
+		}
+	}
+
+	out, err := run(`ret = sh("seq 1 10000")
+print(len(ret.stdout), ret.truncated)`, 0, 0, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "10 True") {
+		t.Errorf("expected truncated stdout, got %s", out)
+	}
+	if _, err := run("print(1)", 100, 100, 0, 0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestExecScriptCancelFleet(t *testing.T) {
+	script := []byte(`
+load("fleet.star", "fleet")
+
+def deploy(host):
+    sleep("100ms")
+    print("deployed", host)
+
+fleet.run(["web%d" % i for i in range(50)], deploy, parallel=2)
+print("finished")
+`)
+	for name, cancel := range map[string]func(id string) func(*ExecOpts){
+		"kill": func(id string) func(*ExecOpts) {
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+				localctx.GetTaskManager().Kill(id)
+			}()
+			return SetTimeout(time.Minute)
+		},
+		"timeout": func(id string) func(*ExecOpts) {
+			return SetTimeout(300 * time.Millisecond)
+		},
+	} {
+		id := "fleet_" + name + "_job"
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: script},
+			SetOutputWriter(output),
+			SetLocals(map[string]interface{}{"job_id": id}),
+			cancel(id),
+		)
+		if err == nil {
+			t.Errorf("%s: expected the job to be cancelled", name)
+		}
+		// 取消后不再向剩余的主机发起执行
+		deployed := strings.Count(output.String(), "deployed")
+		if deployed == 0 || deployed > 10 || strings.Contains(output.String(), "finished") {
+			t.Errorf("%s: expected the rollout to stop, %d hosts deployed: %s", name, deployed, output.String())
+		}
+	}
+}
+
+func TestExecScriptRedaction(t *testing.T) {
+	eventCh := make(chan event.Event, 100)
+	output := &bytes.Buffer{}
+	err := ExecScript(context.Background(), &Target{ScriptContent: []byte(`
+load("encoding/base64.star", "base64")
+secret = ctx.get_secret("pass")
+r = sh("echo " + secret)
+ctx.set("stdout", r.stdout)
+ctx.set("nested", {"token": [secret]})
+print(base64.encode(secret))
+fail("login failed: " + secret)
+`)},
+		SetOutputWriter(output),
+		AddEventsChannel(eventCh),
+		SetSecrets(map[string]string{"pass": "redact-me-123"}),
+		SetLocals(map[string]interface{}{"job_id": "redact_job"}),
+	)
+	close(eventCh)
+	if err == nil || strings.Contains(err.Error(), "redact-me-123") || !strings.Contains(err.Error(), "login failed: *************") {
+		t.Errorf("expected redacted error, got %v", err)
+	}
+	if strings.Contains(output.String(), "cmVkYWN0LW1lLTEyMw") {
+		t.Errorf("expected base64 secret redacted, got %s", output.String())
+	}
+	var data string
+	for e := range eventCh {
+		if payload, ok := e.Payload.(event.DataEvent); ok {
+			data = fmt.Sprint(payload.Data)
+		}
+	}
+	if !strings.Contains(data, "*************") || strings.Contains(data, "redact-me-123") {
+		t.Errorf("expected data event redacted, got %s", data)
+	}
+}
+
+func TestExecScriptConcurrentSecrets(t *testing.T) {
+	run := func(script, pass string) (string, error) {
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(script)},
+			SetOutputWriter(output),
+			SetSecrets(map[string]string{"pass": pass}),
+		)
+		return output.String(), err
+	}
+	// 没有job_id的执行使用相同的线程名, 先结束的执行不能释放其他执行的密码
+	var (
+		wg  sync.WaitGroup
+		out string
+		err error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		out, err = run(`sleep("300ms")`+"\n"+`print(ctx.get_secret("pass"))`, "second-secret-2")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := run(`print(ctx.get_secret("pass"))`, "first-secret-1"); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "second-secret-2") || !strings.Contains(out, "***************") {
+		t.Errorf("expected secret redacted after the other run finished, got %s", out)
+	}
+}
+
+// countingProvider 记录读取次数的密码后端
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Get(path, key string) (string, error) {
+	p.calls++
+	return "resolved-" + path + "-" + key, nil
+}
+
+func TestExecScriptSecretProviders(t *testing.T) {
+	provider := &countingProvider{}
+	run := func(script string) (string, error) {
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(script)},
+			SetOutputWriter(output),
+			SetSecretProviders(secret.Providers{"test": provider}),
+			SetLocals(map[string]interface{}{
+				"db_password": "secret://test/db#password",
+				"api_token":   "secret://test/api#token",
+			}),
+		)
+		return output.String(), err
+	}
+	out, err := run(`
+print(ctx.get_config("db_password"))
+print(ctx.get_secret("db_password"))
+print(ctx.get_secret("db_password") == "resolved-db-password")
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只解析读取过的密码, 同一个job中只解析一次, 输出中被替换
+	if provider.calls != 1 {
+		t.Errorf("expected resolved lazily once, got %d calls", provider.calls)
+	}
+	if out != "secret://test/db#password\n********************\nTrue\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if err := ExecScript(context.Background(), &Target{ScriptContent: []byte(`ctx.get_secret("db_password")`)},
+		SetLocals(map[string]interface{}{"db_password": "secret://test/db#password"}),
+	); err == nil || !strings.Contains(err.Error(), "no secret providers configured") {
+		t.Errorf("expected no providers error, got %v", err)
+	}
+
+	out, err = run(`
+p = params(api_token = param("string", secret=True))
+print(p.api_token == "resolved-api-token")
+`)
+	if err != nil || out != "True\n" {
+		t.Errorf("unexpected secret param %q %v", out, err)
+	}
+}
+
+func TestExecScriptDialect(t *testing.T) {
+	script := []byte(`
+x = 1
+x = 2
+print(set([x]))
+`)
+	strict := func(o *ExecOpts) {
+		o.AllowSet = false
+		o.AllowGlobalReassign = false
+	}
+	// 并发执行语法选项冲突的脚本, 使用-race运行时可以发现对全局开关的竞争
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	outputs := make([]*bytes.Buffer, len(errs))
+	for i := range errs {
+		outputs[i] = &bytes.Buffer{}
+		opts := []func(o *ExecOpts){SetOutputWriter(outputs[i])}
+		if i%2 == 1 {
+			opts = append(opts, strict)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = ExecScript(context.Background(), &Target{ScriptContent: script}, opts...)
+		}(i)
+	}
+	// 不经过Dialect的解析(例如starlarktest加载assert.star)始终使用默认选项
+	legacy := make([]error, 10)
+	for i := range legacy {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, legacy[i] = starlark.ExecFile(&starlark.Thread{}, "legacy.star", "x = 1\nx = 2\n", nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range legacy {
+		if err == nil || !strings.Contains(err.Error(), "cannot reassign global x") {
+			t.Errorf("legacy run %d: expected reassign error, got %v", i, err)
+		}
+	}
+	for i, err := range errs {
+		if i%2 == 0 {
+			if err != nil || !strings.Contains(outputs[i].String(), "set([2])") {
+				t.Errorf("run %d: unexpected result %v %s", i, err, outputs[i].String())
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "cannot reassign global x") {
+			t.Errorf("run %d: expected reassign error, got %v", i, err)
+		}
+	}
+
+	// 默认选项与原来的resolve全局开关一致, 不支持while循环和递归
+	for script, expect := range map[string]string{
+		"x = 0\nwhile x < 3:\n    x += 1\n": "does not support while loops",
+		"def f(n):\n    return f(n - 1) if n else 0\nf(3)\n": "called recursively",
+	} {
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(script)}, SetOutputWriter(&bytes.Buffer{}))
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%q: expected error %q, got %v", script, expect, err)
+		}
+	}
+}
+
 func TestExecScript(t *testing.T) {
 	ctx := context.Background()
 	output := &bytes.Buffer{}
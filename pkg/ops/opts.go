@@ -5,18 +5,24 @@ import (
 	"io/ioutil"
 	"time"
 
+	"github.com/superops-team/hyperops/pkg/inventory"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/debugger"
 	"github.com/superops-team/hyperops/pkg/ops/event"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"github.com/superops-team/hyperops/pkg/secret"
+	"go.starlark.net/starlarktest"
 )
 
 // ExecOpts 设置运行时相关开关
 type ExecOpts struct {
-	// 是否启用浮点数
+	// 是否启用浮点数, 当前starlark版本中始终启用, 仅为兼容保留
 	AllowFloat bool
 	// 是否启用集合类型
 	AllowSet bool
-	// 是否启用lamda表达式
+	// 是否启用lamda表达式, 当前starlark版本中始终启用, 仅为兼容保留
 	AllowLambda bool
-	// 是否启用闭包
+	// 是否启用闭包, 当前starlark版本中始终启用, 仅为兼容保留
 	AllowNestedDef bool
 	// allow reassignment to top-level names; also, allow if/for/while at top-level
 	AllowGlobalReassign bool
@@ -28,10 +34,44 @@ type ExecOpts struct {
 	OutputWriter io.Writer
 	// 模块加载方法
 	ModuleLoader ModuleLoader
+	// 本地模块根目录, load("//lib/common.ops")相对于该目录, 默认为脚本所在的git仓库根目录
+	ModuleRoot string
 	// 事件转发订阅
 	EventsCh chan event.Event
+	// 事件总线, 所有事件以job id作为SessionID发布
+	EventBus event.Bus
 	// 超时
 	Timeout time.Duration
+	// 每个线程最大的starlark执行步数, 0表示不限制
+	MaxSteps uint64
+	// print输出的最大字节数, 0表示不限制
+	MaxOutputBytes uint64
+	// 单次shell调用保存的最大标准输出字节数, 超出部分截断, 0表示不限制
+	MaxShellStdout uint64
+	// 堆内存软上限, 超出时结束执行, 0表示不限制
+	MaxHeapBytes uint64
+	// dry-run模式，有副作用的内置函数只记录调用不执行
+	DryRun bool
+	// dry-run模式下按函数名自定义的返回值
+	DryRunStubs map[string]interface{}
+	// 根据job工作目录下的checkpoint恢复执行, 跳过已完成的step
+	Resume bool
+	// 命令行传入的脚本参数, 按params()的声明校验和转换类型
+	Params map[string]string
+	// 主机清单, 通过inventory和fleet模块使用
+	Inventory *inventory.Inventory
+	// 沙箱策略, 限制脚本可以加载的模块和shell/fs/http等能力, 为nil时不限制
+	Sandbox *sandbox.Policy
+	// 密码后端, 用于解析secrets和ctx配置中的secret://引用
+	SecretProviders secret.Providers
+	// 脚本执行完成后调用的无参函数, 为空时只执行脚本
+	Entrypoint string
+	// 测试模式下接收assert.star的失败信息, 不为nil时可以load("assert.star")
+	TestReporter starlarktest.Reporter
+	// 录制shell/http/fs调用的结果, 或者回放录制的结果代替真实调用
+	Fixtures *localctx.Fixtures
+	// 调试器, 不为nil时在断点和单步处暂停
+	Debugger *debugger.Debugger
 }
 
 // DefaultExecOpts 默认执行配置
@@ -52,6 +92,13 @@ func AddEventsChannel(eventsCh chan event.Event) func(o *ExecOpts) {
 	}
 }
 
+// SetEventBus 设置事件总线，可以同时挂载多个互不影响的订阅者
+func SetEventBus(bus event.Bus) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.EventBus = bus
+	}
+}
+
 // SetOutputWriter 设置输出重定向
 func SetOutputWriter(w io.Writer) func(o *ExecOpts) {
 	return func(o *ExecOpts) {
@@ -95,6 +142,95 @@ func SetLocals(locals map[string]interface{}) func(o *ExecOpts) {
 	}
 }
 
+// SetDryRun 开启dry-run模式, stubs为按函数名(例如shell.exec)自定义的返回值
+func SetDryRun(stubs map[string]interface{}) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.DryRun = true
+		o.DryRunStubs = stubs
+	}
+}
+
+// SetFixtures 录制或回放shell/http/fs调用, 由fixtures是否为LoadFixtures读取的决定
+func SetFixtures(fixtures *localctx.Fixtures) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Fixtures = fixtures
+	}
+}
+
+// SetDebugger 在调试器下执行, 暂停期间task状态为hanging
+func SetDebugger(d *debugger.Debugger) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Debugger = d
+	}
+}
+
+// SetResume 从上次中断处恢复执行, 需要使用与上次相同的job id
+func SetResume() func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Resume = true
+	}
+}
+
+// SetParams 设置脚本参数, 值为字符串形式, 执行前按声明的类型转换
+func SetParams(params map[string]string) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Params = params
+	}
+}
+
+// SetModuleRoot 设置本地模块根目录
+func SetModuleRoot(root string) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.ModuleRoot = root
+	}
+}
+
+// SetInventory 设置主机清单
+func SetInventory(inv *inventory.Inventory) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Inventory = inv
+	}
+}
+
+// SetSandbox 设置沙箱策略
+func SetSandbox(policy *sandbox.Policy) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Sandbox = policy
+	}
+}
+
+// SetLimits 设置执行步数, 输出字节数, shell标准输出字节数和堆内存的上限, 0表示不限制
+// 堆内存按整个进程检查, 只在进程只执行一个job时设置
+func SetLimits(steps, output, shellStdout, heap uint64) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.MaxSteps = steps
+		o.MaxOutputBytes = output
+		o.MaxShellStdout = shellStdout
+		o.MaxHeapBytes = heap
+	}
+}
+
+// SetSecretProviders 设置解析secret://引用的密码后端
+func SetSecretProviders(providers secret.Providers) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.SecretProviders = providers
+	}
+}
+
+// SetEntrypoint 脚本执行完成后调用name函数, 函数的错误作为执行结果
+func SetEntrypoint(name string) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.Entrypoint = name
+	}
+}
+
+// SetTestReporter 以测试模式执行, 脚本可以load("assert.star"), 断言失败报告给reporter
+func SetTestReporter(reporter starlarktest.Reporter) func(o *ExecOpts) {
+	return func(o *ExecOpts) {
+		o.TestReporter = reporter
+	}
+}
+
 // SetTimeout 设置超时
 func SetTimeout(duration time.Duration) func(o *ExecOpts) {
 	return func(o *ExecOpts) {
@@ -0,0 +1,490 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/secret"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// 参数类型
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamFloat  = "float"
+	ParamBool   = "bool"
+	ParamList   = "list"
+)
+
+// ErrUnknownParam 传入了脚本未声明的参数
+var ErrUnknownParam = errors.New("unknown parameter")
+
+// ParamError 参数声明或参数值不合法, 脚本没有执行, 通过errors.As与执行错误区分
+type ParamError struct {
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// Param 脚本声明的参数, 在脚本顶层通过params()声明:
+//
+//	p = params(
+//	    replicas = param("int", default=1, help="replicas of the deployment"),
+//	    region = param("string", required=True, choices=["eu", "us"]),
+//	    token = param("string", secret=True),
+//	)
+//	print(p.replicas, p.region)
+type Param struct {
+	Name     string
+	Type     string
+	Default  interface{}
+	Required bool
+	Choices  []interface{}
+	Secret   bool
+	Help     string
+}
+
+// paramStruct param()返回值的构造器名称
+const paramStruct = "param"
+
+// paramFn param(type="string", default=None, required=False, choices=[], secret=False, help="")
+func paramFn(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		typ                     = ParamString
+		def      starlark.Value = starlark.None
+		required bool
+		choices  *starlark.List
+		secret   bool
+		help     string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"type?", &typ, "default?", &def, "required?", &required, "choices?", &choices, "secret?", &secret, "help?", &help,
+	); err != nil {
+		return starlark.None, err
+	}
+	if choices == nil {
+		choices = starlark.NewList(nil)
+	}
+	return starlarkstruct.FromStringDict(starlark.String(paramStruct), starlark.StringDict{
+		"type":     starlark.String(typ),
+		"default":  def,
+		"required": starlark.Bool(required),
+		"choices":  choices,
+		"secret":   starlark.Bool(secret),
+		"help":     starlark.String(help),
+	}), nil
+}
+
+// toParam 转换param()的返回值并校验默认值和可选值
+func toParam(name string, v starlark.Value) (*Param, error) {
+	s, ok := v.(*starlarkstruct.Struct)
+	if !ok || s.Constructor() != starlark.String(paramStruct) {
+		return nil, fmt.Errorf("params: %s should be declared by param(), got %s", name, v.Type())
+	}
+	attr := func(key string) interface{} {
+		v, _ := s.Attr(key)
+		ret, _ := util.Unmarshal(v)
+		return ret
+	}
+	p := &Param{Name: name}
+	p.Type, _ = attr("type").(string)
+	p.Required, _ = attr("required").(bool)
+	p.Secret, _ = attr("secret").(bool)
+	p.Help, _ = attr("help").(string)
+	switch p.Type {
+	case ParamString, ParamInt, ParamFloat, ParamBool, ParamList:
+	default:
+		return nil, fmt.Errorf("params: %s has unknown type %q, should be string, int, float, bool or list", name, p.Type)
+	}
+	if choices, ok := attr("choices").([]interface{}); ok {
+		for _, c := range choices {
+			value, err := p.coerce(c)
+			if err != nil {
+				return nil, fmt.Errorf("params: %s has invalid choice: %w", name, err)
+			}
+			p.Choices = append(p.Choices, value)
+		}
+	}
+	if def := attr("default"); def != nil {
+		value, err := p.coerce(def)
+		if err != nil {
+			return nil, fmt.Errorf("params: %s has invalid default: %w", name, err)
+		}
+		if err := p.check(value); err != nil {
+			return nil, fmt.Errorf("params: %s has invalid default: %w", name, err)
+		}
+		p.Default = value
+	}
+	return p, nil
+}
+
+// isParamsCall 是否为params(...)调用
+func isParamsCall(expr syntax.Expr) (*syntax.CallExpr, bool) {
+	call, ok := expr.(*syntax.CallExpr)
+	if !ok {
+		return nil, false
+	}
+	fn, ok := call.Fn.(*syntax.Ident)
+	return call, ok && fn.Name == "params"
+}
+
+// ParseParams 在执行前静态解析脚本顶层的params()声明, 声明中只能使用字面量和param()
+func ParseParams(filename string, src []byte) ([]*Param, error) {
+	f, err := Dialect{}.Parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	var calls []*syntax.CallExpr
+	for _, stmt := range f.Stmts {
+		var expr syntax.Expr
+		switch stmt := stmt.(type) {
+		case *syntax.ExprStmt:
+			expr = stmt.X
+		case *syntax.AssignStmt:
+			expr = stmt.RHS
+		}
+		if call, ok := isParamsCall(expr); ok {
+			calls = append(calls, call)
+		}
+	}
+	switch len(calls) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		pos := calls[1].Lparen
+		return nil, fmt.Errorf("%s: params() can only be declared once", pos)
+	}
+
+	var decls []*Param
+	collect := func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) > 0 {
+			return starlark.None, fmt.Errorf("params: only keyword arguments are allowed, eg params(replicas=param(\"int\"))")
+		}
+		for _, kv := range kwargs {
+			name := string(kv[0].(starlark.String))
+			p, err := toParam(name, kv[1])
+			if err != nil {
+				return starlark.None, err
+			}
+			decls = append(decls, p)
+		}
+		return starlark.None, nil
+	}
+	thread := &starlark.Thread{Name: "params"}
+	// 参数声明只包含字面量, 使用默认语法选项
+	_, err = starlark.EvalExprOptions(Dialect{}.FileOptions(), thread, calls[0], starlark.StringDict{
+		"params": starlark.NewBuiltin("params", collect),
+		"param":  starlark.NewBuiltin("param", paramFn),
+	})
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, errors.New(evalErr.Msg)
+		}
+		return nil, fmt.Errorf("params() should only use literals and param(): %w", err)
+	}
+	return decls, nil
+}
+
+// coerce 将命令行的字符串或者ctx配置中的值转换为参数类型
+func (p *Param) coerce(v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok && p.Type != ParamString {
+		return p.parse(s)
+	}
+	switch p.Type {
+	case ParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case ParamInt:
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case int64:
+			return int(n), nil
+		case float64:
+			if n == float64(int(n)) {
+				return int(n), nil
+			}
+		}
+	case ParamFloat:
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case ParamBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case ParamList:
+		if l, ok := v.([]interface{}); ok {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("%v should be %s", v, p.Type)
+}
+
+// parse 解析字符串形式的值, list类型使用逗号分隔
+func (p *Param) parse(s string) (interface{}, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch p.Type {
+	case ParamInt:
+		v, err = strconv.Atoi(s)
+	case ParamFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case ParamBool:
+		v, err = strconv.ParseBool(s)
+	case ParamList:
+		list := []interface{}{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v = list
+	default:
+		v = s
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q should be %s", s, p.Type)
+	}
+	return v, nil
+}
+
+// check 校验可选值
+func (p *Param) check(v interface{}) error {
+	if len(p.Choices) == 0 {
+		return nil
+	}
+	for _, c := range p.Choices {
+		if fmt.Sprint(c) == fmt.Sprint(v) {
+			return nil
+		}
+	}
+	choices := make([]string, 0, len(p.Choices))
+	for _, c := range p.Choices {
+		choices = append(choices, fmt.Sprint(c))
+	}
+	return fmt.Errorf("%v is not one of [%s]", v, strings.Join(choices, ", "))
+}
+
+// ResolveParams 按命令行参数, ctx配置, 默认值的优先级确定参数值, 并校验类型, 必填和可选值
+func ResolveParams(decls []*Param, set map[string]string, config map[string]interface{}) (map[string]interface{}, error) {
+	declared := make(map[string]*Param, len(decls))
+	for _, p := range decls {
+		declared[p.Name] = p
+	}
+	var unknown []string
+	for name := range set {
+		if _, ok := declared[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w %s, run with --help-params to list the parameters", ErrUnknownParam, strings.Join(unknown, ", "))
+	}
+
+	values := make(map[string]interface{}, len(decls))
+	for _, p := range decls {
+		var (
+			value interface{}
+			err   error
+		)
+		if raw, ok := set[p.Name]; ok {
+			value, err = p.parse(raw)
+		} else if v, ok := config[p.Name]; ok && v != nil {
+			value, err = p.coerce(v)
+		} else if p.Default != nil {
+			value = p.Default
+		} else if p.Required {
+			return nil, fmt.Errorf("parameter %s is required", p.Name)
+		}
+		if err == nil && value != nil {
+			err = p.check(value)
+		}
+		if err != nil {
+			// 校验错误中包含参数值, secret参数不输出
+			if p.Secret {
+				return nil, fmt.Errorf("parameter %s: invalid secret value, should be %s", p.Name, p.Type)
+			}
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		values[p.Name] = value
+	}
+	return values, nil
+}
+
+// resolveSecretParams secret参数的值为secret://引用时在类型校验前通过密码后端解析, 返回替换后的参数和ctx配置
+func resolveSecretParams(decls []*Param, o *ExecOpts) (map[string]string, map[string]interface{}, error) {
+	set, config := o.Params, o.Locals
+	resolve := func(p *Param, ref string) (string, error) {
+		if o.SecretProviders == nil {
+			return "", fmt.Errorf("parameter %s: no secret providers configured for %s", p.Name, ref)
+		}
+		v, err := o.SecretProviders.Resolve(ref)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		return v, nil
+	}
+	for _, p := range decls {
+		if !p.Secret {
+			continue
+		}
+		if raw, ok := set[p.Name]; ok {
+			if !secret.IsRef(raw) {
+				continue
+			}
+			v, err := resolve(p, raw)
+			if err != nil {
+				return nil, nil, err
+			}
+			set = copyParams(set)
+			set[p.Name] = v
+		} else if ref, ok := config[p.Name].(string); ok && secret.IsRef(ref) {
+			v, err := resolve(p, ref)
+			if err != nil {
+				return nil, nil, err
+			}
+			config = copyConfig(config)
+			config[p.Name] = v
+		}
+	}
+	return set, config, nil
+}
+
+func copyParams(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+func copyConfig(m map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// applyParams 解析并校验脚本参数, 参数值写入ctx配置, secret参数写入secrets, 返回params()的返回值
+func applyParams(target *Target, o *ExecOpts) (starlark.Value, error) {
+	name, src := target.ScriptPath, target.ScriptContent
+	if len(src) == 0 {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		src = buf
+	}
+	decls, err := ParseParams(name, src)
+	if err != nil {
+		return nil, &ParamError{Err: err}
+	}
+	set, config, err := resolveSecretParams(decls, o)
+	if err != nil {
+		return nil, &ParamError{Err: err}
+	}
+	values, err := ResolveParams(decls, set, config)
+	if err != nil {
+		return nil, &ParamError{Err: err}
+	}
+	locals := make(map[string]interface{}, len(o.Locals)+len(values))
+	for k, v := range o.Locals {
+		locals[k] = v
+	}
+	secrets := make(map[string]string, len(o.Secrets))
+	for k, v := range o.Secrets {
+		secrets[k] = v
+	}
+	fields := make(starlark.StringDict, len(decls))
+	for _, p := range decls {
+		value := values[p.Name]
+		if p.Secret {
+			delete(locals, p.Name)
+			if value != nil {
+				// 绑定上下文时注册为job密码
+				secrets[p.Name] = fmt.Sprint(value)
+			}
+		} else {
+			locals[p.Name] = value
+		}
+		if fields[p.Name], err = util.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	o.Locals, o.Secrets = locals, secrets
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, fields), nil
+}
+
+// paramsBuiltin 运行时的params()返回执行前已经解析好的参数值
+func paramsBuiltin(values starlark.Value) *starlark.Builtin {
+	return starlark.NewBuiltin("params", func(_ *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		return values, nil
+	})
+}
+
+// FormatParams 输出参数说明
+func FormatParams(w io.Writer, decls []*Param) {
+	if len(decls) == 0 {
+		fmt.Fprintln(w, "no parameters declared")
+		return
+	}
+	fmt.Fprintln(w, "Parameters:")
+	for _, p := range decls {
+		var attrs []string
+		if p.Required {
+			attrs = append(attrs, "required")
+		}
+		if p.Default != nil {
+			def := fmt.Sprint(p.Default)
+			if p.Secret {
+				def = "******"
+			}
+			attrs = append(attrs, "default "+def)
+		}
+		if len(p.Choices) > 0 {
+			choices := make([]string, 0, len(p.Choices))
+			for _, c := range p.Choices {
+				choices = append(choices, fmt.Sprint(c))
+			}
+			attrs = append(attrs, "one of "+strings.Join(choices, "|"))
+		}
+		if p.Secret {
+			attrs = append(attrs, "secret")
+		}
+		line := fmt.Sprintf("  --%s %s", p.Name, p.Type)
+		if p.Help != "" {
+			line += "\t" + p.Help
+		}
+		if len(attrs) > 0 {
+			line += " (" + strings.Join(attrs, ", ") + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,139 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const paramsScript = `#!/usr/bin/env -S hyperops run
+p = params(
+    replicas = param("int", default=1, help="replicas of the deployment"),
+    region = param("string", required=True, choices=["eu", "us"]),
+    zones = param("list", default=["a"]),
+    ratio = param(type="float", default=0.5),
+    force = param("bool"),
+    token = param("string", secret=True),
+)
+print(p.replicas, p.region, p.zones, p.ratio, p.force, p.token)
+print(ctx.get_config("replicas"), ctx.get_secret("token"))
+`
+
+func TestParseParams(t *testing.T) {
+	decls, err := ParseParams("deploy.ops", []byte(paramsScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range decls {
+		names = append(names, p.Name)
+	}
+	if want := []string{"replicas", "region", "zones", "ratio", "force", "token"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("params = %v, want %v", names, want)
+	}
+	if p := decls[0]; p.Type != ParamInt || p.Default != 1 || p.Help != "replicas of the deployment" {
+		t.Errorf("unexpected replicas %+v", p)
+	}
+	if p := decls[1]; !p.Required || len(p.Choices) != 2 {
+		t.Errorf("unexpected region %+v", p)
+	}
+	if !decls[5].Secret {
+		t.Error("token should be secret")
+	}
+
+	buf := &bytes.Buffer{}
+	FormatParams(buf, decls)
+	if !strings.Contains(buf.String(), "--region string (required, one of eu|us)") {
+		t.Errorf("unexpected help: %s", buf.String())
+	}
+
+	for src, expect := range map[string]string{
+		`params(n = param("int", default="x"))`:            "invalid default",
+		`params(n = param("uint"))`:                        "unknown type",
+		`params(n = param("int", choices=[1], default=2))`: "is not one of",
+		`params(n = "int")`:                                "should be declared by param()",
+		`x = 1` + "\n" + `params(n = param(default=x))`:    "undefined: x",
+		`params()` + "\n" + `params()`:                     "only be declared once",
+	} {
+		if _, err := ParseParams("x.ops", []byte(src)); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected error %q, got %v", src, expect, err)
+		}
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	decls, err := ParseParams("deploy.ops", []byte(paramsScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := ResolveParams(decls,
+		map[string]string{"replicas": "3", "zones": "a, b", "force": "true"},
+		map[string]interface{}{"region": "eu", "ratio": 1, "replicas": 2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"replicas": 3,
+		"region":   "eu",
+		"zones":    []interface{}{"a", "b"},
+		"ratio":    1.0,
+		"force":    true,
+		"token":    nil,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	cases := []struct {
+		set    map[string]string
+		config map[string]interface{}
+		expect string
+	}{
+		{nil, nil, "parameter region is required"},
+		{map[string]string{"region": "asia"}, nil, "asia is not one of [eu, us]"},
+		{map[string]string{"region": "eu", "replicas": "x"}, nil, `"x" should be int`},
+		{nil, map[string]interface{}{"region": "eu", "force": 1}, "1 should be bool"},
+		{map[string]string{"region": "eu", "replica": "2"}, nil, "unknown parameter replica"},
+	}
+	for _, c := range cases {
+		_, err := ResolveParams(decls, c.set, c.config)
+		if err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Errorf("set=%v config=%v: expected error %q, got %v", c.set, c.config, c.expect, err)
+		}
+	}
+	if _, err := ResolveParams(nil, map[string]string{"x": "1"}, nil); !errors.Is(err, ErrUnknownParam) {
+		t.Errorf("expected unknown parameter error, got %v", err)
+	}
+}
+
+func TestExecScriptParams(t *testing.T) {
+	run := func(params map[string]string) (string, error) {
+		output := &bytes.Buffer{}
+		err := ExecScript(context.Background(), &Target{ScriptContent: []byte(paramsScript)},
+			SetOutputWriter(output),
+			SetLocals(map[string]interface{}{"region": "us"}),
+			SetParams(params),
+		)
+		return output.String(), err
+	}
+	out, err := run(map[string]string{"replicas": "5", "token": "p@ssw0rd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{`5 us ["a"] 0.5 None ********`, "5 ********"} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("output mismatch. expected: '%s', got: '%s'", expect, out)
+		}
+	}
+	var paramErr *ParamError
+	if _, err := run(map[string]string{"replicas": "five"}); !errors.As(err, &paramErr) || !strings.Contains(err.Error(), "replicas") {
+		t.Errorf("expected validation error before execution, got %v", err)
+	}
+	if _, err := run(map[string]string{"region": "fr"}); !errors.As(err, &paramErr) {
+		t.Errorf("expected choices error, got %v", err)
+	}
+}
@@ -6,11 +6,13 @@ import (
 	"time"
 
 	"encoding/base64"
+	nethttp "net/http"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	http "github.com/cloudevents/sdk-go/v2/protocol/http"
 	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
@@ -21,10 +23,23 @@ const ModuleName = "cloudevents.star"
 var Module = &starlarkstruct.Module{
 	Name: "cloudevents",
 	Members: starlark.StringDict{
-		"report": localctx.AddBuiltin("cloudevents.report", Report),
+		"report": localctx.AddSideEffectBuiltin("cloudevents.report", Report, localctx.StubTrue),
 	},
 }
 
+// checkAddr 沙箱中只能上报到允许的主机
+func checkAddr(thread *starlark.Thread, addr string) error {
+	policy := sandbox.FromThread(thread)
+	if policy == nil {
+		return nil
+	}
+	req, err := nethttp.NewRequest(nethttp.MethodPost, addr, nil)
+	if err != nil {
+		return err
+	}
+	return policy.CloudEventsGuard().Allowed(req)
+}
+
 func Report(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var (
 		addr    starlark.String
@@ -42,6 +57,9 @@ func Report(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 	if err != nil {
 		return starlark.None, err
 	}
+	if err := checkAddr(thread, addrstr); err != nil {
+		return starlark.None, err
+	}
 	if len(auth) == 2 {
 		username, err := util.AsString(auth[0])
 		if err != nil {
@@ -106,6 +124,8 @@ func Report(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 		}
 	}
 	cedata, err := util.Unmarshal(data)
+	// 上报的数据中替换job的密码
+	cedata = localctx.RedactValue(thread, cedata)
 	if err != nil {
 		err = event.SetData(cloudevents.ApplicationJSON, map[string]string{"error": err.Error()})
 		if err != nil {
@@ -2,6 +2,7 @@ package env
 
 import (
 	"github.com/superops-team/hyperops/pkg/environment"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/util"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
@@ -14,7 +15,7 @@ var Module = &starlarkstruct.Module{
 	Name: "env",
 	Members: starlark.StringDict{
 		"get": starlark.NewBuiltin("env.get", Get),
-		"set": starlark.NewBuiltin("env.set", Set),
+		"set": localctx.AddSideEffectBuiltin("env.set", Set, nil),
 	},
 }
 
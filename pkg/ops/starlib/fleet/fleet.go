@@ -0,0 +1,235 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"golang.org/x/time/rate"
+
+	starlarktime "go.starlark.net/lib/time"
+)
+
+const Name = "fleet"
+const ModuleName = "fleet.star"
+
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+var Module = &starlarkstruct.Module{
+	Name: "fleet",
+	Members: starlark.StringDict{
+		"run": localctx.AddBuiltin("fleet.run", Run),
+	},
+}
+
+// result 单个主机的执行结果
+type result struct {
+	name   string
+	status string
+	value  starlark.Value
+	err    error
+}
+
+func (r *result) Struct() *starlarkstruct.Struct {
+	errMsg := starlark.Value(starlark.None)
+	if r.err != nil {
+		errMsg = starlark.String(r.err.Error())
+	}
+	value := r.value
+	if value == nil {
+		value = starlark.None
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"host":   starlark.String(r.name),
+		"status": starlark.String(r.status),
+		"value":  value,
+		"error":  errMsg,
+	})
+}
+
+// hostName 主机可以是字符串或者带name属性的结构(例如inventory.hosts的返回值)
+func hostName(host starlark.Value) (string, error) {
+	if s, ok := starlark.AsString(host); ok {
+		return s, nil
+	}
+	if v, ok := host.(starlark.HasAttrs); ok {
+		if name, err := v.Attr("name"); err == nil && name != nil {
+			if s, ok := starlark.AsString(name); ok {
+				return s, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("fleet.run: host should be a string or a struct with name, got %s", host.Type())
+}
+
+// Run fleet.run(hosts, fn, parallel=1, max_fail=0, every=None)
+// 最多parallel个主机并发执行fn(host), 与group.make一样可以用every限制启动速率.
+// fn抛出异常即视为失败, 失败数超过max_fail后不再执行剩余主机, max_fail小于0表示不限制.
+// 返回结构: ok, succeeded, failed, skipped, results
+func Run(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		hosts    starlark.Iterable
+		fn       starlark.Callable
+		parallel = 1
+		maxFail  = 0
+		every    starlarktime.Duration
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"hosts", &hosts, "fn", &fn, "parallel?", &parallel, "max_fail?", &maxFail, "every?", &every,
+	); err != nil {
+		return starlark.None, err
+	}
+	if parallel <= 0 {
+		parallel = 1
+	}
+	limit := rate.Inf
+	if every.Truth() {
+		limit = rate.Every(time.Duration(every))
+	}
+	limiter := rate.NewLimiter(limit, 1)
+	ctx, ok := thread.Local("context").(context.Context)
+	if !ok {
+		ctx = context.Background()
+	}
+
+	var (
+		items []starlark.Value
+		names []string
+	)
+	iter := hosts.Iterate()
+	defer iter.Done()
+	var host starlark.Value
+	for iter.Next(&host) {
+		name, err := hostName(host)
+		if err != nil {
+			return starlark.None, err
+		}
+		items = append(items, host)
+		names = append(names, name)
+	}
+
+	var (
+		mu       sync.Mutex
+		failures int
+		stopped  bool
+		wg       sync.WaitGroup
+	)
+	printer := func(goThread *starlark.Thread, msg string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if thread.Print != nil {
+			thread.Print(goThread, msg)
+		}
+	}
+	var loader func(goThread *starlark.Thread, module string) (starlark.StringDict, error)
+	if thread.Load != nil {
+		loader = func(goThread *starlark.Thread, module string) (starlark.StringDict, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return thread.Load(goThread, module)
+		}
+	}
+
+	results := make([]*result, len(items))
+	queue := make(chan int)
+	for w := 0; w < parallel && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				// job已经取消时剩余的主机不再执行, 结果为skipped
+				if ctx.Err() != nil {
+					continue
+				}
+				// 子线程与父线程同名, 输出和事件归属于同一个任务
+				child := &starlark.Thread{Name: thread.Name, Print: printer, Load: loader}
+				child.SetLocal("context", ctx)
+				localctx.InheritLocals(thread, child)
+				v, err := starlark.Call(child, fn, starlark.Tuple{items[i]}, nil)
+				localctx.ReleaseLocals(child)
+				r := &result{name: names[i], status: StatusOK, value: v, err: err}
+				mu.Lock()
+				if err != nil {
+					r.status = StatusFailed
+					failures++
+					if maxFail >= 0 && failures > maxFail {
+						stopped = true
+					}
+				}
+				results[i] = r
+				mu.Unlock()
+				if err != nil {
+					printer(thread, fmt.Sprintf("[fleet] %s failed: %s", names[i], err.Error()))
+				}
+			}
+		}()
+	}
+	var waitErr error
+	for i := range items {
+		mu.Lock()
+		stop := stopped
+		mu.Unlock()
+		if stop {
+			break
+		}
+		if waitErr = limiter.Wait(ctx); waitErr != nil {
+			break
+		}
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+		}
+		if waitErr != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if waitErr == nil {
+		waitErr = ctx.Err()
+	}
+	if waitErr != nil {
+		return starlark.None, fmt.Errorf("fleet.run cancelled: %w", waitErr)
+	}
+
+	var (
+		succeeded, failed, skipped []starlark.Value
+		dict                       = starlark.NewDict(len(items))
+	)
+	for i, r := range results {
+		if r == nil {
+			r = &result{name: names[i], status: StatusSkipped}
+			results[i] = r
+		}
+		switch r.status {
+		case StatusOK:
+			succeeded = append(succeeded, starlark.String(r.name))
+		case StatusFailed:
+			failed = append(failed, starlark.String(r.name))
+		default:
+			skipped = append(skipped, starlark.String(r.name))
+		}
+		if err := dict.SetKey(starlark.String(r.name), r.Struct()); err != nil {
+			return starlark.None, err
+		}
+	}
+	if len(skipped) > 0 {
+		printer(thread, fmt.Sprintf("[fleet] failure budget exceeded (%d failed, max_fail=%d), %d hosts skipped", len(failed), maxFail, len(skipped)))
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"ok":        starlark.Bool(len(skipped) == 0 && (maxFail < 0 || len(failed) <= maxFail)),
+		"succeeded": starlark.NewList(succeeded),
+		"failed":    starlark.NewList(failed),
+		"skipped":   starlark.NewList(skipped),
+		"results":   dict,
+	}), nil
+}
@@ -0,0 +1,44 @@
+package fleet
+
+import (
+	"testing"
+
+	"github.com/superops-team/hyperops/pkg/inventory"
+	starlibinventory "github.com/superops-team/hyperops/pkg/ops/starlib/inventory"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/testdata"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
+)
+
+const hosts = `
+[web]
+web1
+web2 http_port=9090
+
+[web:vars]
+http_port=80
+
+[db]
+db1 broken=true
+db2
+
+[db:vars]
+http_port=5432
+`
+
+func TestNewModule(t *testing.T) {
+	resolve.AllowLambda = true
+	inv, err := inventory.ParseINI([]byte(hosts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &starlark.Thread{Load: testdata.NewModuleLoader(Module, starlibinventory.Module)}
+	starlibinventory.Set(thread, inv)
+	starlarktest.SetReporter(thread, t)
+
+	_, err = starlark.ExecFile(thread, "testdata/test.star", nil, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
@@ -27,20 +28,31 @@ var Module = &starlarkstruct.Module{
 	Name: "fs",
 	Members: starlark.StringDict{
 		"readall":  localctx.AddBuiltin("fs.readall", ReadAll),
-		"create":   localctx.AddBuiltin("fs.create", Create),
-		"append":   localctx.AddBuiltin("fs.append", Append),
+		"create":   localctx.AddSideEffectBuiltin("fs.create", Create, localctx.StubTrue),
+		"append":   localctx.AddSideEffectBuiltin("fs.append", Append, localctx.StubTrue),
 		"md5":      localctx.AddBuiltin("fs.md5", Md5),
-		"gzip":     localctx.AddBuiltin("fs.gzip", Gzip),
+		"gzip":     localctx.AddSideEffectBuiltin("fs.gzip", Gzip, localctx.StubTrue),
 		"exist":    localctx.AddBuiltin("fs.exist", Exist),
 		"stat":     localctx.AddBuiltin("fs.stat", Stat),
 		"glob":     localctx.AddBuiltin("fs.glob", Glob),
 		"ls":       localctx.AddBuiltin("fs.ls", Ls),
 		"basename": localctx.AddBuiltin("fs.basename", Basename),
 		"dirname":  localctx.AddBuiltin("fs.dirname", Dirname),
-		"rm":       localctx.AddBuiltin("fs.rm", Remove),
+		"rm":       localctx.AddSideEffectBuiltin("fs.rm", Remove, localctx.StubTrue),
 	},
 }
 
+// checkPath 沙箱中只能访问fs.roots下的文件
+func checkPath(thread *starlark.Thread, paths ...string) error {
+	policy := sandbox.FromThread(thread)
+	for _, p := range paths {
+		if err := policy.CheckPath(thread, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func Gzip(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	params, err := util.GetParser(args, kwargs)
 	if err != nil {
@@ -60,6 +72,9 @@ func Gzip(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 			return starlark.None, err
 		}
 	}
+	if err := checkPath(thread, filename, output); err != nil {
+		return starlark.Bool(false), err
+	}
 	file, err := os.Open(filename)
 	if err != nil {
 		return starlark.Bool(false), err
@@ -114,6 +129,9 @@ func Md5(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwar
 			return starlark.None, err
 		}
 	}
+	if err := checkPath(thread, filename); err != nil {
+		return starlark.None, err
+	}
 	file, err := os.Open(filename)
 	if err != nil {
 		return starlark.None, err
@@ -178,6 +196,9 @@ func Remove(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 			flag = ""
 		}
 	}
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.Bool(false), err
+	}
 	if flag == "all" {
 		err = os.RemoveAll(filePath)
 	} else {
@@ -209,6 +230,9 @@ func Ls(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwarg
 			num = -1
 		}
 	}
+	if err := checkPath(thread, dirPath); err != nil {
+		return starlark.None, err
+	}
 	// 打开目录
 	dir, err := os.Open(dirPath)
 	if err != nil {
@@ -273,6 +297,9 @@ func Append(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 		return starlark.None, err
 	}
 
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.Bool(false), err
+	}
 	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return starlark.Bool(false), err
@@ -300,6 +327,9 @@ func Create(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, k
 		content = ""
 	}
 
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.None, err
+	}
 	// 如果文件不存在则创建，如果已经存在则会覆盖
 	file, err := os.Create(filePath)
 	if err != nil {
@@ -324,6 +354,9 @@ func ReadAll(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 		return starlark.None, err
 	}
 
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.None, err
+	}
 	file, err := os.Open(filePath)
 	if err != nil {
 		return starlark.None, err
@@ -352,6 +385,16 @@ func Glob(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 	if err != nil {
 		return starlark.None, err
 	}
+	// 沙箱中只返回允许访问的文件
+	if policy := sandbox.FromThread(thread); policy != nil {
+		allowed := files[:0]
+		for _, file := range files {
+			if policy.CheckPath(thread, file) == nil {
+				allowed = append(allowed, file)
+			}
+		}
+		files = allowed
+	}
 	return util.ConvertToStarlark(files)
 }
 
@@ -364,6 +407,9 @@ func Stat(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 	if err != nil {
 		return starlark.None, err
 	}
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.None, err
+	}
 	fileStat, err := os.Stat(filePath)
 	if err != nil {
 		return starlark.None, err
@@ -380,6 +426,9 @@ func Exist(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kw
 	if err != nil {
 		return starlark.None, err
 	}
+	if err := checkPath(thread, filePath); err != nil {
+		return starlark.Bool(false), err
+	}
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return starlark.Bool(false), err
 	}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 	"golang.org/x/sync/errgroup"
@@ -193,6 +194,7 @@ func group_wait(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tupl
 			return nil, err
 		}
 
+		parent := thread
 		call := func() error {
 			thread := &starlark.Thread{
 				Name:  thread.Name + "/" + strconv.Itoa(i),
@@ -200,6 +202,8 @@ func group_wait(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tupl
 				Load:  loader,
 			}
 			thread.SetLocal("context", g.ctx)
+			localctx.InheritLocals(parent, thread)
+			defer localctx.ReleaseLocals(thread)
 
 			v, err := starlark.Call(thread, fn, args, kwargs)
 			if err != nil {
@@ -13,6 +13,7 @@ import (
 
 	localctx "github.com/superops-team/hyperops/pkg/ops/context"
 	util "github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
@@ -69,6 +70,18 @@ type Module struct {
 	rg  RequestGuard
 }
 
+// guards returns the global RequestGuard and the sandbox host allow-list bound to the thread
+func (m *Module) guards(thread *starlark.Thread) []RequestGuard {
+	var guards []RequestGuard
+	if m.rg != nil {
+		guards = append(guards, m.rg)
+	}
+	if policy := sandbox.FromThread(thread); policy != nil {
+		guards = append(guards, policy.HTTPGuard())
+	}
+	return guards
+}
+
 // Struct returns this module's methods as a starlark Struct
 func (m *Module) Struct() *starlarkstruct.Struct {
 	return starlarkstruct.FromStringDict(starlarkstruct.Default, m.StringDict())
@@ -77,12 +90,39 @@ func (m *Module) Struct() *starlarkstruct.Struct {
 // StringDict returns all module methods in a starlark.StringDict
 func (m *Module) StringDict() starlark.StringDict {
 	return starlark.StringDict{
-		"get":     localctx.AddBuiltin("get", m.reqMethod("get")),
-		"put":     localctx.AddBuiltin("put", m.reqMethod("put")),
-		"post":    localctx.AddBuiltin("post", m.reqMethod("post")),
-		"delete":  localctx.AddBuiltin("delete", m.reqMethod("delete")),
-		"patch":   localctx.AddBuiltin("patch", m.reqMethod("patch")),
-		"options": localctx.AddBuiltin("options", m.reqMethod("options")),
+		"get":     localctx.AddBuiltin("http.get", m.reqMethod("get")),
+		"put":     localctx.AddSideEffectBuiltin("http.put", m.reqMethod("put"), dryRunStub("put")),
+		"post":    localctx.AddSideEffectBuiltin("http.post", m.reqMethod("post"), dryRunStub("post")),
+		"delete":  localctx.AddSideEffectBuiltin("http.delete", m.reqMethod("delete"), dryRunStub("delete")),
+		"patch":   localctx.AddSideEffectBuiltin("http.patch", m.reqMethod("patch"), dryRunStub("patch")),
+		"options": localctx.AddBuiltin("http.options", m.reqMethod("options")),
+	}
+}
+
+// dryRunStub returns an empty 200 response instead of sending the request in dry-run mode
+func dryRunStub(method string) localctx.DryRunStub {
+	return func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var rawurl string
+		if len(args) > 0 {
+			rawurl, _ = starlark.AsString(args[0])
+		}
+		for _, kwarg := range kwargs {
+			if name, _ := starlark.AsString(kwarg[0]); name == "url" {
+				rawurl, _ = starlark.AsString(kwarg[1])
+			}
+		}
+		req, err := http.NewRequest(strings.ToUpper(method), rawurl, nil)
+		if err != nil {
+			return nil, err
+		}
+		r := &Response{http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}}
+		return r.Struct(), nil
 	}
 }
 
@@ -116,8 +156,8 @@ func (m *Module) reqMethod(method string) func(thread *starlark.Thread, _ *starl
 		if err != nil {
 			return nil, err
 		}
-		if m.rg != nil {
-			if err := m.rg.Allowed(req); err != nil {
+		for _, rg := range m.guards(thread) {
+			if err := rg.Allowed(req); err != nil {
 				return nil, err
 			}
 		}
@@ -363,12 +403,12 @@ func (r *Response) JSON(thread *starlark.Thread, _ *starlark.Builtin, args starl
 	if err != nil {
 		return nil, err
 	}
+	r.Body.Close()
+	// reset reader to allow multiple calls, also when the body is not json
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
 
 	if err := json.Unmarshal(body, &data); err != nil {
 		return nil, err
 	}
-	r.Body.Close()
-	// reset reader to allow multiple calls
-	r.Body = ioutil.NopCloser(bytes.NewReader(body))
 	return util.Marshal(data)
 }
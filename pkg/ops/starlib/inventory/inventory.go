@@ -0,0 +1,110 @@
+package inventory
+
+import (
+	"errors"
+
+	"github.com/superops-team/hyperops/pkg/inventory"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const Name = "inventory"
+const ModuleName = "inventory.star"
+
+// ErrNoInventory 执行时没有指定主机清单
+var ErrNoInventory = errors.New("no inventory loaded, run with --inventory=<file>")
+
+var Module = &starlarkstruct.Module{
+	Name: "inventory",
+	Members: starlark.StringDict{
+		"hosts":  localctx.AddBuiltin("inventory.hosts", Hosts),
+		"host":   localctx.AddBuiltin("inventory.host", Host),
+		"groups": localctx.AddBuiltin("inventory.groups", Groups),
+	},
+}
+
+// Set 将主机清单绑定到线程
+func Set(thread *starlark.Thread, inv *inventory.Inventory) {
+	thread.SetLocal(localctx.INVENTORY_NAME, inv)
+}
+
+func get(thread *starlark.Thread) (*inventory.Inventory, error) {
+	inv, ok := thread.Local(localctx.INVENTORY_NAME).(*inventory.Inventory)
+	if !ok || inv == nil {
+		return nil, ErrNoInventory
+	}
+	return inv, nil
+}
+
+// HostStruct 转换为starlark结构: name, groups, vars
+func HostStruct(host *inventory.Host) (*starlarkstruct.Struct, error) {
+	vars, err := util.Marshal(host.Vars)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := util.Marshal(host.Groups)
+	if err != nil {
+		return nil, err
+	}
+	return starlarkstruct.FromStringDict(starlark.String("host"), starlark.StringDict{
+		"name":   starlark.String(host.Name),
+		"groups": groups,
+		"vars":   vars,
+	}), nil
+}
+
+// Hosts inventory.hosts(pattern="all") 按分组名或主机名匹配主机, 支持web:db:&prod:!web3形式的组合
+func Hosts(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	pattern := inventory.AllGroup
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern?", &pattern); err != nil {
+		return starlark.None, err
+	}
+	inv, err := get(thread)
+	if err != nil {
+		return starlark.None, err
+	}
+	hosts, err := inv.Hosts(pattern)
+	if err != nil {
+		return starlark.None, err
+	}
+	elems := make([]starlark.Value, 0, len(hosts))
+	for _, host := range hosts {
+		s, err := HostStruct(host)
+		if err != nil {
+			return starlark.None, err
+		}
+		elems = append(elems, s)
+	}
+	return starlark.NewList(elems), nil
+}
+
+// Host inventory.host(name) 获取单个主机, 不存在时返回None
+func Host(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return starlark.None, err
+	}
+	inv, err := get(thread)
+	if err != nil {
+		return starlark.None, err
+	}
+	host := inv.Host(name)
+	if host == nil {
+		return starlark.None, nil
+	}
+	return HostStruct(host)
+}
+
+// Groups inventory.groups() 返回所有分组名
+func Groups(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return starlark.None, err
+	}
+	inv, err := get(thread)
+	if err != nil {
+		return starlark.None, err
+	}
+	return util.Marshal(inv.GroupNames())
+}
@@ -66,13 +66,13 @@ type LocalCache struct {
 
 func (l *LocalCache) Struct() *starlarkstruct.Struct {
 	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
-		"set":          localctx.AddBuiltin("localcache.set", l.Set),
-		"set_with_ttl": localctx.AddBuiltin("localcache.set_with_ttl", l.SetWithTTL),
+		"set":          localctx.AddSideEffectBuiltin("localcache.set", l.Set, localctx.StubTrue),
+		"set_with_ttl": localctx.AddSideEffectBuiltin("localcache.set_with_ttl", l.SetWithTTL, localctx.StubTrue),
 		"get":          localctx.AddBuiltin("localcache.get", l.Get),
-		"delete":       localctx.AddBuiltin("localcache.delete", l.Delete),
+		"delete":       localctx.AddSideEffectBuiltin("localcache.delete", l.Delete, localctx.StubTrue),
 		"filter":       localctx.AddBuiltin("localcache.filter", l.Filter),
 		"filter_key":   localctx.AddBuiltin("localcache.filter_key", l.FilterKey),
-		"clear":        localctx.AddBuiltin("localcache.clear", l.Clear),
+		"clear":        localctx.AddSideEffectBuiltin("localcache.clear", l.Clear, localctx.StubTrue),
 		"exist":        localctx.AddBuiltin("localcache.exist", l.Exist),
 	})
 }
@@ -43,8 +43,7 @@ func New(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwar
 	t := Metric{
 		token: token,
 	}
-	sm := context.NewSecretsManager()
-	sm.AddSecret(t.token)
+	context.NewSecretsManager().AddJobSecret(context.SecretScope(thread), t.token)
 	return t.Struct(), nil
 }
 
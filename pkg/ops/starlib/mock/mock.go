@@ -0,0 +1,229 @@
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"regexp"
+	"strings"
+
+	"github.com/superops-team/hyperops/pkg/localexec"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/http"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/ssh"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const Name = "mock"
+const ModuleName = "mock.star"
+
+// Module 测试脚本中替换内置函数的返回值, 只在hyperops test中可以加载
+var Module = &starlarkstruct.Module{
+	Name: Name,
+	Members: starlark.StringDict{
+		"shell": starlark.NewBuiltin("mock.shell", Shell),
+		"http":  starlark.NewBuiltin("mock.http", HTTP),
+		"fs":    starlark.NewBuiltin("mock.fs", FS),
+		"fn":    starlark.NewBuiltin("mock.fn", Fn),
+		"calls": starlark.NewBuiltin("mock.calls", Calls),
+	},
+}
+
+// shellFuncs mock.shell替换的内置函数
+var shellFuncs = []string{"sh", "shell.exec"}
+
+// register 将mock规则注册到线程绑定的mock集合
+func register(thread *starlark.Thread, fnname string, funcs []string, pattern string, result localctx.MockResult) (starlark.Value, error) {
+	mocks := localctx.MocksFromThread(thread)
+	if mocks == nil {
+		return starlark.None, fmt.Errorf("%s: mocks are only available in hyperops test", fnname)
+	}
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return starlark.None, fmt.Errorf("%s: invalid pattern: %w", fnname, err)
+		}
+	}
+	m := localctx.NewMock(funcs, re, result)
+	mocks.Add(m)
+	return &Value{name: fnname, mock: m}, nil
+}
+
+// Shell mock.shell(pattern="", code=0, stdout="", stderr="", error="")替换命令匹配pattern的sh和shell.exec调用
+func Shell(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		pattern, errmsg string
+		res             localexec.Result
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"pattern?", &pattern, "code?", &res.Code, "stdout?", &res.Stdout, "stderr?", &res.Stderr, "error?", &errmsg,
+	); err != nil {
+		return starlark.None, err
+	}
+	return register(thread, b.Name(), shellFuncs, pattern, func(thread *starlark.Thread, _ *starlark.Builtin, _ string, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		if errmsg != "" {
+			return starlark.None, errors.New(errmsg)
+		}
+		result := res
+		return ssh.ResultStruct(thread, &result), nil
+	})
+}
+
+// HTTP mock.http(method="*", pattern="", status=200, body="", json=None, headers={}, error="")替换url匹配pattern的http请求
+func HTTP(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		method          = "*"
+		pattern, errmsg string
+		status          = nethttp.StatusOK
+		body            string
+		jsonBody        starlark.Value
+		headers         = &starlark.Dict{}
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"method?", &method, "pattern?", &pattern, "status?", &status, "body?", &body, "json?", &jsonBody, "headers?", &headers, "error?", &errmsg,
+	); err != nil {
+		return starlark.None, err
+	}
+	if jsonBody != nil && jsonBody != starlark.None {
+		encoded, err := starlark.Call(thread, json.Module.Members["encode"], starlark.Tuple{jsonBody}, nil)
+		if err != nil {
+			return starlark.None, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+		body = string(encoded.(starlark.String))
+	}
+	header := nethttp.Header{}
+	for _, item := range headers.Items() {
+		key, ok1 := starlark.AsString(item[0])
+		val, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return starlark.None, fmt.Errorf("%s: headers should be a dict of strings", b.Name())
+		}
+		header.Set(key, val)
+	}
+	return register(thread, b.Name(), []string{"http." + strings.ToLower(method)}, pattern, func(_ *starlark.Thread, fn *starlark.Builtin, subject string, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+		if errmsg != "" {
+			return starlark.None, errors.New(errmsg)
+		}
+		req, err := nethttp.NewRequest(strings.ToUpper(strings.TrimPrefix(fn.Name(), "http.")), subject, nil)
+		if err != nil {
+			return starlark.None, err
+		}
+		r := &http.Response{Response: nethttp.Response{
+			Status:     fmt.Sprintf("%d %s", status, nethttp.StatusText(status)),
+			StatusCode: status,
+			Header:     header.Clone(),
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+			Request:    req,
+		}}
+		return r.Struct(), nil
+	})
+}
+
+// FS mock.fs(func="*", pattern="", result=None, error="")替换路径匹配pattern的fs调用
+func FS(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		fn              = "*"
+		pattern, errmsg string
+		result          starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"func?", &fn, "pattern?", &pattern, "result?", &result, "error?", &errmsg,
+	); err != nil {
+		return starlark.None, err
+	}
+	return register(thread, b.Name(), []string{"fs." + fn}, pattern, valueResult(result, errmsg))
+}
+
+// Fn mock.fn(name, pattern="", result=None, error="")按名称替换任意内置函数, 例如metric.get_queries, ssh.exec, http.*
+func Fn(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name, pattern, errmsg string
+		result                starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name", &name, "pattern?", &pattern, "result?", &result, "error?", &errmsg,
+	); err != nil {
+		return starlark.None, err
+	}
+	return register(thread, b.Name(), []string{name}, pattern, valueResult(result, errmsg))
+}
+
+// valueResult result为函数时使用原调用的参数调用它, dict转换为struct以便通过属性访问
+func valueResult(result starlark.Value, errmsg string) localctx.MockResult {
+	return func(thread *starlark.Thread, _ *starlark.Builtin, _ string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if errmsg != "" {
+			return starlark.None, errors.New(errmsg)
+		}
+		if callable, ok := result.(starlark.Callable); ok {
+			return starlark.Call(thread, callable, args, kwargs)
+		}
+		return localctx.ToStruct(result), nil
+	}
+}
+
+// Calls mock.calls(name="*")返回名称匹配的内置函数的所有调用, 包括未被mock的调用
+func Calls(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	name := "*"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name?", &name); err != nil {
+		return starlark.None, err
+	}
+	mocks := localctx.MocksFromThread(thread)
+	if mocks == nil {
+		return starlark.None, fmt.Errorf("%s: mocks are only available in hyperops test", b.Name())
+	}
+	return callList(mocks.Calls(name)), nil
+}
+
+func callList(calls []*localctx.MockCall) *starlark.List {
+	list := make([]starlark.Value, 0, len(calls))
+	for _, call := range calls {
+		kw := starlark.NewDict(len(call.Kwargs))
+		for _, kwarg := range call.Kwargs {
+			_ = kw.SetKey(kwarg[0], kwarg[1])
+		}
+		list = append(list, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"name":    starlark.String(call.Name),
+			"subject": starlark.String(call.Subject),
+			"args":    call.Args,
+			"kwargs":  kw,
+		}))
+	}
+	return starlark.NewList(list)
+}
+
+// Value mock规则的句柄, 通过count和calls检查调用次数和参数
+type Value struct {
+	name string
+	mock *localctx.Mock
+}
+
+var _ starlark.HasAttrs = (*Value)(nil)
+
+func (v *Value) String() string        { return fmt.Sprintf("<%s>", v.name) }
+func (v *Value) Type() string          { return "mock" }
+func (v *Value) Freeze()               {}
+func (v *Value) Truth() starlark.Bool  { return starlark.True }
+func (v *Value) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: mock") }
+
+// Attr count为命中次数, called为是否命中过, calls为命中的调用
+func (v *Value) Attr(name string) (starlark.Value, error) {
+	calls := v.mock.Calls()
+	switch name {
+	case "count":
+		return starlark.MakeInt(len(calls)), nil
+	case "called":
+		return starlark.Bool(len(calls) > 0), nil
+	case "calls":
+		return callList(calls), nil
+	}
+	return nil, nil
+}
+
+func (v *Value) AttrNames() []string {
+	return []string{"called", "calls", "count"}
+}
@@ -0,0 +1,45 @@
+package mock
+
+import (
+	"testing"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/fs"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/http"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/sh"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/testdata"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
+)
+
+func TestNewModule(t *testing.T) {
+	resolve.AllowLambda = true
+	modules := testdata.NewModuleLoader(Module, sh.Module, fs.Module)
+	thread := &starlark.Thread{Load: func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		if module == http.ModuleName {
+			return http.LoadModule()
+		}
+		return modules(thread, module)
+	}}
+	localctx.NewMocks().Bind(thread)
+	starlarktest.SetReporter(thread, t)
+
+	predeclared := starlark.StringDict{
+		"sh": localctx.AddSideEffectBuiltin("sh", sh.Exec, sh.DryRunStub),
+	}
+	_, err := starlark.ExecFile(thread, "testdata/test.star", nil, predeclared)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithoutMocks(t *testing.T) {
+	thread := &starlark.Thread{Load: testdata.NewModuleLoader(Module)}
+	_, err := starlark.ExecFile(thread, "without.star", `load("mock.star", "mock")
+mock.shell("ls")
+`, nil)
+	if err == nil {
+		t.Error("expected an error without bound mocks")
+	}
+}
@@ -7,7 +7,10 @@ import (
 
 	"github.com/superops-team/hyperops/pkg/environment"
 	"github.com/superops-team/hyperops/pkg/localexec"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/ssh"
 	"github.com/superops-team/hyperops/pkg/ops/util"
+	"github.com/superops-team/hyperops/pkg/sandbox"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
@@ -18,7 +21,7 @@ const ModuleName = "shell.star"
 var Module = &starlarkstruct.Module{
 	Name: "shell",
 	Members: starlark.StringDict{
-		"exec": starlark.NewBuiltin("shell.exec", Exec),
+		"exec": localctx.AddSideEffectBuiltin("shell.exec", Exec, DryRunStub),
 	},
 }
 
@@ -35,8 +38,46 @@ func ensureWorkdir(jobid string) string {
 	return workdir
 }
 
-// Exec run local command in starlark
-func Exec(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+// DryRunStub dry-run模式下命令不会执行，返回成功的空结果
+var DryRunStub = ssh.DryRunStub
+
+// hasHost 是否指定了host参数
+func hasHost(kwargs []starlark.Tuple) bool {
+	for _, kwarg := range kwargs {
+		if name, ok := starlark.AsString(kwarg[0]); ok && name == "host" {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteExec shell.exec(cmd, dir="", timeout=100, host="", insecure_ignore_host_key=False)通过ssh在远程主机执行
+func remoteExec(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		cmd, dir, host string
+		opts           ssh.Options
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "cmd", &cmd, "dir?", &dir, "timeout?", &opts.Timeout, "host", &host,
+		"insecure_ignore_host_key?", &opts.InsecureIgnoreHostKey); err != nil {
+		return starlark.None, err
+	}
+	res, err := ssh.Run(thread, host, cmd, dir, opts)
+	if err != nil {
+		return starlark.None, err
+	}
+	return ssh.ResultStruct(thread, res), nil
+}
+
+// Exec run local command in starlark, or on remote host with host kwarg
+func Exec(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	policy := sandbox.FromThread(thread)
+	if err := policy.CheckShell(hasHost(kwargs)); err != nil {
+		return starlark.None, err
+	}
+	if hasHost(kwargs) {
+		return remoteExec(thread, b, args, kwargs)
+	}
+	restricted := policy.ShellMode() == sandbox.ShellRestricted
 	params, err := util.GetParser(args, kwargs)
 	if err != nil {
 		return starlark.None, err
@@ -53,7 +94,9 @@ func Exec(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 	if err != nil {
 		dir, err = params.GetString(1)
 		if err != nil {
-			if len(thread.Name) != 0 {
+			if restricted {
+				dir = ""
+			} else if len(thread.Name) != 0 {
 				dir = ensureWorkdir(thread.Name)
 			} else {
 				dir = "./"
@@ -68,13 +111,21 @@ func Exec(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwa
 		}
 	}
 
-	response, err := localexec.ExecBatchCmdS(time.Duration(timeout)*time.Second, dir, cmd)
+	var response *localexec.Result
+	limits := localctx.LimitsFromThread(thread)
+	// job被kill或超时时结束正在执行的命令
+	ctx := localctx.JobContext(thread)
+	if restricted {
+		// 沙箱restricted模式下dir为相对于basedir的路径
+		response, err = localexec.ExecRestrictedBatchCmdSContext(ctx, time.Duration(timeout)*time.Second, policy.ShellBasedir(thread), dir, cmd, limits.ShellStdout())
+	} else {
+		response, err = localexec.ExecBatchCmdSContext(ctx, time.Duration(timeout)*time.Second, dir, cmd, limits.ShellStdout())
+	}
 	if response == nil && err != nil {
 		return starlark.None, err
 	}
-	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
-		"code":   starlark.MakeInt(int(response.Code)),
-		"stdout": starlark.String(response.Stdout),
-		"stderr": starlark.String(response.Stderr),
-	}), nil
+	if response.Truncated {
+		limits.Truncated()
+	}
+	return ssh.ResultStruct(thread, response), nil
 }
@@ -0,0 +1,370 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+	"github.com/superops-team/hyperops/pkg/localexec"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/sandbox"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v2"
+)
+
+const Name = "ssh"
+const ModuleName = "ssh.star"
+
+const (
+	// ConfigName ctx配置中ssh相关配置的key
+	ConfigName = "ssh"
+
+	defaultPort             = 22
+	defaultTimeout          = 100 // 秒, 与shell.exec一致
+	defaultConnectTimeout   = 10 * time.Second
+	defaultKeySecret        = "ssh_private_key"
+	defaultPasswordSecret   = "ssh_password"
+	defaultPassphraseSecret = "ssh_key_passphrase"
+)
+
+var (
+	// ErrNoCredential 没有可用的认证方式
+	ErrNoCredential = errors.New("no ssh credential found in ctx secrets")
+	// ErrNoContext 线程未绑定运行时上下文
+	ErrNoContext = errors.New("no hyperops context bound to the thread")
+	// ErrUnknownHost 主机公钥不在known_hosts中
+	ErrUnknownHost = errors.New("ssh host key is unknown")
+)
+
+var Module = &starlarkstruct.Module{
+	Name: "ssh",
+	Members: starlark.StringDict{
+		"exec": localctx.AddSideEffectBuiltin("ssh.exec", Exec, DryRunStub),
+	},
+}
+
+// Options 连接远程主机的参数, 可以在ctx配置中按主机单独设置
+//
+//	ssh:
+//	  user: deploy
+//	  key_secret: ssh_private_key
+//	  timeout: 60
+//	  hosts:
+//	    db1.example.com:
+//	      port: 2222
+//	      timeout: 600
+type Options struct {
+	User             string `yaml:"user"`
+	Port             int    `yaml:"port"`
+	Timeout          int    `yaml:"timeout"`           // 命令执行超时时间(秒), 包括建立连接的时间
+	KeySecret        string `yaml:"key_secret"`        // 保存私钥内容的secret名称
+	PasswordSecret   string `yaml:"password_secret"`   // 保存密码的secret名称
+	PassphraseSecret string `yaml:"passphrase_secret"` // 保存私钥密码的secret名称
+	KnownHosts       string `yaml:"known_hosts"`       // known_hosts文件路径, 默认$HOME/.ssh/known_hosts
+	// InsecureIgnoreHostKey 不校验主机公钥, 连接可能被中间人劫持, 只用于测试环境
+	InsecureIgnoreHostKey bool `yaml:"insecure_ignore_host_key"`
+}
+
+// Config ctx配置中的ssh配置
+type Config struct {
+	Options `yaml:",inline"`
+	Hosts   map[string]Options `yaml:"hosts"`
+}
+
+// merge 用other补全未设置的参数
+func (o *Options) merge(other Options) {
+	if o.User == "" {
+		o.User = other.User
+	}
+	if o.Port == 0 {
+		o.Port = other.Port
+	}
+	if o.Timeout == 0 {
+		o.Timeout = other.Timeout
+	}
+	if o.KeySecret == "" {
+		o.KeySecret = other.KeySecret
+	}
+	if o.PasswordSecret == "" {
+		o.PasswordSecret = other.PasswordSecret
+	}
+	if o.PassphraseSecret == "" {
+		o.PassphraseSecret = other.PassphraseSecret
+	}
+	if o.KnownHosts == "" {
+		o.KnownHosts = other.KnownHosts
+	}
+	if !o.InsecureIgnoreHostKey {
+		o.InsecureIgnoreHostKey = other.InsecureIgnoreHostKey
+	}
+}
+
+// loadConfig 读取ctx配置中的ssh配置
+func loadConfig(c *localctx.Context) (*Config, error) {
+	cfg := &Config{}
+	v, ok := c.Config(ConfigName)
+	if !ok || v == nil {
+		return cfg, nil
+	}
+	buf, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(buf, cfg); err != nil {
+		return nil, fmt.Errorf("invalid ssh config: %w", err)
+	}
+	return cfg, nil
+}
+
+// splitHost 解析user@host:port格式的主机地址
+func splitHost(addr string) (user, host string, port int, err error) {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		user, addr = addr[:i], addr[i+1:]
+	}
+	host = addr
+	if h, p, splitErr := net.SplitHostPort(addr); splitErr == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", "", 0, fmt.Errorf("invalid port in host %s", addr)
+		}
+	}
+	return user, host, port, nil
+}
+
+// resolve 依次使用调用参数, 主机配置, 全局配置和默认值确定连接参数
+func resolve(c *localctx.Context, addr string, opts Options) (string, Options, error) {
+	user, host, port, err := splitHost(addr)
+	if err != nil {
+		return "", opts, err
+	}
+	opts.merge(Options{User: user, Port: port})
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return "", opts, err
+	}
+	if hostOpts, ok := cfg.Hosts[addr]; ok {
+		opts.merge(hostOpts)
+	} else if hostOpts, ok := cfg.Hosts[host]; ok {
+		opts.merge(hostOpts)
+	}
+	opts.merge(cfg.Options)
+	opts.merge(Options{
+		User:             environment.NewEnvStorage().Get("USER"),
+		Port:             defaultPort,
+		Timeout:          defaultTimeout,
+		KeySecret:        defaultKeySecret,
+		PasswordSecret:   defaultPasswordSecret,
+		PassphraseSecret: defaultPassphraseSecret,
+	})
+	if opts.User == "" {
+		opts.User = "root"
+	}
+	return host, opts, nil
+}
+
+// authMethods 根据ctx中的secrets生成认证方式, 私钥优先
+func authMethods(c *localctx.Context, opts Options) ([]gossh.AuthMethod, error) {
+	var methods []gossh.AuthMethod
+	key, _, err := c.LookupSecret(opts.KeySecret)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		var signer gossh.Signer
+		passphrase, _, err := c.LookupSecret(opts.PassphraseSecret)
+		if err != nil {
+			return nil, err
+		}
+		if passphrase != "" {
+			signer, err = gossh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+		} else {
+			signer, err = gossh.ParsePrivateKey([]byte(key))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh private key in secret %s: %w", opts.KeySecret, err)
+		}
+		methods = append(methods, gossh.PublicKeys(signer))
+	}
+	password, _, err := c.LookupSecret(opts.PasswordSecret)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		methods = append(methods, gossh.Password(password))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("%w, set secret %s or %s", ErrNoCredential, opts.KeySecret, opts.PasswordSecret)
+	}
+	return methods, nil
+}
+
+// hostKeyCallback 使用known_hosts校验主机公钥, 未知主机拒绝连接
+func hostKeyCallback(opts Options) (gossh.HostKeyCallback, error) {
+	if opts.InsecureIgnoreHostKey {
+		return gossh.InsecureIgnoreHostKey(), nil
+	}
+	file := opts.KnownHosts
+	if file == "" {
+		file = filepath.Join(environment.NewEnvStorage().Get("HOME"), ".ssh", "known_hosts")
+	}
+	check, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts failed, set insecure_ignore_host_key to skip the host key check: %w", err)
+	}
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("%w: %s is not in %s", ErrUnknownHost, hostname, file)
+		}
+		return err
+	}, nil
+}
+
+// Run 在远程主机上执行命令, 超时后返回code为-1的结果
+func Run(thread *starlark.Thread, addr, cmd, dir string, opts Options) (*localexec.Result, error) {
+	// 远程命令与shell.exec(host=...)受同样的沙箱限制
+	if err := sandbox.FromThread(thread).CheckShell(true); err != nil {
+		return nil, err
+	}
+	c := localctx.FromThread(thread)
+	if c == nil {
+		return nil, ErrNoContext
+	}
+	host, opts, err := resolve(c, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := authMethods(c, opts)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := hostKeyCallback(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(opts.Timeout) * time.Second
+	connectTimeout := defaultConnectTimeout
+	if timeout < connectTimeout {
+		connectTimeout = timeout
+	}
+	deadline := time.Now().Add(timeout)
+	client, err := gossh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(opts.Port)), &gossh.ClientConfig{
+		User:            opts.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         connectTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s@%s:%d failed: %w", opts.User, host, opts.Port, err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	limits := localctx.LimitsFromThread(thread)
+	stdout := localexec.NewLimitedBuffer(limits.ShellStdout())
+	var stderr bytes.Buffer
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	if dir != "" {
+		cmd = fmt.Sprintf("cd %s && %s", quote(dir), cmd)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case err = <-done:
+	case <-localctx.JobContext(thread).Done():
+		// job被kill或超时时关闭连接结束远程命令
+		client.Close()
+		<-done
+		return nil, fmt.Errorf("ssh exec cancelled: %w", localctx.JobContext(thread).Err())
+	case <-time.After(time.Until(deadline)):
+		// 关闭连接结束远程命令, 与本地执行超时一样返回-1
+		client.Close()
+		<-done
+		stderr.WriteString(fmt.Sprintf("ssh exec timeout after %s", timeout))
+		if stdout.Truncated {
+			limits.Truncated()
+		}
+		return &localexec.Result{Code: -1, Stdout: stdout.String(), Stderr: stderr.String(), Truncated: stdout.Truncated}, nil
+	}
+
+	code := 0
+	if err != nil {
+		var exitErr *gossh.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		code = exitErr.ExitStatus()
+	}
+	if stdout.Truncated {
+		limits.Truncated()
+	}
+	return &localexec.Result{Code: code, Stdout: stdout.String(), Stderr: stderr.String(), Truncated: stdout.Truncated}, nil
+}
+
+// quote 使用单引号转义shell参数
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ResultStruct 转换为与shell.exec一致的返回结构, 输出中的密码会被替换
+func ResultStruct(thread *starlark.Thread, res *localexec.Result) *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"code":   starlark.MakeInt(res.Code),
+		"stdout": starlark.String(localctx.Redact(thread, res.Stdout)),
+		"stderr": starlark.String(localctx.Redact(thread, res.Stderr)),
+		// stdout超出资源限制被截断
+		"truncated": starlark.Bool(res.Truncated),
+	})
+}
+
+// DryRunStub dry-run模式下命令不会执行，返回成功的空结果
+func DryRunStub(thread *starlark.Thread, _ *starlark.Builtin, _ starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+	return ResultStruct(thread, &localexec.Result{}), nil
+}
+
+// Exec ssh.exec(host, cmd, dir="", user="", port=22, timeout=100, key_secret="", password_secret="", insecure_ignore_host_key=False)
+// host支持user@host:port格式, 认证信息从ctx secrets中读取, 主机公钥必须在known_hosts中
+func Exec(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		host, cmd, dir string
+		opts           Options
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"host", &host,
+		"cmd", &cmd,
+		"dir?", &dir,
+		"user?", &opts.User,
+		"port?", &opts.Port,
+		"timeout?", &opts.Timeout,
+		"key_secret?", &opts.KeySecret,
+		"password_secret?", &opts.PasswordSecret,
+		"insecure_ignore_host_key?", &opts.InsecureIgnoreHostKey,
+	); err != nil {
+		return starlark.None, err
+	}
+	res, err := Run(thread, host, cmd, dir, opts)
+	if err != nil {
+		return starlark.None, err
+	}
+	return ResultStruct(thread, res), nil
+}
@@ -0,0 +1,305 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	hmetrics "github.com/superops-team/hyperops/pkg/metrics"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"go.starlark.net/starlark"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testPassword = "s3cret"
+
+// testServer 进程内的ssh server, 使用本地sh执行exec请求
+type testServer struct {
+	addr     string
+	userKey  gossh.PublicKey
+	hostKey  gossh.PublicKey
+	listener net.Listener
+}
+
+func newTestServer(t *testing.T, userKey gossh.PublicKey) *testServer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := gossh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+			if conn.User() == "ops" && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, errors.New("invalid password")
+		},
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if userKey != nil && string(key.Marshal()) == string(userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testServer{addr: l.Addr().String(), userKey: userKey, hostKey: hostSigner.PublicKey(), listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn, config *gossh.ServerConfig) {
+	_, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(gossh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				_ = gossh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdout = ch
+				cmd.Stderr = ch.Stderr()
+				code := 0
+				if err := cmd.Run(); err != nil {
+					if exitErr, ok := err.(*exec.ExitError); ok {
+						code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+					}
+				}
+				_, _ = ch.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{uint32(code)}))
+				return
+			}
+		}()
+	}
+}
+
+// writeKnownHosts 写入addr对应主机公钥为key的known_hosts文件
+func writeKnownHosts(t *testing.T, addr string, key gossh.PublicKey) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err := ioutil.WriteFile(file, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// knownHosts 信任测试server公钥的ssh配置
+func (s *testServer) knownHosts(t *testing.T) map[string]interface{} {
+	return map[string]interface{}{
+		"ssh": map[interface{}]interface{}{"known_hosts": writeKnownHosts(t, s.addr, s.hostKey)},
+	}
+}
+
+func run(t *testing.T, script string, config map[string]interface{}, secrets map[string]string) (string, error) {
+	t.Helper()
+	var out strings.Builder
+	thread := &starlark.Thread{Print: func(_ *starlark.Thread, msg string) {
+		out.WriteString(msg + "\n")
+	}}
+	localctx.NewContext(config, secrets).Bind(thread)
+	_, err := starlark.ExecFile(thread, "ssh_test.star", script, starlark.StringDict{"ssh": Module})
+	return out.String(), err
+}
+
+func TestExecPassword(t *testing.T) {
+	s := newTestServer(t, nil)
+	out, err := run(t, `
+ret = ssh.exec("ops@`+s.addr+`", "echo hello; echo oops >&2; exit 3")
+print(ret.code, ret.stdout.strip(), ret.stderr.strip())
+ret2 = ssh.exec("ops@`+s.addr+`", "pwd", dir="/tmp")
+print(ret2.stdout.strip())
+`, s.knownHosts(t), map[string]string{"ssh_password": testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "3 hello oops\n/tmp\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	_, err = run(t, `ssh.exec("ops@`+s.addr+`", "true")`, s.knownHosts(t), map[string]string{"ssh_password": "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		t.Errorf("expected auth error, got %v", err)
+	}
+
+	_, err = run(t, `ssh.exec("ops@`+s.addr+`", "true")`, s.knownHosts(t), nil)
+	if !errors.Is(err, ErrNoCredential) {
+		t.Errorf("expected ErrNoCredential, got %v", err)
+	}
+}
+
+func TestExecPrivateKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	s := newTestServer(t, userKey)
+	host, p, _ := net.SplitHostPort(s.addr)
+	port, _ := strconv.Atoi(p)
+
+	// 端口和用户名从ctx配置中按主机读取, 私钥使用自定义的secret名称
+	config := map[string]interface{}{
+		"ssh": map[interface{}]interface{}{
+			"key_secret":  "deploy_key",
+			"known_hosts": writeKnownHosts(t, s.addr, s.hostKey),
+			"hosts": map[interface{}]interface{}{
+				host: map[interface{}]interface{}{"user": "deploy", "port": port},
+			},
+		},
+	}
+	out, err := run(t, `
+ret = ssh.exec("`+host+`", "whoami >/dev/null; echo ok")
+print(ret.code, ret.stdout.strip())
+`, config, map[string]string{"deploy_key": string(pem.EncodeToMemory(block))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "0 ok\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	s := newTestServer(t, nil)
+	out, err := run(t, `
+ret = ssh.exec("ops@`+s.addr+`", "sleep 5", timeout=1)
+print(ret.code, ret.stderr)
+`, s.knownHosts(t), map[string]string{"ssh_password": testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "-1 ssh exec timeout after 1s") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	// 超时前被截断的输出同样计入资源限制的metrics
+	thread := &starlark.Thread{Print: func(*starlark.Thread, string) {}}
+	localctx.NewContext(s.knownHosts(t), map[string]string{"ssh_password": testPassword}).Bind(thread)
+	limits := &localctx.Limits{MaxShellStdout: 4}
+	limits.Bind(thread, "ssh-timeout-job")
+	counter := hmetrics.LimitExceededCount.WithLabelValues("ssh-timeout-job", localctx.LimitShellStdout)
+	globals, err := starlark.ExecFile(thread, "ssh_test.star", `ret = ssh.exec("ops@`+s.addr+`", "echo truncated; sleep 5", timeout=1)`, starlark.StringDict{"ssh": Module})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret := globals["ret"].String(); !strings.Contains(ret, `stdout = "trun"`) || !strings.Contains(ret, "truncated = True") {
+		t.Errorf("expected truncated stdout, got %s", ret)
+	}
+	if got := testutil.ToFloat64(counter); got != 1 {
+		t.Errorf("expected 1 truncation counted, got %v", got)
+	}
+}
+
+func TestUnknownHost(t *testing.T) {
+	s := newTestServer(t, nil)
+	secrets := map[string]string{"ssh_password": testPassword}
+	// 默认使用$HOME/.ssh/known_hosts, 不存在时拒绝连接
+	t.Setenv("HOME", t.TempDir())
+	_, err := run(t, `ssh.exec("ops@`+s.addr+`", "true")`, nil, secrets)
+	if err == nil || !strings.Contains(err.Error(), "known_hosts") {
+		t.Errorf("expected missing known_hosts error, got %v", err)
+	}
+
+	// known_hosts中没有该主机
+	other := newTestServer(t, nil)
+	_, err = run(t, `ssh.exec("ops@`+s.addr+`", "true")`, other.knownHosts(t), secrets)
+	if err == nil || !strings.Contains(err.Error(), ErrUnknownHost.Error()) {
+		t.Errorf("expected unknown host error, got %v", err)
+	}
+
+	// 主机公钥与known_hosts中的不一致
+	config := map[string]interface{}{
+		"ssh": map[interface{}]interface{}{"known_hosts": writeKnownHosts(t, s.addr, other.hostKey)},
+	}
+	_, err = run(t, `ssh.exec("ops@`+s.addr+`", "true")`, config, secrets)
+	if err == nil || !strings.Contains(err.Error(), "key mismatch") {
+		t.Errorf("expected key mismatch error, got %v", err)
+	}
+
+	// 显式跳过校验
+	out, err := run(t, `print(ssh.exec("ops@`+s.addr+`", "echo ok", insecure_ignore_host_key=True).stdout.strip())`, nil, secrets)
+	if err != nil || out != "ok\n" {
+		t.Errorf("expected ok with insecure_ignore_host_key, got %q %v", out, err)
+	}
+	config = map[string]interface{}{"ssh": map[interface{}]interface{}{"insecure_ignore_host_key": true}}
+	if _, err := run(t, `ssh.exec("ops@`+s.addr+`", "true")`, config, secrets); err != nil {
+		t.Errorf("expected insecure_ignore_host_key in ctx config to skip the check, got %v", err)
+	}
+}
+
+func TestSplitHost(t *testing.T) {
+	cases := []struct {
+		addr, user, host string
+		port             int
+	}{
+		{"web1", "", "web1", 0},
+		{"root@web1", "root", "web1", 0},
+		{"root@web1:2222", "root", "web1", 2222},
+		{"[::1]:22", "", "::1", 22},
+	}
+	for _, c := range cases {
+		user, host, port, err := splitHost(c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != c.user || host != c.host || port != c.port {
+			t.Errorf("split %s: got %s %s %d", c.addr, user, host, port)
+		}
+	}
+}
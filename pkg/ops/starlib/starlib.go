@@ -10,13 +10,16 @@ import (
 	"github.com/superops-team/hyperops/pkg/ops/starlib/encoding/json"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/encoding/yaml"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/env"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/fleet"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/fs"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/group"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/hash"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/http"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/inventory"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/math"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/re"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/sh"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/ssh"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/sys"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/time"
 	"github.com/superops-team/hyperops/pkg/ops/starlib/tools"
@@ -58,6 +61,12 @@ func Loader(thread *starlark.Thread, module string) (dict starlark.StringDict, e
 		return starlark.StringDict{"group": group.Module}, nil
 	case sh.ModuleName:
 		return starlark.StringDict{"shell": sh.Module}, nil
+	case ssh.ModuleName:
+		return starlark.StringDict{"ssh": ssh.Module}, nil
+	case inventory.ModuleName:
+		return starlark.StringDict{"inventory": inventory.Module}, nil
+	case fleet.ModuleName:
+		return starlark.StringDict{"fleet": fleet.Module}, nil
 	case env.ModuleName:
 		return starlark.StringDict{"env": env.Module}, nil
 	case sys.ModuleName:
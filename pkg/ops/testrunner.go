@@ -0,0 +1,249 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/starlib/mock"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarktest"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// TestSuffix 测试脚本的文件名后缀
+	TestSuffix = "_test.ops"
+	// TestPrefix 测试函数名的前缀
+	TestPrefix = "test_"
+
+	// assertModule 测试模式下可以加载的断言模块
+	assertModule = "assert.star"
+)
+
+// testModuleLoader 测试模式下额外提供assert.star和mock.star, 其余模块交给fallback加载
+func testModuleLoader(fallback ModuleLoader) ModuleLoader {
+	return func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		switch module {
+		case assertModule:
+			return starlarktest.LoadAssertModule()
+		case mock.ModuleName:
+			return starlark.StringDict{mock.Name: mock.Module}, nil
+		}
+		return fallback(thread, module)
+	}
+}
+
+// TestCase 一个测试函数的执行结果
+type TestCase struct {
+	File     string
+	Name     string
+	Duration time.Duration
+	Output   string   // 测试执行过程中print的输出
+	Failures []string // assert失败的信息, 断言失败后测试继续执行
+	Err      error    // 执行错误, 测试在出错处结束
+}
+
+// Failed 测试是否失败
+func (c *TestCase) Failed() bool {
+	return c.Err != nil || len(c.Failures) > 0
+}
+
+// testReporter 收集assert.star报告的失败信息
+type testReporter struct {
+	sync.Mutex
+	failures []string
+}
+
+// Error 实现starlarktest.Reporter
+func (r *testReporter) Error(args ...interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.failures = append(r.failures, fmt.Sprint(args...))
+}
+
+// redactReporter 使用线程所属执行的密码替换报告的失败信息
+type redactReporter struct {
+	thread   *starlark.Thread
+	reporter starlarktest.Reporter
+}
+
+// Error 实现starlarktest.Reporter
+func (r *redactReporter) Error(args ...interface{}) {
+	r.reporter.Error(localctx.Redact(r.thread, fmt.Sprint(args...)))
+}
+
+// ListTests 静态解析测试脚本顶层定义的test_*函数, 按定义的顺序返回
+func ListTests(filename string, src []byte) ([]string, error) {
+	var content interface{}
+	if len(src) > 0 {
+		content = src
+	}
+	f, err := Dialect{}.Parse(filename, content)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, stmt := range f.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && strings.HasPrefix(def.Name.Name, TestPrefix) {
+			names = append(names, def.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// RunTests 依次执行测试脚本中名称匹配run的测试函数, run为nil时执行全部, 脚本无法解析时返回一个失败的结果
+func RunTests(ctx context.Context, target *Target, run *regexp.Regexp, opts ...func(o *ExecOpts)) []*TestCase {
+	names, err := ListTests(target.ScriptPath, target.ScriptContent)
+	if err != nil {
+		return []*TestCase{{File: target.ScriptPath, Name: filepath.Base(target.ScriptPath), Err: err}}
+	}
+	var cases []*TestCase
+	for _, name := range names {
+		if run != nil && !run.MatchString(name) {
+			continue
+		}
+		cases = append(cases, RunTest(ctx, target, name, opts...))
+	}
+	return cases
+}
+
+// RunTest 在独立的运行时中执行测试脚本后调用测试函数, 每个测试使用新的job, ctx, 模块缓存和checkpoint
+func RunTest(ctx context.Context, target *Target, name string, opts ...func(o *ExecOpts)) *TestCase {
+	jobID := "test-" + uuid.New().String()
+	reporter := &testReporter{}
+	output := &bytes.Buffer{}
+	opts = append(append([]func(o *ExecOpts){}, opts...),
+		SetOutputWriter(output),
+		SetEntrypoint(name),
+		SetTestReporter(reporter),
+		func(o *ExecOpts) {
+			locals := make(map[string]interface{}, len(o.Locals)+1)
+			for k, v := range o.Locals {
+				locals[k] = v
+			}
+			locals["job_id"] = jobID
+			o.Locals = locals
+		},
+	)
+	start := time.Now()
+	err := ExecScript(ctx, target, opts...)
+	c := &TestCase{
+		File:     target.ScriptPath,
+		Name:     name,
+		Duration: time.Since(start),
+		Output:   output.String(),
+		Err:      err,
+	}
+	reporter.Lock()
+	c.Failures = reporter.failures
+	reporter.Unlock()
+	return c
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+	duration time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit 按JUnit XML格式输出测试结果, 每个测试脚本为一个testsuite, 执行错误记为error, 断言失败记为failure
+func WriteJUnit(w io.Writer, cases []*TestCase) error {
+	report := &junitTestSuites{}
+	suites := map[string]*junitTestSuite{}
+	var total time.Duration
+	for _, c := range cases {
+		suite, ok := suites[c.File]
+		if !ok {
+			suite = &junitTestSuite{Name: c.File}
+			suites[c.File] = suite
+			report.Suites = append(report.Suites, suite)
+		}
+		tc := &junitTestCase{
+			Name:      c.Name,
+			Classname: c.File,
+			Time:      junitTime(c.Duration),
+			SystemOut: c.Output,
+		}
+		switch {
+		case c.Err != nil:
+			text := c.Err.Error()
+			if len(c.Failures) > 0 {
+				text = strings.Join(c.Failures, "\n") + "\n" + text
+			}
+			tc.Error = &junitMessage{Message: firstLine(c.Err.Error()), Text: text}
+			suite.Errors++
+			report.Errors++
+		case len(c.Failures) > 0:
+			tc.Failure = &junitMessage{Message: fmt.Sprintf("%d assertion(s) failed", len(c.Failures)), Text: strings.Join(c.Failures, "\n")}
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		suite.duration += c.Duration
+		report.Tests++
+		total += c.Duration
+	}
+	for _, suite := range report.Suites {
+		suite.Time = junitTime(suite.duration)
+	}
+	report.Time = junitTime(total)
+
+	buf, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(buf, '\n'))
+	return err
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package ops
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunTests(t *testing.T) {
+	root := t.TempDir()
+	script := filepath.Join(root, "common_test.ops")
+	content := `load("assert.star", "assert")
+
+def node_name(cluster, node):
+    return "%s-%s" % (cluster, node)
+
+def test_node_name():
+    print("checking", node_name("c1", "n1"))
+    ctx.set("seen", True)
+    assert.eq(node_name("c1", "n1"), "c1-n1")
+
+def test_isolated():
+    assert.fails(lambda: ctx.get("seen"), "not set")
+
+def test_assert_failed():
+    ctx.set_secret("token", "s3cr3t")
+    assert.eq("s3cr3t", "other")
+    assert.true(False, "second")
+
+def test_error():
+    fail("boom")
+
+def helper():
+    pass
+`
+	if err := ioutil.WriteFile(script, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ListTests(script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"test_node_name", "test_isolated", "test_assert_failed", "test_error"}
+	if !reflect.DeepEqual(names, expect) {
+		t.Fatalf("expected tests %v, got %v", expect, names)
+	}
+
+	target, err := NewTarget(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := RunTests(context.Background(), target, nil)
+	if len(cases) != 4 {
+		t.Fatalf("expected 4 cases, got %d", len(cases))
+	}
+	for _, c := range cases[:2] {
+		if c.Failed() {
+			t.Errorf("%s should pass, failures %v, err %v", c.Name, c.Failures, c.Err)
+		}
+	}
+	if cases[0].Output != "checking c1-n1\n" {
+		t.Errorf("unexpected output %q", cases[0].Output)
+	}
+	failed := cases[2]
+	if failed.Err != nil || len(failed.Failures) != 2 {
+		t.Fatalf("expected 2 assert failures, got %v, err %v", failed.Failures, failed.Err)
+	}
+	if strings.Contains(failed.Failures[0], "s3cr3t") {
+		t.Errorf("secret not redacted: %s", failed.Failures[0])
+	}
+	if !strings.Contains(failed.Failures[1], "second") {
+		t.Errorf("unexpected failure %s", failed.Failures[1])
+	}
+	if cases[3].Err == nil || !strings.Contains(cases[3].Err.Error(), "boom") {
+		t.Errorf("expected error boom, got %v", cases[3].Err)
+	}
+
+	filtered := RunTests(context.Background(), target, regexp.MustCompile("^test_node"))
+	if len(filtered) != 1 || filtered[0].Name != "test_node_name" {
+		t.Errorf("unexpected filtered cases %v", filtered)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, cases); err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 4 || report.Failures != 1 || report.Errors != 1 || len(report.Suites) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if tc := report.Suites[0].Cases[2]; tc.Failure == nil || tc.Failure.Message != "2 assertion(s) failed" {
+		t.Errorf("unexpected failure %+v", tc)
+	}
+}
+
+func TestRunTestsSyntaxError(t *testing.T) {
+	target := &Target{ScriptPath: "bad_test.ops", ScriptContent: []byte("def test_x(:\n")}
+	cases := RunTests(context.Background(), target, nil)
+	if len(cases) != 1 || cases[0].Name != "bad_test.ops" || cases[0].Err == nil {
+		t.Errorf("expected a failed case, got %v", cases)
+	}
+}
@@ -262,6 +262,18 @@ type Marshaler interface {
 	MarshalStarlark() (starlark.Value, error)
 }
 
+// Workdir 返回job工作目录的绝对路径, 不创建目录, 未设置PWD时使用进程的当前目录
+func Workdir(jobid string) (string, error) {
+	pwdpath := environment.NewEnvStorage().Get("PWD")
+	if len(pwdpath) == 0 {
+		var err error
+		if pwdpath, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	return path.Join(pwdpath, jobid), nil
+}
+
 func EnsureWorkdir(jobid string) string {
 	env := environment.NewEnvStorage()
 	pwdpath := env.Get("PWD")
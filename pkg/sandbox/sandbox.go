@@ -0,0 +1,314 @@
+// Package sandbox 按job限制脚本的能力: 可以加载的模块, shell执行方式, 可以访问的文件目录和网络主机
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	localctx "github.com/superops-team/hyperops/pkg/ops/context"
+	"github.com/superops-team/hyperops/pkg/ops/util"
+	"go.starlark.net/starlark"
+	"gopkg.in/yaml.v2"
+)
+
+// ShellMode shell的执行方式
+type ShellMode string
+
+const (
+	// ShellDeny 禁止执行shell命令, 默认值
+	ShellDeny ShellMode = "deny"
+	// ShellAllow 不限制shell命令
+	ShellAllow ShellMode = "allow"
+	// ShellRestricted 只能在basedir下执行, 命令中不能出现绝对路径和..
+	ShellRestricted ShellMode = "restricted"
+
+	// Wildcard 允许所有模块或主机
+	Wildcard = "*"
+	// WorkdirRoot fs.roots中表示job工作目录的占位符
+	WorkdirRoot = "$WORKDIR"
+)
+
+// ErrDenied 沙箱禁止的操作
+var ErrDenied = errors.New("denied by sandbox")
+
+// Shell shell相关限制
+type Shell struct {
+	Mode    ShellMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Basedir string    `yaml:"basedir,omitempty" json:"basedir,omitempty"` // restricted模式下命令的根目录, 默认为job工作目录
+}
+
+// FS fs模块相关限制
+type FS struct {
+	Roots []string `yaml:"roots,omitempty" json:"roots,omitempty"` // 允许访问的目录, 相对路径基于策略文件所在目录
+}
+
+// Hosts 允许访问的主机列表, 支持host, host:port, *.example.com和*
+type Hosts []string
+
+// Policy 沙箱策略, 未声明的能力默认禁止
+type Policy struct {
+	Name        string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Modules     []string `yaml:"modules,omitempty" json:"modules,omitempty"` // 允许load的内置模块, 支持通配符, 例如encoding/*.star
+	Shell       Shell    `yaml:"shell,omitempty" json:"shell,omitempty"`
+	FS          FS       `yaml:"fs,omitempty" json:"fs,omitempty"`
+	HTTP        Hosts    `yaml:"http,omitempty" json:"http,omitempty"`               // http模块允许访问的主机
+	CloudEvents Hosts    `yaml:"cloudevents,omitempty" json:"cloudevents,omitempty"` // cloudevents模块允许上报的主机
+}
+
+// Load 读取YAML格式的沙箱策略, 未指定name时使用文件名
+func Load(file string) (*Policy, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(buf, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sandbox %s: %w", file, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	return p, nil
+}
+
+// Parse 解析沙箱策略, fs.roots中的相对路径基于dir
+func Parse(buf []byte, dir string) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(buf, p); err != nil {
+		return nil, err
+	}
+	switch p.Shell.Mode {
+	case "":
+		p.Shell.Mode = ShellDeny
+	case ShellDeny, ShellAllow, ShellRestricted:
+	default:
+		return nil, fmt.Errorf("unknown shell mode %q, should be allow, deny or restricted", p.Shell.Mode)
+	}
+	for _, m := range p.Modules {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("invalid module pattern %q: %w", m, err)
+		}
+	}
+	for i, root := range p.FS.Roots {
+		if root != WorkdirRoot && !filepath.IsAbs(root) {
+			p.FS.Roots[i] = filepath.Join(dir, root)
+		}
+	}
+	if p.Shell.Basedir != "" && !filepath.IsAbs(p.Shell.Basedir) {
+		p.Shell.Basedir = filepath.Join(dir, p.Shell.Basedir)
+	}
+	return p, nil
+}
+
+// LoadDir 读取目录下所有.yaml/.yml沙箱策略, 按name索引
+func LoadDir(dir string) (map[string]*Policy, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	policies := map[string]*Policy{}
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		p, err := Load(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := policies[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate sandbox %s in %s", p.Name, dir)
+		}
+		policies[p.Name] = p
+	}
+	return policies, nil
+}
+
+// Names 策略名称列表
+func Names(policies map[string]*Policy) []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Bind 将沙箱策略绑定到线程, 子线程通过localctx.InheritLocals继承
+func Bind(thread *starlark.Thread, p *Policy) {
+	thread.SetLocal(localctx.SANDBOX_NAME, p)
+}
+
+// FromThread 获取线程绑定的沙箱策略, 未绑定时返回nil, 表示不限制
+func FromThread(thread *starlark.Thread) *Policy {
+	p, _ := thread.Local(localctx.SANDBOX_NAME).(*Policy)
+	return p
+}
+
+func (p *Policy) deny(format string, args ...interface{}) error {
+	return fmt.Errorf("%w %s: %s", ErrDenied, p.Name, fmt.Sprintf(format, args...))
+}
+
+// AllowModule 是否允许加载内置模块
+func (p *Policy) AllowModule(module string) error {
+	if p == nil {
+		return nil
+	}
+	for _, pattern := range p.Modules {
+		if pattern == Wildcard {
+			return nil
+		}
+		if ok, _ := path.Match(pattern, module); ok {
+			return nil
+		}
+	}
+	return p.deny("module %s is not allowed", module)
+}
+
+// ShellMode 当前策略的shell执行方式, 未设置沙箱时不限制
+func (p *Policy) ShellMode() ShellMode {
+	if p == nil {
+		return ShellAllow
+	}
+	return p.Shell.Mode
+}
+
+// ShellBasedir restricted模式下命令的根目录
+func (p *Policy) ShellBasedir(thread *starlark.Thread) string {
+	if p.Shell.Basedir != "" {
+		return p.Shell.Basedir
+	}
+	return util.EnsureWorkdir(thread.Name)
+}
+
+// CheckShell 检查是否允许执行shell命令, remote为通过ssh在远程主机执行
+func (p *Policy) CheckShell(remote bool) error {
+	switch p.ShellMode() {
+	case ShellAllow:
+		return nil
+	case ShellRestricted:
+		if !remote {
+			return nil
+		}
+		return p.deny("remote shell is not allowed in restricted mode")
+	}
+	return p.deny("shell is not allowed")
+}
+
+// CheckPath 检查文件路径是否在允许的目录下, 符号链接按实际路径检查
+func (p *Policy) CheckPath(thread *starlark.Thread, file string) error {
+	if p == nil {
+		return nil
+	}
+	real, err := realPath(file)
+	if err != nil {
+		return err
+	}
+	for _, root := range p.FS.Roots {
+		if root == WorkdirRoot {
+			root = util.EnsureWorkdir(thread.Name)
+		}
+		r, err := realPath(root)
+		if err != nil {
+			continue
+		}
+		if real == r || strings.HasPrefix(real, strings.TrimSuffix(r, string(filepath.Separator))+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return p.deny("path %s is outside of the fs roots", file)
+}
+
+// realPath 绝对路径并解析符号链接, 文件不存在时解析其已存在的上级目录
+func realPath(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for dir := abs; ; {
+		real, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// HTTPGuard http模块的RequestGuard
+func (p *Policy) HTTPGuard() *Guard {
+	return &Guard{policy: p, module: "http", hosts: p.HTTP}
+}
+
+// CloudEventsGuard cloudevents模块的RequestGuard
+func (p *Policy) CloudEventsGuard() *Guard {
+	return &Guard{policy: p, module: "cloudevents", hosts: p.CloudEvents}
+}
+
+// match 主机是否在列表中, 带端口的配置需要端口也一致
+func (h Hosts) match(u *url.URL) bool {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range h {
+		if allowed == Wildcard {
+			return true
+		}
+		name, p := strings.ToLower(allowed), ""
+		if n, ap, err := net.SplitHostPort(allowed); err == nil {
+			name, p = strings.ToLower(n), ap
+		}
+		if p != "" && p != port {
+			continue
+		}
+		if strings.HasPrefix(name, "*.") {
+			if strings.HasSuffix(host, name[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Guard 按主机列表限制请求, 实现http模块的RequestGuard
+type Guard struct {
+	policy *Policy
+	module string
+	hosts  Hosts
+}
+
+// Allowed 主机不在列表中时拒绝请求
+func (g *Guard) Allowed(req *http.Request) error {
+	if g.hosts.match(req.URL) {
+		return nil
+	}
+	return g.policy.deny("%s request to %s is not allowed", g.module, req.URL.Host)
+}
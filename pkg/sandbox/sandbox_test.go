@@ -0,0 +1,134 @@
+package sandbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+const policyYAML = `
+modules: ["encoding/*.star", "http.star", "fs.star"]
+shell:
+  mode: restricted
+  basedir: work
+fs:
+  roots: ["data", "$WORKDIR"]
+http: ["api.example.com", "*.internal.example.com:8443"]
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "team-a.yaml")
+	if err := ioutil.WriteFile(file, []byte(policyYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "team-a" || p.Shell.Mode != ShellRestricted || p.Shell.Basedir != filepath.Join(dir, "work") {
+		t.Errorf("unexpected policy %+v", p)
+	}
+	if p.FS.Roots[0] != filepath.Join(dir, "data") || p.FS.Roots[1] != WorkdirRoot {
+		t.Errorf("unexpected fs roots %v", p.FS.Roots)
+	}
+	policies, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(Names(policies), ",") != "team-a" {
+		t.Errorf("unexpected policies %v", Names(policies))
+	}
+
+	for src, expect := range map[string]string{
+		"shell: {mode: sudo}": "unknown shell mode",
+		"modules: ['[']":      "invalid module pattern",
+		"network: ['*']":      "not found",
+	} {
+		if _, err := Parse([]byte(src), dir); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected error %q, got %v", src, expect, err)
+		}
+	}
+	// 未声明的能力默认禁止
+	p, _ = Parse([]byte("name: empty"), dir)
+	if err := p.CheckShell(false); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected shell denied, got %v", err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse([]byte(policyYAML), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Name = "team-a"
+	for module, allowed := range map[string]bool{
+		"encoding/json.star": true,
+		"http.star":          true,
+		"shell.star":         false,
+		"ssh.star":           false,
+	} {
+		if err := p.AllowModule(module); (err == nil) != allowed {
+			t.Errorf("module %s: allowed=%v, got %v", module, allowed, err)
+		}
+	}
+	if err := p.CheckShell(true); err == nil || !strings.Contains(err.Error(), "denied by sandbox team-a: remote shell") {
+		t.Errorf("expected remote shell denied, got %v", err)
+	}
+	var none *Policy
+	if none.AllowModule("shell.star") != nil || none.CheckShell(true) != nil || none.CheckPath(nil, "/etc/passwd") != nil {
+		t.Error("nil policy should not limit anything")
+	}
+
+	for rawurl, allowed := range map[string]bool{
+		"https://api.example.com/v1":             true,
+		"http://API.example.com:80/v1":           true,
+		"https://a.internal.example.com:8443/x":  true,
+		"https://a.internal.example.com/x":       false,
+		"https://api.example.com.evil.com/":      false,
+		"http://169.254.169.254/latest/metadata": false,
+	} {
+		req, _ := http.NewRequest("GET", rawurl, nil)
+		if err := p.HTTPGuard().Allowed(req); (err == nil) != allowed {
+			t.Errorf("%s: allowed=%v, got %v", rawurl, allowed, err)
+		}
+	}
+	req, _ := http.NewRequest("POST", "https://api.example.com", nil)
+	if err := p.CloudEventsGuard().Allowed(req); !errors.Is(err, ErrDenied) {
+		t.Errorf("expected cloudevents denied, got %v", err)
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	dir := t.TempDir()
+	p, err := Parse([]byte(policyYAML), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(dir, "data")
+	if err := os.MkdirAll(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(data, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	thread := &starlark.Thread{Name: "sandbox_job"}
+	for file, allowed := range map[string]bool{
+		data:                                  true,
+		filepath.Join(data, "new/report.txt"): true,
+		filepath.Join(data, "../data2/x"):     false,
+		filepath.Join(data, "etc/passwd"):     false,
+		filepath.Join(dir, "other.txt"):       false,
+		"/etc/passwd":                         false,
+	} {
+		if err := p.CheckPath(thread, file); (err == nil) != allowed {
+			t.Errorf("%s: allowed=%v, got %v", file, allowed, err)
+		}
+	}
+}
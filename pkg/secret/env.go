@@ -0,0 +1,34 @@
+package secret
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+)
+
+// Env 从带前缀的环境变量读取密码
+// secret://env/db#password读取<Prefix>DB_PASSWORD, 名称转为大写, /和-替换为_
+type Env struct {
+	Prefix  string
+	Storage environment.EnvStorage
+}
+
+// NewEnv 创建环境变量密码后端
+func NewEnv(prefix string) *Env {
+	return &Env{Prefix: prefix, Storage: environment.NewEnvStorage()}
+}
+
+// Get 读取密码, 未设置或为空时返回ErrNotFound
+func (e *Env) Get(path, key string) (string, error) {
+	name := path
+	if key != "" {
+		name += "_" + key
+	}
+	name = e.Prefix + strings.NewReplacer("/", "_", "-", "_").Replace(strings.ToUpper(name))
+	v := e.Storage.Get(name)
+	if v == "" {
+		return "", fmt.Errorf("%w: environment variable %s", ErrNotFound, name)
+	}
+	return v, nil
+}
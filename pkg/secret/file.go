@@ -0,0 +1,88 @@
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fireworkweb/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+// File 从dotenv或YAML文件读取密码, 文件在第一次读取时加载
+// YAML中的嵌套字段使用/连接, secret://file/db#password读取db.password, dotenv文件使用secret://file/DB_PASSWORD
+type File struct {
+	Path string
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+// NewFile 创建文件密码后端, .yaml/.yml按YAML解析, 其余按dotenv解析
+func NewFile(path string) *File {
+	return &File{Path: path}
+}
+
+// Get 读取密码, key不为空时与path使用/连接
+func (f *File) Get(path, key string) (string, error) {
+	f.once.Do(f.load)
+	if f.err != nil {
+		return "", f.err
+	}
+	name := path
+	if key != "" {
+		name += "/" + key
+	}
+	v, ok := f.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s in %s", ErrNotFound, name, f.Path)
+	}
+	return v, nil
+}
+
+func (f *File) load() {
+	ext := strings.ToLower(filepath.Ext(f.Path))
+	if ext != ".yaml" && ext != ".yml" {
+		f.secrets, f.err = godotenv.Read(f.Path)
+		return
+	}
+	buf, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		f.err = err
+		return
+	}
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(buf, &data); err != nil {
+		f.err = fmt.Errorf("invalid secret file %s: %w", f.Path, err)
+		return
+	}
+	f.secrets = make(map[string]string)
+	flatten("", data, f.secrets)
+}
+
+// flatten 将嵌套的YAML字段展开为/连接的名称
+func flatten(prefix string, v interface{}, out map[string]string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			flatten(join(prefix, k), item, out)
+		}
+	case map[interface{}]interface{}:
+		for k, item := range val {
+			flatten(join(prefix, fmt.Sprint(k)), item, out)
+		}
+	case nil:
+	default:
+		out[prefix] = fmt.Sprint(val)
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
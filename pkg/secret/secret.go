@@ -0,0 +1,76 @@
+// Package secret 从外部密码后端读取脚本使用的密码, ctx配置中通过secret://<provider>/<path>#<key>引用
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Scheme 密码引用的前缀
+const Scheme = "secret://"
+
+// ErrNotFound 密码不存在
+var ErrNotFound = errors.New("secret not found")
+
+// Provider 密码后端, path和key的含义由后端决定
+type Provider interface {
+	Get(path, key string) (string, error)
+}
+
+// Ref 密码引用, 例如secret://vault/kv/db#password
+type Ref struct {
+	Provider string
+	Path     string
+	Key      string
+}
+
+func (r *Ref) String() string {
+	s := Scheme + r.Provider + "/" + r.Path
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// IsRef 字符串是否为密码引用
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, Scheme)
+}
+
+// ParseRef 解析密码引用
+func ParseRef(s string) (*Ref, error) {
+	if !IsRef(s) {
+		return nil, fmt.Errorf("invalid secret reference %q, should start with %s", s, Scheme)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret reference %q: %w", s, err)
+	}
+	ref := &Ref{Provider: u.Host, Path: strings.Trim(u.Path, "/"), Key: u.Fragment}
+	if ref.Provider == "" || ref.Path == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, should be %s<provider>/<path>#<key>", s, Scheme)
+	}
+	return ref, nil
+}
+
+// Providers 按名称索引的密码后端
+type Providers map[string]Provider
+
+// Resolve 解析密码引用并从对应的后端读取密码
+func (p Providers) Resolve(s string) (string, error) {
+	ref, err := ParseRef(s)
+	if err != nil {
+		return "", err
+	}
+	provider, ok := p[ref.Provider]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q in %s", ref.Provider, ref)
+	}
+	v, err := provider.Get(ref.Path, ref.Key)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,121 @@
+package secret
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/superops-team/hyperops/pkg/environment"
+)
+
+func TestParseRef(t *testing.T) {
+	ref, err := ParseRef("secret://vault/kv/db#password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Provider != "vault" || ref.Path != "kv/db" || ref.Key != "password" || ref.String() != "secret://vault/kv/db#password" {
+		t.Errorf("unexpected ref %+v", ref)
+	}
+	for _, s := range []string{"vault/kv/db", "secret://vault", "secret:///kv/db#password"} {
+		if _, err := ParseRef(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+	if _, err := (Providers{}).Resolve("secret://none/db#password"); err == nil || !strings.Contains(err.Error(), "unknown secret provider") {
+		t.Errorf("expected unknown provider, got %v", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, "secrets.env")
+	yml := filepath.Join(dir, "secrets.yaml")
+	if err := ioutil.WriteFile(env, []byte("# comment\nDB_PASSWORD=\"env-pass\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(yml, []byte("db:\n  password: yaml-pass\n  port: 5432\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	providers := Providers{"env_file": NewFile(env), "file": NewFile(yml)}
+	for ref, expect := range map[string]string{
+		"secret://env_file/DB_PASSWORD": "env-pass",
+		"secret://file/db#password":     "yaml-pass",
+		"secret://file/db/port":         "5432",
+	} {
+		if v, err := providers.Resolve(ref); err != nil || v != expect {
+			t.Errorf("%s: expected %s, got %s %v", ref, expect, v, err)
+		}
+	}
+	if _, err := providers.Resolve("secret://file/db#user"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	storage := environment.NewFakeEnvStorage()
+	storage.Set("HYPEROPS_SECRET_DB_PASSWORD", "env-pass")
+	e := &Env{Prefix: "HYPEROPS_SECRET_", Storage: storage}
+	for _, ref := range [][2]string{{"db", "password"}, {"DB_PASSWORD", ""}, {"db/password", ""}} {
+		if v, err := e.Get(ref[0], ref[1]); err != nil || v != "env-pass" {
+			t.Errorf("%v: unexpected %s %v", ref, v, err)
+		}
+	}
+	if _, err := e.Get("db", "user"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+// newVault 模拟Vault KV接口的本地服务
+func newVault(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/db":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"vault-pass","port":5432},"metadata":{"version":3}}}`))
+		case "/v1/secret/db":
+			_, _ = w.Write([]byte(`{"data":{"password":"kv1-pass"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVault(t *testing.T) {
+	srv := newVault(t)
+	providers := Providers{
+		"vault":   NewVault(srv.URL, "root-token", 2),
+		"vault1":  NewVault(srv.URL, "root-token", 1),
+		"nobody":  NewVault(srv.URL, "bad-token", 2),
+		"missing": NewVault(srv.URL+"/", "root-token", 0),
+	}
+	for ref, expect := range map[string]string{
+		"secret://vault/kv/db#password":      "vault-pass",
+		"secret://vault/kv/db#port":          "5432",
+		"secret://vault1/secret/db#password": "kv1-pass",
+	} {
+		if v, err := providers.Resolve(ref); err != nil || v != expect {
+			t.Errorf("%s: expected %s, got %s %v", ref, expect, v, err)
+		}
+	}
+	for ref, expect := range map[string]string{
+		"secret://vault/kv/db#user":      "secret not found",
+		"secret://missing/kv/app#token":  "secret not found",
+		"secret://vault/kv/db":           "should specify the field",
+		"secret://nobody/kv/db#password": "permission denied",
+	} {
+		if _, err := providers.Resolve(ref); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected error %q, got %v", ref, expect, err)
+		}
+	}
+}
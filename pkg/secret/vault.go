@@ -0,0 +1,86 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault 通过HTTP API读取HashiCorp Vault KV中的密码
+// secret://vault/kv/db#password中kv为挂载点, db为密码路径, password为字段
+type Vault struct {
+	Addr      string
+	Token     string
+	KVVersion int // KV引擎版本, 1或2, 默认为2
+	Client    *http.Client
+}
+
+// NewVault 创建Vault密码后端
+func NewVault(addr, token string, kvVersion int) *Vault {
+	return &Vault{
+		Addr:      strings.TrimSuffix(addr, "/"),
+		Token:     token,
+		KVVersion: kvVersion,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// vaultResponse KV读取接口的返回值, v2的字段在data.data中
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// Get 读取path下的key字段
+func (v *Vault) Get(path, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("vault secret %s should specify the field with #<key>", path)
+	}
+	url := v.Addr + "/v1/" + path
+	if v.KVVersion != 1 {
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("vault secret %s should be <mount>/<path>", path)
+		}
+		url = v.Addr + "/v1/" + parts[0] + "/data/" + parts[1]
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var res vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: vault %s", ErrNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault %s returned %s: %s", path, resp.Status, strings.Join(res.Errors, "; "))
+	}
+	data := res.Data
+	if v.KVVersion != 1 {
+		data, _ = data["data"].(map[string]interface{})
+	}
+	value, ok := data[key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("%w: vault %s#%s", ErrNotFound, path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}